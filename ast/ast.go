@@ -1,6 +1,10 @@
 package ast
 
-import "github.com/notrealandy/tox/token"
+import (
+	"fmt"
+
+	"github.com/notrealandy/tox/token"
+)
 
 type Statement interface{}
 
@@ -10,10 +14,21 @@ type CImportStatement struct {
 
 // StructStatement represents a struct declaration (e.g. struct User >> { name: string, age: int }).
 type StructStatement struct {
-	Name   string        // Name of the struct (e.g. "User")
-	Fields []StructField // List of field declarations
-	Line   int
-	Col    int
+	Name       string        // Name of the struct (e.g. "User")
+	Fields     []StructField // List of field declarations
+	Visibility string        // "pub" (public) or "" (private by default)
+	Line       int
+	Col        int
+}
+
+// TypeAliasStatement declares another name for an existing type
+// (e.g. type UserId >> int). The alias and the type are interchangeable.
+type TypeAliasStatement struct {
+	Name       string // Name of the alias (e.g. "UserId")
+	Type       string // Aliased type, which may itself use aliases
+	Visibility string // "pub" (public) or "" (private by default)
+	Line       int
+	Col        int
 }
 
 // StructField represents a single field in a struct declaration.
@@ -36,6 +51,7 @@ type LetStatement struct {
 	Type       string     // type as declared
 	Value      Expression // the value assigned
 	Visibility string     // "pub" (public) or "" (private by default)
+	Const      bool       // declared with const: cannot be reassigned
 	Line       int
 	Col        int
 }
@@ -44,10 +60,14 @@ type FunctionStatement struct {
 	Name         string // function name
 	Params       []string
 	ParamTypes   []string
+	Defaults     []Expression // default value of each parameter, nil if it has none; nil when none do
+	Variadic     bool         // the last parameter, an array, collects the remaining arguments
 	Body         []Statement
 	ReturnType   string
 	Visibility   string // "pub" (public) or "" (private by default)
 	ReceiverType string
+	Pure         bool // declared with @pure: no side effects, calls may be folded
+	Override     bool // declared with @override: replaces the builtin named Name
 	Line         int
 	Col          int
 }
@@ -64,6 +84,14 @@ type ReturnStatement struct {
 	Col   int
 }
 
+// AssertStatement represents an invariant check (e.g. assert x > 0, "x must be positive").
+type AssertStatement struct {
+	Cond    Expression // condition that must hold
+	Message Expression // optional message, nil if omitted
+	Line    int
+	Col     int
+}
+
 type IfStatement struct {
 	IfCond     Expression    // condition for the if
 	IfBody     []Statement   // body for the if
@@ -74,12 +102,25 @@ type IfStatement struct {
 	Col        int
 }
 
+// MatchStatement runs the body of the first case whose value equals Subject,
+// or Default when none does, e.g. match x { case 1 >> { ... } default >> { ... } }.
+// Cases don't fall through.
+type MatchStatement struct {
+	Subject Expression    // evaluated once
+	Cases   []Expression  // value of each case
+	Bodies  [][]Statement // body of each case
+	Default []Statement   // body of default, nil if there is none
+	Line    int
+	Col     int
+}
+
 type AssignmentStatement struct {
-	Name  string
-	Left  Expression
-	Value Expression
-	Line  int
-	Col   int
+	Name     string
+	Left     Expression
+	Value    Expression
+	Compound token.TokenType // operator of a compound assignment (PLUS for x += 1, whose Value is x + 1), "" for >>
+	Line     int
+	Col      int
 }
 
 type WhileStatement struct {
@@ -89,6 +130,16 @@ type WhileStatement struct {
 	Col       int
 }
 
+// WithStatement binds a resource for the duration of its body and closes it
+// when the body exits, e.g. with f >> go.file.open("a.txt") { ... }.
+type WithStatement struct {
+	Name  string     // name the resource is bound to inside Body
+	Value Expression // call that opens the resource
+	Body  []Statement
+	Line  int
+	Col   int
+}
+
 type ForStatement struct {
 	Init      Statement  // e.g. let i int >> 0 or i >> 0
 	Condition Expression // e.g. i < 10
@@ -98,6 +149,17 @@ type ForStatement struct {
 	Col       int
 }
 
+// WhenStatement is a top-level declaration guarded by a build condition, e.g.
+// @when(os == "windows") fnc clear() >> void { ... }. The loader keeps Decl
+// only on a matching platform and drops it otherwise.
+type WhenStatement struct {
+	Key   string    // "os" or "arch"
+	Value string    // e.g. "windows", "amd64"
+	Decl  Statement // the guarded declaration
+	Line  int
+	Col   int
+}
+
 type PackageStatement struct {
 	Name string
 }
@@ -108,11 +170,15 @@ type ImportStatement struct {
 
 type ArrayLiteral struct {
 	Elements []Expression
+	Line     int
+	Col      int
 }
 
 type IndexExpression struct {
 	Left  Expression
 	Index Expression
+	Line  int // position of the '['
+	Col   int
 }
 
 type Identifier struct {
@@ -125,6 +191,60 @@ type Identifier struct {
 type CallExpression struct {
 	Function  Expression
 	Arguments []Expression
+	ArgNames  []string // parameter name of each argument, "" if positional; nil when none are named
+	Line      int      // position of the callee
+	Col       int
+}
+
+// ArgumentsFor returns the call's arguments in parameter order, matching named
+// arguments (greet(name: "Andy")) to the parameter of the same name. Omitted
+// parameters that have a default get the default expression. Calls without
+// named arguments are otherwise returned as is; checking their count is up to
+// the caller.
+func (ce *CallExpression) ArgumentsFor(params []string, defaults []Expression) ([]Expression, error) {
+	if ce.ArgNames == nil {
+		args := ce.Arguments
+		if len(args) < len(params) && len(args) < len(defaults) && defaults[len(args)] != nil {
+			args = append([]Expression{}, args...)
+			for i := len(args); i < len(params) && i < len(defaults) && defaults[i] != nil; i++ {
+				args = append(args, defaults[i])
+			}
+		}
+		return args, nil
+	}
+	ordered := make([]Expression, len(params))
+	for i, arg := range ce.Arguments {
+		name := ce.ArgNames[i]
+		if name == "" {
+			if i >= len(params) {
+				return nil, fmt.Errorf("too many arguments")
+			}
+			ordered[i] = arg
+			continue
+		}
+		idx := -1
+		for j, param := range params {
+			if param == name {
+				idx = j
+				break
+			}
+		}
+		if idx == -1 {
+			return nil, fmt.Errorf("unknown parameter '%s'", name)
+		}
+		if ordered[idx] != nil {
+			return nil, fmt.Errorf("parameter '%s' is given more than once", name)
+		}
+		ordered[idx] = arg
+	}
+	for j, arg := range ordered {
+		if arg == nil && j < len(defaults) && defaults[j] != nil {
+			ordered[j] = defaults[j]
+		} else if arg == nil {
+			return nil, fmt.Errorf("missing argument for parameter '%s'", params[j])
+		}
+	}
+	return ordered, nil
 }
 
 type ExpressionStatement struct {
@@ -137,6 +257,9 @@ type SliceExpression struct {
 	Left  Expression
 	Start Expression // can be nil
 	End   Expression // can be nil
+	Step  Expression // can be nil (defaults to 1), negative steps walk backwards
+	Line  int
+	Col   int
 }
 
 type UnaryExpression struct {
@@ -150,11 +273,34 @@ type MapLiteral struct {
 	KeyType   string
 	ValueType string
 	Pairs     map[Expression]Expression
+	Spreads   []Expression // maps spread into the literal (...defaults), applied before Pairs
 	Line      int
 	Col       int
 }
 
-type NilLiteral struct{}
+// FunctionLiteral is an anonymous function used as a value, e.g.
+// Config { onDone: fnc() >> void { log("done") } }. Its value is a closure
+// over the scope it is evaluated in; values of type fnc hold one.
+type FunctionLiteral struct {
+	Fn   *FunctionStatement // Name is empty
+	Line int
+	Col  int
+}
+
+// TernaryExpression is a conditional expression, e.g. x > 0 ? "pos" : "neg".
+// Only the branch that is taken is evaluated.
+type TernaryExpression struct {
+	Cond Expression
+	Then Expression
+	Else Expression
+	Line int
+	Col  int
+}
+
+type NilLiteral struct {
+	Line int
+	Col  int
+}
 
 type Expression interface {
 	expressionNode()
@@ -171,16 +317,30 @@ type BinaryExpression struct {
 // Define type check string value
 type StringLiteral struct {
 	Value string
+	Raw   bool // `raw` literal, its <%...%> placeholders are not interpolated
+	Line  int
+	Col   int
 }
 
 // Define type check int value
 type IntegerLiteral struct {
 	Value int64
+	Line  int
+	Col   int
+}
+
+// Define type check float value
+type FloatLiteral struct {
+	Value float64
+	Line  int
+	Col   int
 }
 
 // Define type check bool value
 type BoolLiteral struct {
 	Value bool
+	Line  int
+	Col   int
 }
 
 type BreakStatement struct {
@@ -195,24 +355,31 @@ type ContinueStatement struct {
 
 func (lf *LogFunction) statementNode()         {}
 func (rs *ReturnStatement) statementNode()     {}
+func (as *AssertStatement) statementNode()     {}
 func (es *ExpressionStatement) statementNode() {}
 func (is *IfStatement) statementNode()         {}
+func (ms *MatchStatement) statementNode()      {}
 func (as *AssignmentStatement) statementNode() {}
 func (ws *WhileStatement) statementNode()      {}
 func (fs *ForStatement) statementNode()        {}
 func (bs *BreakStatement) statementNode()      {}
+func (ws *WhenStatement) statementNode()       {}
+func (ws *WithStatement) statementNode()       {}
 func (cs *ContinueStatement) statementNode()   {}
 
-func (id *Identifier) expressionNode()       {}
-func (il *IntegerLiteral) expressionNode()   {}
-func (sl *StringLiteral) expressionNode()    {}
-func (bl *BoolLiteral) expressionNode()      {}
-func (be *BinaryExpression) expressionNode() {}
-func (nl *NilLiteral) expressionNode()       {}
-func (ce *CallExpression) expressionNode()   {}
-func (ue *UnaryExpression) expressionNode()  {}
-func (al *ArrayLiteral) expressionNode()     {}
-func (ie *IndexExpression) expressionNode()  {}
-func (se *SliceExpression) expressionNode()  {}
-func (sl *StructLiteral) expressionNode()    {}
-func (ml *MapLiteral) expressionNode()       {}
+func (id *Identifier) expressionNode()        {}
+func (il *IntegerLiteral) expressionNode()    {}
+func (fl *FloatLiteral) expressionNode()      {}
+func (sl *StringLiteral) expressionNode()     {}
+func (bl *BoolLiteral) expressionNode()       {}
+func (be *BinaryExpression) expressionNode()  {}
+func (nl *NilLiteral) expressionNode()        {}
+func (ce *CallExpression) expressionNode()    {}
+func (ue *UnaryExpression) expressionNode()   {}
+func (al *ArrayLiteral) expressionNode()      {}
+func (ie *IndexExpression) expressionNode()   {}
+func (se *SliceExpression) expressionNode()   {}
+func (sl *StructLiteral) expressionNode()     {}
+func (ml *MapLiteral) expressionNode()        {}
+func (fl *FunctionLiteral) expressionNode()   {}
+func (te *TernaryExpression) expressionNode() {}
@@ -0,0 +1,342 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// JSON schema for external tools
+//
+// Every node is encoded as an object holding its exported fields under their Go
+// names, plus a "node" discriminator naming its type:
+//
+//	{"node": "IntegerLiteral", "Value": 3}
+//
+// Statement and Expression fields hold such objects (or null), so programs
+// round-trip through MarshalProgram and UnmarshalProgram with positions intact.
+// MapLiteral.Pairs, whose keys are expressions, is encoded as a list of
+// {"Key": ..., "Value": ...} objects.
+
+// nodeTypes maps the discriminator of each node type to its Go type.
+var nodeTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, node := range []interface{}{
+		&CImportStatement{}, &StructStatement{}, &StructLiteral{}, &LetStatement{},
+		&FunctionStatement{}, &LogFunction{}, &ReturnStatement{}, &AssertStatement{},
+		&IfStatement{}, &AssignmentStatement{}, &WhileStatement{}, &ForStatement{},
+		&PackageStatement{}, &ImportStatement{}, &ArrayLiteral{}, &IndexExpression{},
+		&Identifier{}, &CallExpression{}, &ExpressionStatement{}, &SliceExpression{},
+		&UnaryExpression{}, &MapLiteral{}, &NilLiteral{}, &BinaryExpression{},
+		&StringLiteral{}, &IntegerLiteral{}, &FloatLiteral{}, &BoolLiteral{},
+		&BreakStatement{}, &ContinueStatement{}, &WhenStatement{},
+		&WithStatement{}, &FunctionLiteral{}, &TernaryExpression{},
+		&MatchStatement{}, &TypeAliasStatement{},
+	} {
+		t := reflect.TypeOf(node).Elem()
+		nodeTypes[t.Name()] = t
+	}
+}
+
+// MarshalProgram encodes a list of statements as a JSON array of nodes.
+func MarshalProgram(stmts []Statement) ([]byte, error) {
+	encoded, err := encodeValue(reflect.ValueOf(stmts))
+	if err != nil {
+		return nil, fmt.Errorf("ast: %v", err)
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalProgram decodes a JSON array produced by MarshalProgram.
+func UnmarshalProgram(data []byte) ([]Statement, error) {
+	raw, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var stmts []Statement
+	if err := decodeValue(raw, reflect.ValueOf(&stmts).Elem()); err != nil {
+		return nil, fmt.Errorf("ast: %v", err)
+	}
+	return stmts, nil
+}
+
+// MarshalNode encodes a single statement or expression.
+func MarshalNode(node interface{}) ([]byte, error) {
+	encoded, err := encodeValue(reflect.ValueOf(node))
+	if err != nil {
+		return nil, fmt.Errorf("ast: %v", err)
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalNode decodes a single node produced by MarshalNode.
+func UnmarshalNode(data []byte) (interface{}, error) {
+	raw, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	node, err := decodeNode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("ast: %v", err)
+	}
+	return node.Interface(), nil
+}
+
+// decodeJSON parses data keeping numbers exact, so large int literals survive.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func encodeValue(v reflect.Value) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return encodeValue(v.Elem())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+		name := v.Elem().Type().Name()
+		if nodeTypes[name] != v.Elem().Type() {
+			return nil, fmt.Errorf("cannot encode %s: not a node type", v.Type())
+		}
+		obj, err := encodeFields(v.Elem())
+		if err != nil {
+			return nil, err
+		}
+		obj["node"] = name
+		return obj, nil
+	case reflect.Struct:
+		// Plain value structs such as StructField carry no discriminator
+		return encodeFields(v)
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		arr := make([]interface{}, v.Len())
+		for i := range arr {
+			elem, err := encodeValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = elem
+		}
+		return arr, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Key().Kind() == reflect.String {
+			obj := map[string]interface{}{}
+			for _, key := range v.MapKeys() {
+				val, err := encodeValue(v.MapIndex(key))
+				if err != nil {
+					return nil, err
+				}
+				obj[key.String()] = val
+			}
+			return obj, nil
+		}
+		return encodePairs(v)
+	default:
+		return v.Interface(), nil
+	}
+}
+
+func encodeFields(v reflect.Value) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	for i := 0; i < v.NumField(); i++ {
+		val, err := encodeValue(v.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		obj[v.Type().Field(i).Name] = val
+	}
+	return obj, nil
+}
+
+// encodePairs encodes a map with expression keys as a list of key/value
+// objects, sorted so the same program always produces the same JSON.
+func encodePairs(v reflect.Value) (interface{}, error) {
+	type pair struct {
+		sortKey string
+		obj     map[string]interface{}
+	}
+	pairs := []pair{}
+	for _, key := range v.MapKeys() {
+		k, err := encodeValue(key)
+		if err != nil {
+			return nil, err
+		}
+		val, err := encodeValue(v.MapIndex(key))
+		if err != nil {
+			return nil, err
+		}
+		sortKey, _ := json.Marshal(k)
+		pairs = append(pairs, pair{string(sortKey), map[string]interface{}{"Key": k, "Value": val}})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].sortKey < pairs[j].sortKey })
+	arr := make([]interface{}, len(pairs))
+	for i, p := range pairs {
+		arr[i] = p.obj
+	}
+	return arr, nil
+}
+
+// decodeNode builds the node described by raw, returning a pointer to it.
+func decodeNode(raw interface{}) (reflect.Value, error) {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("expected node object, got %T", raw)
+	}
+	name, _ := obj["node"].(string)
+	t, ok := nodeTypes[name]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("unknown node type %q", name)
+	}
+	node := reflect.New(t)
+	if err := decodeFields(obj, node.Elem()); err != nil {
+		return reflect.Value{}, fmt.Errorf("%s.%v", name, err)
+	}
+	return node, nil
+}
+
+func decodeFields(obj map[string]interface{}, target reflect.Value) error {
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		if raw, ok := obj[field.Name]; ok {
+			if err := decodeValue(raw, target.Field(i)); err != nil {
+				return fmt.Errorf("%s: %v", field.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func decodeValue(raw interface{}, target reflect.Value) error {
+	switch target.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if raw == nil {
+			return nil
+		}
+		node, err := decodeNode(raw)
+		if err != nil {
+			return err
+		}
+		if !node.Type().AssignableTo(target.Type()) {
+			return fmt.Errorf("%s is not a %s", node.Elem().Type().Name(), target.Type().Name())
+		}
+		target.Set(node)
+	case reflect.Struct:
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected object, got %T", raw)
+		}
+		return decodeFields(obj, target)
+	case reflect.Slice:
+		if raw == nil {
+			return nil
+		}
+		arr, ok := raw.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected array, got %T", raw)
+		}
+		slice := reflect.MakeSlice(target.Type(), len(arr), len(arr))
+		for i, elem := range arr {
+			if err := decodeValue(elem, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+	case reflect.Map:
+		if raw == nil {
+			return nil
+		}
+		m := reflect.MakeMap(target.Type())
+		if target.Type().Key().Kind() == reflect.String {
+			obj, ok := raw.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("expected object, got %T", raw)
+			}
+			for key, rawVal := range obj {
+				val := reflect.New(target.Type().Elem()).Elem()
+				if err := decodeValue(rawVal, val); err != nil {
+					return err
+				}
+				m.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), val)
+			}
+		} else {
+			pairs, ok := raw.([]interface{})
+			if !ok {
+				return fmt.Errorf("expected array of pairs, got %T", raw)
+			}
+			for _, rawPair := range pairs {
+				pair, ok := rawPair.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("expected key/value object, got %T", rawPair)
+				}
+				key := reflect.New(target.Type().Key()).Elem()
+				val := reflect.New(target.Type().Elem()).Elem()
+				if err := decodeValue(pair["Key"], key); err != nil {
+					return err
+				}
+				if err := decodeValue(pair["Value"], val); err != nil {
+					return err
+				}
+				m.SetMapIndex(key, val)
+			}
+		}
+		target.Set(m)
+	case reflect.String:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("expected string, got %T", raw)
+		}
+		target.SetString(s)
+	case reflect.Bool:
+		b, ok := raw.(bool)
+		if !ok {
+			return fmt.Errorf("expected bool, got %T", raw)
+		}
+		target.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return fmt.Errorf("expected number, got %T", raw)
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return err
+		}
+		target.SetInt(i)
+	case reflect.Float64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return fmt.Errorf("expected number, got %T", raw)
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return err
+		}
+		target.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field kind %s", target.Kind())
+	}
+	return nil
+}
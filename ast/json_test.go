@@ -0,0 +1,173 @@
+package ast
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/notrealandy/tox/token"
+)
+
+func id(name string, line, col int) *Identifier {
+	return &Identifier{Value: name, Type: token.IDENT, Line: line, Col: col}
+}
+
+func num(n int64, line, col int) *IntegerLiteral {
+	return &IntegerLiteral{Value: n, Line: line, Col: col}
+}
+
+// jsonCases holds one node of every type, with all of its fields set.
+var jsonCases = []struct {
+	name string
+	node interface{}
+}{
+	{"c import", &CImportStatement{Header: "stdio.h"}},
+	{"struct", &StructStatement{Name: "User", Fields: []StructField{{Name: "name", Type: "string"}, {Name: "tags", Type: "string[]"}}, Visibility: "pub", Line: 1, Col: 1}},
+	{"type alias", &TypeAliasStatement{Name: "UserId", Type: "int", Visibility: "pub", Line: 2, Col: 1}},
+	{"struct literal", &StructLiteral{StructName: "User", Fields: map[string]Expression{"name": &StringLiteral{Value: "Ann", Line: 3, Col: 9}}, Line: 3, Col: 1}},
+	{"let", &LetStatement{Name: "x", Type: "int", Value: num(1, 4, 14), Visibility: "pub", Const: true, Line: 4, Col: 1}},
+	{"function", &FunctionStatement{
+		Name: "greet", Params: []string{"name", "rest"}, ParamTypes: []string{"string", "int[]"},
+		Defaults: []Expression{&StringLiteral{Value: "you", Line: 5, Col: 20}, nil}, Variadic: true,
+		Body:       []Statement{&ReturnStatement{Value: id("name", 6, 12), Line: 6, Col: 5}},
+		ReturnType: "string", Visibility: "pub", ReceiverType: "User", Pure: true, Override: true, Line: 5, Col: 1,
+	}},
+	{"log", &LogFunction{Value: &StringLiteral{Value: "hi", Line: 7, Col: 5}, Line: 7, Col: 1}},
+	{"return", &ReturnStatement{Value: &NilLiteral{Line: 8, Col: 8}, Line: 8, Col: 1}},
+	{"assert", &AssertStatement{Cond: &BoolLiteral{Value: true, Line: 9, Col: 8}, Message: &StringLiteral{Value: "ok", Line: 9, Col: 14}, Line: 9, Col: 1}},
+	{"if", &IfStatement{
+		IfCond:     id("a", 10, 4),
+		IfBody:     []Statement{&BreakStatement{Line: 11, Col: 5}},
+		ElifConds:  []Expression{id("b", 12, 8), id("c", 14, 8)},
+		ElifBodies: [][]Statement{{&ContinueStatement{Line: 13, Col: 5}}, {}},
+		ElseBody:   []Statement{&LogFunction{Value: id("d", 16, 9), Line: 16, Col: 5}},
+		Line:       10, Col: 1,
+	}},
+	{"match", &MatchStatement{
+		Subject: id("x", 18, 7),
+		Cases:   []Expression{num(1, 19, 10)},
+		Bodies:  [][]Statement{{&LogFunction{Value: num(1, 20, 9), Line: 20, Col: 5}}},
+		Default: []Statement{&LogFunction{Value: num(0, 22, 9), Line: 22, Col: 5}},
+		Line:    18, Col: 1,
+	}},
+	{"assignment", &AssignmentStatement{Name: "x", Left: id("x", 23, 1), Value: &BinaryExpression{Left: id("x", 23, 1), Operator: token.PLUS, Right: num(1, 23, 6), Line: 23, Col: 3}, Compound: token.PLUS, Line: 23, Col: 1}},
+	{"while", &WhileStatement{Condition: &BoolLiteral{Value: false, Line: 24, Col: 7}, Body: []Statement{&BreakStatement{Line: 25, Col: 5}}, Line: 24, Col: 1}},
+	{"with", &WithStatement{Name: "f", Value: &CallExpression{Function: id("go.file.open", 26, 11), Arguments: []Expression{&StringLiteral{Value: "a.txt", Line: 26, Col: 24}}, Line: 26, Col: 11}, Body: []Statement{}, Line: 26, Col: 1}},
+	{"for", &ForStatement{
+		Init:      &LetStatement{Name: "i", Type: "int", Value: num(0, 27, 18), Line: 27, Col: 5},
+		Condition: &BinaryExpression{Left: id("i", 27, 21), Operator: token.LT, Right: num(3, 27, 25), Line: 27, Col: 23},
+		Post:      &AssignmentStatement{Name: "i", Left: id("i", 27, 28), Value: num(1, 27, 33), Line: 27, Col: 28},
+		Body:      []Statement{&LogFunction{Value: id("i", 28, 9), Line: 28, Col: 5}},
+		Line:      27, Col: 1,
+	}},
+	{"when", &WhenStatement{Key: "os", Value: "windows", Decl: &LetStatement{Name: "sep", Type: "string", Value: &StringLiteral{Value: "\\", Line: 30, Col: 20}, Line: 30, Col: 1}, Line: 29, Col: 1}},
+	{"package", &PackageStatement{Name: "main"}},
+	{"import", &ImportStatement{Path: "utils"}},
+	{"array", &ArrayLiteral{Elements: []Expression{num(1, 31, 2), &FloatLiteral{Value: 2.5, Line: 31, Col: 5}}, Line: 31, Col: 1}},
+	{"index", &IndexExpression{Left: id("xs", 32, 1), Index: num(0, 32, 4), Line: 32, Col: 3}},
+	{"identifier", &Identifier{Value: "u.name", Type: token.IDENT, Line: 33, Col: 1}},
+	{"call", &CallExpression{Function: id("greet", 34, 1), Arguments: []Expression{&StringLiteral{Value: "Ann", Line: 34, Col: 13}, num(1, 34, 20)}, ArgNames: []string{"name", ""}, Line: 34, Col: 1}},
+	{"expression statement", &ExpressionStatement{Expr: &CallExpression{Function: id("f", 35, 1), Line: 35, Col: 1}, Line: 35, Col: 1}},
+	{"slice", &SliceExpression{Left: id("xs", 36, 1), Start: num(1, 36, 4), End: nil, Step: &UnaryExpression{Operator: token.MINUS, Right: num(1, 36, 8), Line: 36, Col: 7}, Line: 36, Col: 3}},
+	{"unary", &UnaryExpression{Operator: token.NOT, Right: id("ok", 37, 2), Line: 37, Col: 1}},
+	{"map", &MapLiteral{
+		KeyType: "string", ValueType: "int",
+		Pairs:   map[Expression]Expression{&StringLiteral{Value: "a", Line: 38, Col: 2}: num(1, 38, 7), &StringLiteral{Value: "b", Line: 38, Col: 10}: num(2, 38, 15)},
+		Spreads: []Expression{id("defaults", 38, 21)},
+		Line:    38, Col: 1,
+	}},
+	{"nil", &NilLiteral{Line: 39, Col: 1}},
+	{"binary", &BinaryExpression{Left: num(1, 40, 1), Operator: token.IN, Right: id("xs", 40, 6), Line: 40, Col: 3}},
+	{"string", &StringLiteral{Value: "line\n<%x%>", Raw: true, Line: 41, Col: 1}},
+	{"integer", &IntegerLiteral{Value: 1<<62 + 1, Line: 42, Col: 1}},
+	{"float", &FloatLiteral{Value: 2.5e-4, Line: 43, Col: 1}},
+	{"bool", &BoolLiteral{Value: true, Line: 44, Col: 1}},
+	{"break", &BreakStatement{Line: 45, Col: 5}},
+	{"continue", &ContinueStatement{Line: 46, Col: 5}},
+	{"function literal", &FunctionLiteral{Fn: &FunctionStatement{Params: []string{"n"}, ParamTypes: []string{"int"}, Body: []Statement{&ReturnStatement{Value: id("n", 48, 12), Line: 48, Col: 5}}, ReturnType: "int", Line: 47, Col: 10}, Line: 47, Col: 10}},
+	{"ternary", &TernaryExpression{Cond: id("ok", 49, 1), Then: num(1, 49, 6), Else: num(2, 49, 10), Line: 49, Col: 4}},
+}
+
+func TestNodeRoundTrip(t *testing.T) {
+	covered := map[string]bool{}
+	for _, tt := range jsonCases {
+		covered[reflect.TypeOf(tt.node).Elem().Name()] = true
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := MarshalNode(tt.node)
+			if err != nil {
+				t.Fatalf("MarshalNode: %v", err)
+			}
+			node, err := UnmarshalNode(data)
+			if err != nil {
+				t.Fatalf("UnmarshalNode(%s): %v", data, err)
+			}
+			again, err := MarshalNode(node)
+			if err != nil {
+				t.Fatalf("MarshalNode of the decoded node: %v", err)
+			}
+			if !bytes.Equal(data, again) {
+				t.Errorf("round trip changed the JSON:\n got %s\nwant %s", again, data)
+			}
+			// Map literal keys are pointers, so only the JSON can be compared
+			if !strings.Contains(string(data), `"MapLiteral"`) && !reflect.DeepEqual(node, tt.node) {
+				t.Errorf("UnmarshalNode(%s) = %#v, want %#v", data, node, tt.node)
+			}
+		})
+	}
+	for name := range nodeTypes {
+		if !covered[name] {
+			t.Errorf("no round trip case for %s", name)
+		}
+	}
+}
+
+func TestProgramRoundTrip(t *testing.T) {
+	var stmts []Statement
+	for _, tt := range jsonCases {
+		if _, isExpr := tt.node.(Expression); !isExpr {
+			stmts = append(stmts, tt.node)
+		}
+	}
+	data, err := MarshalProgram(stmts)
+	if err != nil {
+		t.Fatalf("MarshalProgram: %v", err)
+	}
+	decoded, err := UnmarshalProgram(data)
+	if err != nil {
+		t.Fatalf("UnmarshalProgram: %v", err)
+	}
+	if len(decoded) != len(stmts) {
+		t.Fatalf("decoded %d statements, want %d", len(decoded), len(stmts))
+	}
+	again, err := MarshalProgram(decoded)
+	if err != nil {
+		t.Fatalf("MarshalProgram of the decoded program: %v", err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("round trip changed the JSON:\n got %s\nwant %s", again, data)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unknown node", `{"node": "GotoStatement"}`, `unknown node type "GotoStatement"`},
+		{"missing discriminator", `{"Value": 1}`, `unknown node type ""`},
+		{"wrong field type", `{"node": "IntegerLiteral", "Value": "one"}`, "IntegerLiteral.Value: expected number, got string"},
+		{"statement as an expression", `{"node": "ReturnStatement", "Value": {"node": "BreakStatement"}}`, "BreakStatement is not a Expression"},
+		{"not an object", `[1]`, "expected node object"},
+		{"invalid JSON", `{`, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := UnmarshalNode([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("UnmarshalNode(%s) error = %v, want it to contain %q", tt.data, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package ast
+
+// Walk calls visit for every statement and expression reachable from stmts,
+// parents before children. Function bodies and nested blocks are included.
+func Walk(stmts []Statement, visit func(node interface{})) {
+	rewriteStatements(stmts, func(s Statement) { visit(s) }, func(e Expression) Expression {
+		visit(e)
+		return e
+	}, true)
+}
+
+// Rewrite walks every expression reachable from stmts, children before parents,
+// and replaces each with the result of fn. Returning the expression unchanged
+// keeps it as is.
+func Rewrite(stmts []Statement, fn func(Expression) Expression) {
+	rewriteStatements(stmts, func(Statement) {}, fn, false)
+}
+
+func rewriteStatements(stmts []Statement, visitStmt func(Statement), fn func(Expression) Expression, preOrder bool) {
+	expr := func(e Expression) Expression {
+		return rewriteExpression(e, visitStmt, fn, preOrder)
+	}
+	block := func(body []Statement) {
+		rewriteStatements(body, visitStmt, fn, preOrder)
+	}
+	for _, s := range stmts {
+		if s == nil {
+			continue
+		}
+		visitStmt(s)
+		switch st := s.(type) {
+		case *LetStatement:
+			st.Value = expr(st.Value)
+		case *FunctionStatement:
+			for i := range st.Defaults {
+				st.Defaults[i] = expr(st.Defaults[i])
+			}
+			block(st.Body)
+		case *LogFunction:
+			st.Value = expr(st.Value)
+		case *ReturnStatement:
+			st.Value = expr(st.Value)
+		case *AssertStatement:
+			st.Cond = expr(st.Cond)
+			st.Message = expr(st.Message)
+		case *ExpressionStatement:
+			st.Expr = expr(st.Expr)
+		case *IfStatement:
+			st.IfCond = expr(st.IfCond)
+			block(st.IfBody)
+			for i := range st.ElifConds {
+				st.ElifConds[i] = expr(st.ElifConds[i])
+				block(st.ElifBodies[i])
+			}
+			block(st.ElseBody)
+		case *MatchStatement:
+			st.Subject = expr(st.Subject)
+			for i := range st.Cases {
+				st.Cases[i] = expr(st.Cases[i])
+				block(st.Bodies[i])
+			}
+			block(st.Default)
+		case *AssignmentStatement:
+			st.Left = expr(st.Left)
+			st.Value = expr(st.Value)
+		case *WhileStatement:
+			st.Condition = expr(st.Condition)
+			block(st.Body)
+		case *WithStatement:
+			st.Value = expr(st.Value)
+			block(st.Body)
+		case *WhenStatement:
+			block([]Statement{st.Decl})
+		case *ForStatement:
+			block([]Statement{st.Init})
+			st.Condition = expr(st.Condition)
+			block([]Statement{st.Post})
+			block(st.Body)
+		}
+	}
+}
+
+func rewriteExpression(e Expression, visitStmt func(Statement), fn func(Expression) Expression, preOrder bool) Expression {
+	if e == nil {
+		return nil
+	}
+	if preOrder {
+		e = fn(e)
+	}
+	expr := func(child Expression) Expression {
+		return rewriteExpression(child, visitStmt, fn, preOrder)
+	}
+	switch ex := e.(type) {
+	case *BinaryExpression:
+		ex.Left = expr(ex.Left)
+		ex.Right = expr(ex.Right)
+	case *UnaryExpression:
+		ex.Right = expr(ex.Right)
+	case *TernaryExpression:
+		ex.Cond = expr(ex.Cond)
+		ex.Then = expr(ex.Then)
+		ex.Else = expr(ex.Else)
+	case *CallExpression:
+		ex.Function = expr(ex.Function)
+		for i := range ex.Arguments {
+			ex.Arguments[i] = expr(ex.Arguments[i])
+		}
+	case *ArrayLiteral:
+		for i := range ex.Elements {
+			ex.Elements[i] = expr(ex.Elements[i])
+		}
+	case *IndexExpression:
+		ex.Left = expr(ex.Left)
+		ex.Index = expr(ex.Index)
+	case *SliceExpression:
+		ex.Left = expr(ex.Left)
+		ex.Start = expr(ex.Start)
+		ex.End = expr(ex.End)
+		ex.Step = expr(ex.Step)
+	case *StructLiteral:
+		for name, value := range ex.Fields {
+			ex.Fields[name] = expr(value)
+		}
+	case *MapLiteral:
+		for i := range ex.Spreads {
+			ex.Spreads[i] = expr(ex.Spreads[i])
+		}
+		pairs := make(map[Expression]Expression, len(ex.Pairs))
+		for key, value := range ex.Pairs {
+			pairs[expr(key)] = expr(value)
+		}
+		ex.Pairs = pairs
+	case *FunctionLiteral:
+		for i := range ex.Fn.Defaults {
+			ex.Fn.Defaults[i] = expr(ex.Fn.Defaults[i])
+		}
+		rewriteStatements(ex.Fn.Body, visitStmt, fn, preOrder)
+	}
+	if !preOrder {
+		e = fn(e)
+	}
+	return e
+}
@@ -3,14 +3,20 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/notrealandy/tox/ast"
 	"github.com/notrealandy/tox/evaluator"
 	"github.com/notrealandy/tox/lexer"
+	"github.com/notrealandy/tox/optimizer"
 	"github.com/notrealandy/tox/parser"
 	"github.com/notrealandy/tox/typechecker"
 )
@@ -24,6 +30,29 @@ func projectRoot(mainPath string, srcDir string) string {
 	return abs[:idx]
 }
 
+// maxErrors returns how many type errors to print, from the project's
+// maxErrors setting; 0 (the default) prints them all.
+func maxErrors(config map[string]interface{}) int {
+	if project, ok := config["project"].(map[string]interface{}); ok {
+		if n, ok := project["maxErrors"].(float64); ok {
+			return int(n)
+		}
+	}
+	return 0
+}
+
+// profileFile returns where `tox run --profile` writes its CPU profile, from
+// the project's profileFile setting; tox.pprof by default. --profile=file
+// takes precedence.
+func profileFile(config map[string]interface{}) string {
+	if project, ok := config["project"].(map[string]interface{}); ok {
+		if path, ok := project["profileFile"].(string); ok && path != "" {
+			return path
+		}
+	}
+	return "tox.pprof"
+}
+
 // Helper to load config
 func loadConfig(configPath string) (map[string]interface{}, error) {
 	data, err := ioutil.ReadFile(configPath)
@@ -35,6 +64,135 @@ func loadConfig(configPath string) (map[string]interface{}, error) {
 	return cfg, err
 }
 
+// toxVersion is the version of the language this interpreter implements.
+const toxVersion = "0.1.0"
+
+// checkToxVersion checks the project's toxVersion setting, the version of tox
+// it was written for, against this interpreter. It must have the same major
+// version and not be newer; projects without the setting always pass.
+func checkToxVersion(config map[string]interface{}) error {
+	project, _ := config["project"].(map[string]interface{})
+	want, ok := project["toxVersion"].(string)
+	if !ok {
+		return nil
+	}
+	required, ok := parseVersion(want)
+	if !ok {
+		return fmt.Errorf("invalid toxVersion '%s', expected e.g. \"0.1.0\"", want)
+	}
+	have, _ := parseVersion(toxVersion)
+	if required[0] != have[0] || compareVersions(required, have) > 0 {
+		return fmt.Errorf("project requires tox %s, this is tox %s", want, toxVersion)
+	}
+	return nil
+}
+
+// parseVersion splits a version such as "1.2.3" or "1.2" into its numbers;
+// missing ones are 0.
+func parseVersion(s string) ([3]int, bool) {
+	var v [3]int
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// compareVersions returns -1, 0 or 1 as a is older than, equal to or newer than b.
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// tabWidth returns the project's configured tab width used for column positions
+// in diagnostics, defaulting to 1 (a tab counts as a single column).
+func tabWidth(config map[string]interface{}) int {
+	if project, ok := config["project"].(map[string]interface{}); ok {
+		if w, ok := project["tabWidth"].(float64); ok {
+			return int(w)
+		}
+	}
+	return 1
+}
+
+// applyBuildConditions unwraps @when declarations whose condition matches the
+// platform tox is running on and drops the others.
+func applyBuildConditions(stmts []ast.Statement) []ast.Statement {
+	var kept []ast.Statement
+	for _, stmt := range stmts {
+		for {
+			when, ok := stmt.(*ast.WhenStatement)
+			if !ok {
+				break
+			}
+			platform := runtime.GOOS
+			if when.Key == "arch" {
+				platform = runtime.GOARCH
+			}
+			if when.Value != platform {
+				stmt = nil
+				break
+			}
+			stmt = when.Decl
+		}
+		if stmt != nil {
+			kept = append(kept, stmt)
+		}
+	}
+	return kept
+}
+
+// exportedAlias returns a copy of a public top-level declaration renamed to
+// module.Name, or nil if stmt isn't exported. Every declaration kind that can
+// be marked pub needs a case here.
+func exportedAlias(stmt ast.Statement, moduleName string) ast.Statement {
+	switch s := stmt.(type) {
+	case *ast.FunctionStatement:
+		if s.Visibility == "pub" {
+			fnGlobal := *s
+			fnGlobal.Name = moduleName + "." + s.Name
+			// Methods follow their struct: geo.Point.len belongs to geo.Point
+			if s.ReceiverType != "" {
+				fnGlobal.ReceiverType = moduleName + "." + s.ReceiverType
+			}
+			return &fnGlobal
+		}
+	case *ast.LetStatement:
+		if s.Visibility == "pub" {
+			letGlobal := *s
+			letGlobal.Name = moduleName + "." + s.Name
+			return &letGlobal
+		}
+	case *ast.StructStatement:
+		if s.Visibility == "pub" {
+			structGlobal := *s
+			structGlobal.Name = moduleName + "." + s.Name
+			return &structGlobal
+		}
+	case *ast.TypeAliasStatement:
+		if s.Visibility == "pub" {
+			aliasGlobal := *s
+			aliasGlobal.Name = moduleName + "." + s.Name
+			return &aliasGlobal
+		}
+	}
+	return nil
+}
+
 // Recursively load and parse all .tox files in a package directory, collecting all statements
 func loadAndParseFile(path string, loaded map[string]bool, config map[string]interface{}, allStmts *[]ast.Statement) error {
 	dir := filepath.Dir(path)
@@ -65,12 +223,13 @@ func loadAndParseFile(path string, loaded map[string]bool, config map[string]int
 		if err != nil {
 			return fmt.Errorf("error reading file %s: %v", file, err)
 		}
-		l := lexer.New(string(content))
+		l := lexer.NewWithTabWidth(string(content), tabWidth(config))
 		p := parser.New(l)
 		prog := p.ParseProgram()
 		if len(p.Errors) > 0 {
 			return fmt.Errorf("parser errors in %s: %v", file, p.Errors)
 		}
+		prog = applyBuildConditions(prog)
 		// Check package statement
 		for _, stmt := range prog {
 			if pkgStmt, ok := stmt.(*ast.PackageStatement); ok {
@@ -113,26 +272,12 @@ func loadAndParseFile(path string, loaded map[string]bool, config map[string]int
 					if err != nil {
 						return err
 					}
-					// Within the import loop in loadAndParseFile, replace your existing prefixing code with:
+					// Public declarations are also visible under the module alias
 					for _, istmt := range importedStmts {
-						switch stmt := istmt.(type) {
-						case *ast.FunctionStatement:
-							if stmt.Visibility == "pub" {
-								fnGlobal := *stmt
-								fnGlobal.Name = moduleName + "." + stmt.Name
-								*allStmts = append(*allStmts, &fnGlobal)
-							}
-							*allStmts = append(*allStmts, stmt)
-						case *ast.LetStatement:
-							if stmt.Visibility == "pub" {
-								letGlobal := *stmt
-								letGlobal.Name = moduleName + "." + stmt.Name
-								*allStmts = append(*allStmts, &letGlobal)
-							}
-							*allStmts = append(*allStmts, stmt)
-						default:
-							*allStmts = append(*allStmts, stmt)
+						if alias := exportedAlias(istmt, moduleName); alias != nil {
+							*allStmts = append(*allStmts, alias)
 						}
+						*allStmts = append(*allStmts, istmt)
 					}
 					found = true
 					break
@@ -183,17 +328,36 @@ func loadAndParseFile(path string, loaded map[string]bool, config map[string]int
 
 func main() {
 	// Usage instructions
-	if len(os.Args) < 2 || os.Args[1] != "run" {
-		fmt.Println("Usage: tox run <path>")
+	if len(os.Args) < 2 || (os.Args[1] != "run" && os.Args[1] != "check") {
+		fmt.Println("Usage: tox run [--profile[=file]] <path> [args...]")
+		fmt.Println("       tox check [--types] <path>")
 		os.Exit(1)
 	}
+	command := os.Args[1]
 
 	// Determine the path
+	args := os.Args[2:]
+	profile, types := false, false
+	profilePath := ""
+	if len(args) > 0 && command == "run" && (args[0] == "--profile" || strings.HasPrefix(args[0], "--profile=")) {
+		profile = true
+		profilePath = strings.TrimPrefix(strings.TrimPrefix(args[0], "--profile"), "=")
+		args = args[1:]
+	}
+	if len(args) > 0 && command == "check" && args[0] == "--types" {
+		types = true
+		args = args[1:]
+	}
+
 	var path string
-	if len(os.Args) < 3 || os.Args[2] == "." {
+	if len(args) == 0 || args[0] == "." {
 		path = "main.tox"
 	} else {
-		path = os.Args[2]
+		path = args[0]
+	}
+	// Whatever follows the path is for the program, see go.os.args
+	if len(args) > 1 {
+		evaluator.ProgramArgs = args[1:]
 	}
 
 	// Load config
@@ -202,6 +366,10 @@ func main() {
 		fmt.Println("Error loading toxconfig.json:", err)
 		os.Exit(1)
 	}
+	if err := checkToxVersion(config); err != nil {
+		fmt.Println("Error in toxconfig.json:", err)
+		os.Exit(1)
+	}
 
 	// Recursively load all files and collect all statements
 	loaded := map[string]bool{}
@@ -212,27 +380,86 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Run typechecker
-	errors := typechecker.Check(allStmts)
+	// Run typechecker; with --types, print what it inferred before any errors
+	var errors []error
+	if types {
+		var inferred []typechecker.InferredType
+		errors, inferred = typechecker.CheckTypes(allStmts)
+		writeTypes(os.Stdout, inferred)
+	} else {
+		errors = typechecker.Check(allStmts)
+	}
 	if len(errors) > 0 {
 		fmt.Println("Type errors:")
-		for _, err := range errors {
+		shown := errors
+		if max := maxErrors(config); max > 0 && len(errors) > max {
+			shown = errors[:max]
+		}
+		for _, err := range shown {
 			fmt.Println("  -", err)
 		}
+		if len(shown) < len(errors) {
+			fmt.Printf("  ... and %d more\n", len(errors)-len(shown))
+		}
 		os.Exit(1)
 	}
-	fmt.Println("Program passed type checking ✅\n")
+	if command == "check" {
+		fmt.Println("Program passed type checking ✅")
+		return
+	}
+	fmt.Print("Program passed type checking ✅\n\n")
 
-	env := evaluator.NewEnvironment()
+	// Fold calls to @pure functions with constant arguments
+	allStmts = optimizer.Optimize(allStmts)
 
-	// Evaluate all top-level statements to populate env
-	evaluator.Eval(allStmts, env)
+	// With --profile, write a CPU profile and time every node type
+	var profiler *evaluator.Profiler
+	if profile {
+		if profilePath == "" {
+			profilePath = profileFile(config)
+		}
+		f, err := os.Create(profilePath)
+		if err != nil {
+			fmt.Println("Profile error:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fmt.Println("Profile error:", err)
+			os.Exit(1)
+		}
+		profiler = evaluator.EnableProfiling()
+	}
 
-	// Now run main if it exists
-	if mainFn, ok := env.Get("main"); ok {
-		if fnStmt, ok := mainFn.(*ast.FunctionStatement); ok {
-			mainEnv := evaluator.NewEnclosedEnvironment(env)
-			evaluator.Eval(fnStmt.Body, mainEnv)
+	// Evaluate all top-level statements, then run main if it exists
+	_, err = evaluator.Run(allStmts)
+
+	if profile {
+		pprof.StopCPUProfile()
+		fmt.Fprintln(os.Stderr)
+		profiler.WriteSummary(os.Stderr)
+		fmt.Fprintf(os.Stderr, "CPU profile written to %s (inspect with: go tool pprof %s)\n", profilePath, profilePath)
+	}
+	if err != nil {
+		fmt.Println("Runtime error:", err)
+		os.Exit(1)
+	}
+}
+
+// writeTypes prints the types inferred by `tox check --types`, one statement
+// per line: position, statement kind and type.
+func writeTypes(w io.Writer, inferred []typechecker.InferredType) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, t := range inferred {
+		kind := t.Kind
+		if t.Name != "" {
+			kind += " " + t.Name
+		}
+		typ := t.Type
+		if typ == "" {
+			typ = "<unknown>"
 		}
+		fmt.Fprintf(tw, "%d:%d\t%s\t%s\n", t.Line, t.Col, kind, typ)
 	}
+	tw.Flush()
 }
@@ -4,21 +4,67 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type BuiltinFunc func(args []interface{}) interface{}
 
+// builtinError aborts a builtin with a runtime error; callBuiltin fills in the
+// position of the call site.
+func builtinError(format string, args ...interface{}) {
+	runtimeError(0, 0, format, args...)
+}
+
+// callBuiltin runs a builtin, attaching the call site position to runtime errors it raises.
+func callBuiltin(fn BuiltinFunc, args []interface{}, line, col int) interface{} {
+	defer func() {
+		if r := recover(); r != nil {
+			if rtErr, ok := r.(*RuntimeError); ok && rtErr.Line == 0 {
+				rtErr.Line, rtErr.Col = line, col
+			}
+			panic(r)
+		}
+	}()
+	return fn(args)
+}
+
+// randSource backs the go.rand builtins; go.async callers share it, hence randMu.
+var (
+	randMu     sync.Mutex
+	randSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// regexCache holds the patterns compiled by the go.regex builtins.
+var (
+	regexMu    sync.Mutex
+	regexCache = map[string]*regexp.Regexp{}
+)
+
+// ProgramArgs are the command line arguments after the script path, returned
+// by go.os.args.
+var ProgramArgs []string
+
 var fileHandles = map[int]*os.File{}
 var nextFileHandle = 1
 var fileReaders = map[int]*bufio.Reader{}
 
 var Builtins = map[string]BuiltinFunc{
 	"go.println": func(args []interface{}) interface{} {
-		fmt.Println(args...)
+		parts := make([]string, len(args))
+		for i, arg := range args {
+			parts[i] = formatValue(arg)
+		}
+		fmt.Println(strings.Join(parts, " "))
 		return nil
 	},
 	"go.printf": func(args []interface{}) interface{} {
@@ -27,7 +73,17 @@ var Builtins = map[string]BuiltinFunc{
 			if !ok {
 				return nil
 			}
-			fmt.Printf(format, args[1:]...)
+			// Composite values and nil are pre-formatted so %v prints them Tox-style
+			fmtArgs := make([]interface{}, len(args)-1)
+			for i, arg := range args[1:] {
+				switch arg.(type) {
+				case nil, []interface{}, map[string]interface{}, map[interface{}]interface{}:
+					fmtArgs[i] = formatValue(arg)
+				default:
+					fmtArgs[i] = arg
+				}
+			}
+			fmt.Printf(format, fmtArgs...)
 		}
 		return nil
 	},
@@ -42,6 +98,36 @@ var Builtins = map[string]BuiltinFunc{
 		}
 		return nil
 	},
+	"go.time.sleepSeconds": func(args []interface{}) interface{} {
+		if len(args) != 1 {
+			builtinError("go.time.sleepSeconds expects 1 argument, got %d", len(args))
+		}
+		seconds, ok := args[0].(int64)
+		if !ok {
+			builtinError("go.time.sleepSeconds expects an int, got %s", typeName(args[0]))
+		}
+		time.Sleep(time.Duration(seconds) * time.Second)
+		return nil
+	},
+	"go.time.unix": func(args []interface{}) interface{} {
+		return time.Now().Unix()
+	},
+	"go.time.unixMillis": func(args []interface{}) interface{} {
+		return time.Now().UnixMilli()
+	},
+	// go.time.format(unix, layout) formats a time in seconds since the epoch,
+	// in UTC, with a Go layout such as "2006-01-02 15:04:05".
+	"go.time.format": func(args []interface{}) interface{} {
+		if len(args) != 2 {
+			builtinError("go.time.format expects 2 arguments, got %d", len(args))
+		}
+		unix, ok1 := args[0].(int64)
+		layout, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			builtinError("go.time.format expects a unix time and a layout string")
+		}
+		return time.Unix(unix, 0).UTC().Format(layout)
+	},
 	"go.file.open": func(args []interface{}) interface{} {
 		if len(args) > 0 {
 			if fname, ok := args[0].(string); ok {
@@ -96,11 +182,6 @@ var Builtins = map[string]BuiltinFunc{
 			handle, ok1 := args[0].(int)
 			data, ok2 := args[1].(string)
 			if ok1 && ok2 {
-				// Unescape escape sequences
-				unescaped, err := strconv.Unquote(`"` + data + `"`)
-				if err == nil {
-					data = unescaped
-				}
 				if f, ok := fileHandles[handle]; ok {
 					_, err := f.WriteString(data)
 					return err == nil
@@ -109,6 +190,26 @@ var Builtins = map[string]BuiltinFunc{
 		}
 		return false
 	},
+	"go.file.writeAll": func(args []interface{}) interface{} {
+		if len(args) >= 2 {
+			fname, ok1 := args[0].(string)
+			data, ok2 := args[1].(string)
+			if ok1 && ok2 {
+				return writeFileWithFlags(fname, data, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+			}
+		}
+		return false
+	},
+	"go.file.append": func(args []interface{}) interface{} {
+		if len(args) >= 2 {
+			fname, ok1 := args[0].(string)
+			data, ok2 := args[1].(string)
+			if ok1 && ok2 {
+				return writeFileWithFlags(fname, data, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+			}
+		}
+		return false
+	},
 	"go.file.create": func(args []interface{}) interface{} {
 		if len(args) > 0 {
 			if fname, ok := args[0].(string); ok {
@@ -177,35 +278,42 @@ var Builtins = map[string]BuiltinFunc{
 				if err != nil {
 					return nil
 				}
-				m := map[interface{}]interface{}{
-					"name":           info.Name(),
-					"size":           info.Size(),
-					"mode":           info.Mode().String(),
-					"modeBits":       uint32(info.Mode()),
-					"modTime":        info.ModTime().Format(time.RFC3339),
-					"modTimeRaw":     info.ModTime(), // if you want to expose the raw object
-					"isDir":          info.IsDir(),
-					"isRegular":      info.Mode().IsRegular(),
-					"isSymlink":      info.Mode()&os.ModeSymlink != 0,
-					"isHidden":       strings.HasPrefix(info.Name(), "."),
-					"sys":            info.Sys(), // OS-specific, usually not needed
-					"modeDevice":     info.Mode()&os.ModeDevice != 0,
-					"modeCharDevice": info.Mode()&os.ModeCharDevice != 0,
-					"modeNamedPipe":  info.Mode()&os.ModeNamedPipe != 0,
-					"modeSocket":     info.Mode()&os.ModeSocket != 0,
-					"modeSetuid":     info.Mode()&os.ModeSetuid != 0,
-					"modeSetgid":     info.Mode()&os.ModeSetgid != 0,
-					"modeSticky":     info.Mode()&os.ModeSticky != 0,
-					"modeTemporary":  info.Mode()&os.ModeTemporary != 0,
-					"modeAppend":     info.Mode()&os.ModeAppend != 0,
-					"modeExclusive":  info.Mode()&os.ModeExclusive != 0,
-					"modeIrregular":  info.Mode()&os.ModeIrregular != 0,
-				}
-				return m
+				return statMap(info)
 			}
 		}
 		return nil
 	},
+	// go.dir.list(path) returns the names of the entries in a directory, and
+	// go.dir.listDetailed(path) describes each like go.file.stat, both sorted
+	// by name. A path that isn't a readable directory is reported on stderr
+	// and gives nil.
+	"go.dir.list": func(args []interface{}) interface{} {
+		entries := readDir("go.dir.list", args)
+		if entries == nil {
+			return nil
+		}
+		names := make([]interface{}, len(entries))
+		for i, entry := range entries {
+			names[i] = entry.Name()
+		}
+		return names
+	},
+	"go.dir.listDetailed": func(args []interface{}) interface{} {
+		entries := readDir("go.dir.listDetailed", args)
+		if entries == nil {
+			return nil
+		}
+		details := make([]interface{}, 0, len(entries))
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				// Removed since the directory was read
+				continue
+			}
+			details = append(details, statMap(info))
+		}
+		return details
+	},
 	"go.file.readline": func(args []interface{}) interface{} {
 		if len(args) > 0 {
 			if handle, ok := args[0].(int); ok {
@@ -220,6 +328,27 @@ var Builtins = map[string]BuiltinFunc{
 		}
 		return nil
 	},
+	// go.file.readLines(path) reads a whole file by name and returns its lines
+	// without their line endings. A file that can't be read is reported on
+	// stderr and gives nil.
+	"go.file.readLines": func(args []interface{}) interface{} {
+		path := stringArgs("go.file.readLines", args, 1)[0]
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Read error:", err)
+			return nil
+		}
+		text := strings.TrimSuffix(string(data), "\n")
+		if text == "" {
+			return []interface{}{}
+		}
+		parts := strings.Split(text, "\n")
+		lines := make([]interface{}, len(parts))
+		for i, line := range parts {
+			lines[i] = strings.TrimSuffix(line, "\r")
+		}
+		return lines
+	},
 	"go.strings.split": func(args []interface{}) interface{} {
 		if len(args) == 2 {
 			s, ok1 := args[0].(string)
@@ -261,6 +390,394 @@ var Builtins = map[string]BuiltinFunc{
 		}
 		return nil
 	},
+	"go.strings.title": func(args []interface{}) interface{} {
+		if len(args) == 1 {
+			if s, ok := args[0].(string); ok {
+				return titleCase(s)
+			}
+		}
+		return nil
+	},
+	"go.strings.capitalize": func(args []interface{}) interface{} {
+		if len(args) == 1 {
+			if s, ok := args[0].(string); ok {
+				return capitalize(s)
+			}
+		}
+		return nil
+	},
+	"go.strings.scan": func(args []interface{}) interface{} {
+		if len(args) != 2 {
+			builtinError("go.strings.scan expects 2 arguments, got %d", len(args))
+		}
+		s, ok1 := args[0].(string)
+		format, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			builtinError("go.strings.scan expects a string and a format string")
+		}
+		return scan(s, format)
+	},
+	"go.strings.replace": func(args []interface{}) interface{} {
+		strs := stringArgs("go.strings.replace", args, 3)
+		return strings.ReplaceAll(strs[0], strs[1], strs[2])
+	},
+	"go.strings.contains": func(args []interface{}) interface{} {
+		strs := stringArgs("go.strings.contains", args, 2)
+		return strings.Contains(strs[0], strs[1])
+	},
+	"go.strings.indexOf": func(args []interface{}) interface{} {
+		strs := stringArgs("go.strings.indexOf", args, 2)
+		i := strings.Index(strs[0], strs[1])
+		if i < 0 {
+			return int64(-1)
+		}
+		// Characters, not bytes: go.strings.indexOf("héllo", "l") is 2
+		return int64(utf8.RuneCountInString(strs[0][:i]))
+	},
+	"go.strings.repeat": func(args []interface{}) interface{} {
+		if len(args) != 2 {
+			builtinError("go.strings.repeat expects 2 arguments, got %d", len(args))
+		}
+		s, ok1 := args[0].(string)
+		n, ok2 := args[1].(int64)
+		if !ok1 || !ok2 {
+			builtinError("go.strings.repeat expects a string and an int")
+		}
+		if n < 0 {
+			builtinError("go.strings.repeat count must not be negative, got %d", n)
+		}
+		return strings.Repeat(s, int(n))
+	},
+	"go.strings.join": func(args []interface{}) interface{} {
+		if len(args) != 2 {
+			builtinError("go.strings.join expects 2 arguments, got %d", len(args))
+		}
+		arr, ok1 := args[0].([]interface{})
+		sep, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			builtinError("go.strings.join expects a string[] and a separator")
+		}
+		parts := make([]string, len(arr))
+		for i, el := range arr {
+			part, ok := el.(string)
+			if !ok {
+				builtinError("go.strings.join expects a string[], found %s", typeName(el))
+			}
+			parts[i] = part
+		}
+		return strings.Join(parts, sep)
+	},
+	"go.strings.hasPrefix": func(args []interface{}) interface{} {
+		strs := stringArgs("go.strings.hasPrefix", args, 2)
+		return strings.HasPrefix(strs[0], strs[1])
+	},
+	"go.strings.hasSuffix": func(args []interface{}) interface{} {
+		strs := stringArgs("go.strings.hasSuffix", args, 2)
+		return strings.HasSuffix(strs[0], strs[1])
+	},
+	"go.strings.length": func(args []interface{}) interface{} {
+		// Characters, not bytes: go.strings.length("héllo") is 5
+		return int64(utf8.RuneCountInString(stringArgs("go.strings.length", args, 1)[0]))
+	},
+	"go.map.merge": func(args []interface{}) interface{} {
+		if len(args) == 2 {
+			a, ok1 := args[0].(map[interface{}]interface{})
+			b, ok2 := args[1].(map[interface{}]interface{})
+			if ok1 && ok2 {
+				merged := make(map[interface{}]interface{}, len(a)+len(b))
+				for k, v := range a {
+					merged[k] = v
+				}
+				for k, v := range b {
+					merged[k] = v
+				}
+				return merged
+			}
+		}
+		return nil
+	},
+	// go.map.keys and go.map.values list a map in key order: numbers
+	// ascending, strings alphabetically. go.map.delete changes the map itself;
+	// deleting a key that isn't there does nothing.
+	"go.map.keys": func(args []interface{}) interface{} {
+		if len(args) != 1 {
+			builtinError("go.map.keys expects 1 argument, got %d", len(args))
+		}
+		return sortedKeys(mapArg("go.map.keys", args[0]))
+	},
+	"go.map.values": func(args []interface{}) interface{} {
+		if len(args) != 1 {
+			builtinError("go.map.values expects 1 argument, got %d", len(args))
+		}
+		m := mapArg("go.map.values", args[0])
+		keys := sortedKeys(m)
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			values[i] = m[key]
+		}
+		return values
+	},
+	"go.map.has": func(args []interface{}) interface{} {
+		if len(args) != 2 {
+			builtinError("go.map.has expects 2 arguments, got %d", len(args))
+		}
+		_, ok := mapArg("go.map.has", args[0])[args[1]]
+		return ok
+	},
+	"go.map.delete": func(args []interface{}) interface{} {
+		if len(args) != 2 {
+			builtinError("go.map.delete expects 2 arguments, got %d", len(args))
+		}
+		delete(mapArg("go.map.delete", args[0]), args[1])
+		return nil
+	},
+	"go.math.sum": func(args []interface{}) interface{} {
+		nums, isFloat := numericArrayArg("go.math.sum", args)
+		var sum float64
+		for _, n := range nums {
+			sum += n
+		}
+		if isFloat {
+			return sum
+		}
+		return int64(sum)
+	},
+	"go.math.avg": func(args []interface{}) interface{} {
+		nums, _ := numericArrayArg("go.math.avg", args)
+		if len(nums) == 0 {
+			builtinError("go.math.avg of an empty array")
+		}
+		var sum float64
+		for _, n := range nums {
+			sum += n
+		}
+		return sum / float64(len(nums))
+	},
+	"go.math.minOf": func(args []interface{}) interface{} {
+		return extremeOf("go.math.minOf", args, func(a, b float64) bool { return a < b })
+	},
+	"go.math.maxOf": func(args []interface{}) interface{} {
+		return extremeOf("go.math.maxOf", args, func(a, b float64) bool { return a > b })
+	},
+	// The go.array builtins never modify their arguments: push and append
+	// return a new array, and pop returns the last element, leaving the array
+	// as it was. Use them with an assignment, e.g. xs >> go.array.push(xs, 4).
+	"go.array.push": func(args []interface{}) interface{} {
+		if len(args) != 2 {
+			builtinError("go.array.push expects 2 arguments, got %d", len(args))
+		}
+		arr := arrayArg("go.array.push", args[0])
+		return append(append(make([]interface{}, 0, len(arr)+1), arr...), args[1])
+	},
+	"go.array.pop": func(args []interface{}) interface{} {
+		if len(args) != 1 {
+			builtinError("go.array.pop expects 1 argument, got %d", len(args))
+		}
+		arr := arrayArg("go.array.pop", args[0])
+		if len(arr) == 0 {
+			builtinError("go.array.pop of an empty array")
+		}
+		return arr[len(arr)-1]
+	},
+	"go.array.append": func(args []interface{}) interface{} {
+		if len(args) != 2 {
+			builtinError("go.array.append expects 2 arguments, got %d", len(args))
+		}
+		a, b := arrayArg("go.array.append", args[0]), arrayArg("go.array.append", args[1])
+		return append(append(make([]interface{}, 0, len(a)+len(b)), a...), b...)
+	},
+	"go.array.contains": func(args []interface{}) interface{} {
+		if len(args) != 2 {
+			builtinError("go.array.contains expects 2 arguments, got %d", len(args))
+		}
+		return evalMembership(args[1], arrayArg("go.array.contains", args[0]), 0, 0)
+	},
+	// go.array.sort orders ints, floats or strings ascending. Like
+	// go.array.sortBy it returns a new array and keeps equal elements in their
+	// original order.
+	"go.array.sort": func(args []interface{}) interface{} {
+		if len(args) != 1 {
+			builtinError("go.array.sort expects 1 argument, got %d", len(args))
+		}
+		sorted := append([]interface{}{}, arrayArg("go.array.sort", args[0])...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			switch a := sorted[i].(type) {
+			case int64:
+				if b, ok := sorted[j].(int64); ok {
+					return a < b
+				}
+			case float64:
+				if b, ok := sorted[j].(float64); ok {
+					return a < b
+				}
+			case string:
+				if b, ok := sorted[j].(string); ok {
+					return a < b
+				}
+			}
+			builtinError("go.array.sort cannot order %s and %s", typeName(sorted[i]), typeName(sorted[j]))
+			return false
+		})
+		return sorted
+	},
+	"go.math.abs": func(args []interface{}) interface{} {
+		switch n := numberArg("go.math.abs", args, 0).(type) {
+		case int64:
+			if n < 0 {
+				return -n
+			}
+			return n
+		default:
+			return math.Abs(n.(float64))
+		}
+	},
+	"go.math.min": func(args []interface{}) interface{} {
+		return minMax("go.math.min", args, func(a, b float64) bool { return a < b })
+	},
+	"go.math.max": func(args []interface{}) interface{} {
+		return minMax("go.math.max", args, func(a, b float64) bool { return a > b })
+	},
+	"go.math.pow": func(args []interface{}) interface{} {
+		base, _ := toFloat(numberArg("go.math.pow", args, 0))
+		exp, _ := toFloat(numberArg("go.math.pow", args, 1))
+		return math.Pow(base, exp)
+	},
+	"go.math.sqrt": func(args []interface{}) interface{} {
+		f, _ := toFloat(numberArg("go.math.sqrt", args, 0))
+		if f < 0 {
+			builtinError("go.math.sqrt of a negative number")
+		}
+		return math.Sqrt(f)
+	},
+	"go.math.floor": func(args []interface{}) interface{} {
+		return roundedInt("go.math.floor", args, math.Floor)
+	},
+	"go.math.ceil": func(args []interface{}) interface{} {
+		return roundedInt("go.math.ceil", args, math.Ceil)
+	},
+	// The go.rand builtins draw from a single source, seeded from the clock at
+	// startup. go.rand.seed makes the numbers that follow reproducible.
+	// go.rand.int(n) has no number to draw from when n <= 0: that is reported
+	// on stderr and gives -1, which it never returns otherwise.
+	"go.rand.int": func(args []interface{}) interface{} {
+		if len(args) != 1 {
+			builtinError("go.rand.int expects 1 argument, got %d", len(args))
+		}
+		n, ok := args[0].(int64)
+		if !ok {
+			builtinError("go.rand.int expects an int, got %s", typeName(args[0]))
+		}
+		if n <= 0 {
+			fmt.Fprintf(os.Stderr, "Rand error: go.rand.int needs a positive bound, got %d\n", n)
+			return int64(-1)
+		}
+		randMu.Lock()
+		defer randMu.Unlock()
+		return randSource.Int63n(n)
+	},
+	"go.rand.float": func(args []interface{}) interface{} {
+		if len(args) != 0 {
+			builtinError("go.rand.float expects no arguments, got %d", len(args))
+		}
+		randMu.Lock()
+		defer randMu.Unlock()
+		return randSource.Float64()
+	},
+	"go.rand.seed": func(args []interface{}) interface{} {
+		if len(args) != 1 {
+			builtinError("go.rand.seed expects 1 argument, got %d", len(args))
+		}
+		seed, ok := args[0].(int64)
+		if !ok {
+			builtinError("go.rand.seed expects an int, got %s", typeName(args[0]))
+		}
+		randMu.Lock()
+		defer randMu.Unlock()
+		randSource.Seed(seed)
+		return nil
+	},
+	"go.env.get": func(args []interface{}) interface{} {
+		return os.Getenv(stringArgs("go.env.get", args, 1)[0])
+	},
+	"go.env.set": func(args []interface{}) interface{} {
+		strs := stringArgs("go.env.set", args, 2)
+		if err := os.Setenv(strs[0], strs[1]); err != nil {
+			fmt.Fprintln(os.Stderr, "Env error:", err)
+			return false
+		}
+		return true
+	},
+	"go.os.args": func(args []interface{}) interface{} {
+		if len(args) != 0 {
+			builtinError("go.os.args expects no arguments, got %d", len(args))
+		}
+		result := make([]interface{}, len(ProgramArgs))
+		for i, arg := range ProgramArgs {
+			result[i] = arg
+		}
+		return result
+	},
+	// The go.regex builtins take an RE2 pattern, as in Go's regexp package,
+	// and the string to search. An invalid pattern is reported on stderr and
+	// matches nothing: match gives false, find "" and findAll nil.
+	"go.regex.match": func(args []interface{}) interface{} {
+		strs := stringArgs("go.regex.match", args, 2)
+		re := compileRegex("go.regex.match", strs[0])
+		if re == nil {
+			return false
+		}
+		return re.MatchString(strs[1])
+	},
+	"go.regex.find": func(args []interface{}) interface{} {
+		strs := stringArgs("go.regex.find", args, 2)
+		re := compileRegex("go.regex.find", strs[0])
+		if re == nil {
+			return ""
+		}
+		return re.FindString(strs[1])
+	},
+	"go.regex.findAll": func(args []interface{}) interface{} {
+		strs := stringArgs("go.regex.findAll", args, 2)
+		re := compileRegex("go.regex.findAll", strs[0])
+		if re == nil {
+			return nil
+		}
+		matches := re.FindAllString(strs[1], -1)
+		result := make([]interface{}, len(matches))
+		for i, m := range matches {
+			result[i] = m
+		}
+		return result
+	},
+	// The go.conv builtins parse a string, typically read with input(), ignoring
+	// surrounding whitespace. Input that doesn't parse is a runtime error.
+	"go.conv.toInt": func(args []interface{}) interface{} {
+		s := convArg("go.conv.toInt", args)
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			builtinError("go.conv.toInt: cannot convert %q to int", s)
+		}
+		return n
+	},
+	"go.conv.toFloat": func(args []interface{}) interface{} {
+		s := convArg("go.conv.toFloat", args)
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			builtinError("go.conv.toFloat: cannot convert %q to float", s)
+		}
+		return f
+	},
+	"go.conv.toBool": func(args []interface{}) interface{} {
+		s := convArg("go.conv.toBool", args)
+		switch s {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+		builtinError("go.conv.toBool: cannot convert %q to bool", s)
+		return nil
+	},
 	"go.bytes.make": func(args []interface{}) interface{} {
 		if len(args) == 1 {
 			if size, ok := args[0].(int64); ok && size >= 0 {
@@ -294,3 +811,352 @@ var Builtins = map[string]BuiltinFunc{
 		return int64(0)
 	},
 }
+
+// go.array.sortBy calls back into the evaluator, so like go.async it is
+// registered in init to avoid an initialization cycle.
+func init() {
+	Builtins["go.array.sortBy"] = builtinSortBy
+}
+
+// go.array.sortBy(arr, less) returns a copy of arr ordered by less, a
+// fnc(a T, b T) >> bool reporting whether a goes before b.
+func builtinSortBy(args []interface{}) interface{} {
+	if len(args) != 2 {
+		builtinError("go.array.sortBy expects 2 arguments, got %d", len(args))
+	}
+	sorted := append([]interface{}{}, arrayArg("go.array.sortBy", args[0])...)
+	less, ok := args[1].(*Function)
+	if !ok {
+		builtinError("go.array.sortBy expects a function as its second argument, got %s", typeName(args[1]))
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		result, ok := callFunction(less, nil, []interface{}{sorted[i], sorted[j]}).(bool)
+		if !ok {
+			builtinError("go.array.sortBy comparator must return a bool")
+		}
+		return result
+	})
+	return sorted
+}
+
+// readDir reads the directory named by the single argument of a go.dir
+// builtin, or reports why it can't and returns nil.
+func readDir(name string, args []interface{}) []os.DirEntry {
+	path := stringArgs(name, args, 1)[0]
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Read error:", err)
+		return nil
+	}
+	if entries == nil {
+		entries = []os.DirEntry{}
+	}
+	return entries
+}
+
+// statMap describes a file the way go.file.stat and go.dir.listDetailed
+// return it.
+func statMap(info os.FileInfo) map[interface{}]interface{} {
+	return map[interface{}]interface{}{
+		"name":           info.Name(),
+		"size":           info.Size(),
+		"mode":           info.Mode().String(),
+		"modeBits":       uint32(info.Mode()),
+		"modTime":        info.ModTime().Format(time.RFC3339),
+		"modTimeRaw":     info.ModTime(), // if you want to expose the raw object
+		"isDir":          info.IsDir(),
+		"isRegular":      info.Mode().IsRegular(),
+		"isSymlink":      info.Mode()&os.ModeSymlink != 0,
+		"isHidden":       strings.HasPrefix(info.Name(), "."),
+		"sys":            info.Sys(), // OS-specific, usually not needed
+		"modeDevice":     info.Mode()&os.ModeDevice != 0,
+		"modeCharDevice": info.Mode()&os.ModeCharDevice != 0,
+		"modeNamedPipe":  info.Mode()&os.ModeNamedPipe != 0,
+		"modeSocket":     info.Mode()&os.ModeSocket != 0,
+		"modeSetuid":     info.Mode()&os.ModeSetuid != 0,
+		"modeSetgid":     info.Mode()&os.ModeSetgid != 0,
+		"modeSticky":     info.Mode()&os.ModeSticky != 0,
+		"modeTemporary":  info.Mode()&os.ModeTemporary != 0,
+		"modeAppend":     info.Mode()&os.ModeAppend != 0,
+		"modeExclusive":  info.Mode()&os.ModeExclusive != 0,
+		"modeIrregular":  info.Mode()&os.ModeIrregular != 0,
+	}
+}
+
+// writeFileWithFlags opens fname with flags, writes data and closes it again,
+// reporting whether every step succeeded. Errors are printed to stderr.
+func writeFileWithFlags(fname string, data string, flags int) bool {
+	f, err := os.OpenFile(fname, flags, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Open error:", err)
+		return false
+	}
+	_, err = f.WriteString(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Write error:", err)
+		return false
+	}
+	return true
+}
+
+// numericArrayArg extracts the single int or float array argument of the
+// go.math aggregation builtins, reporting whether it holds floats.
+func numericArrayArg(name string, args []interface{}) ([]float64, bool) {
+	if len(args) != 1 {
+		builtinError("%s expects 1 argument, got %d", name, len(args))
+	}
+	arr, ok := args[0].([]interface{})
+	if !ok {
+		builtinError("%s expects an int[] or float[] argument", name)
+	}
+	nums := make([]float64, len(arr))
+	isFloat := false
+	for i, el := range arr {
+		switch n := el.(type) {
+		case int64:
+			nums[i] = float64(n)
+		case float64:
+			nums[i] = n
+			isFloat = true
+		default:
+			builtinError("%s expects an int[] or float[] argument", name)
+		}
+	}
+	return nums, isFloat
+}
+
+// arrayArg returns the array argument of a go.array builtin.
+func arrayArg(name string, arg interface{}) []interface{} {
+	arr, ok := arg.([]interface{})
+	if !ok {
+		builtinError("%s expects an array, got %s", name, typeName(arg))
+	}
+	return arr
+}
+
+// mapArg returns the map argument of a go.map builtin.
+func mapArg(name string, arg interface{}) map[interface{}]interface{} {
+	m, ok := arg.(map[interface{}]interface{})
+	if !ok {
+		builtinError("%s expects a map, got %s", name, typeName(arg))
+	}
+	return m
+}
+
+// sortedKeys returns the keys of m in order: numbers ascending, strings
+// alphabetically, and anything else by how it prints.
+func sortedKeys(m map[interface{}]interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if a, b, ok := floatOperands(keys[i], keys[j]); ok {
+			return a < b
+		}
+		switch a := keys[i].(type) {
+		case int64:
+			if b, ok := keys[j].(int64); ok {
+				return a < b
+			}
+		case string:
+			if b, ok := keys[j].(string); ok {
+				return a < b
+			}
+		}
+		return formatValue(keys[i]) < formatValue(keys[j])
+	})
+	return keys
+}
+
+// numberArg returns argument i of a go.math builtin, an int64 or a float64.
+func numberArg(name string, args []interface{}, i int) interface{} {
+	if i >= len(args) {
+		builtinError("%s is missing argument %d", name, i+1)
+	}
+	switch args[i].(type) {
+	case int64, float64:
+		return args[i]
+	}
+	builtinError("%s expects int or float arguments", name)
+	return nil
+}
+
+// roundedInt rounds the argument of go.math.floor or go.math.ceil to an int.
+// NaN, the infinities and floats beyond the int range have no int to round to.
+func roundedInt(name string, args []interface{}, round func(float64) float64) int64 {
+	f, ok := numberArg(name, args, 0).(float64)
+	if !ok {
+		return args[0].(int64)
+	}
+	r := round(f)
+	if math.IsNaN(r) || r < math.MinInt64 || r >= math.MaxInt64 {
+		builtinError("%s of %v is out of the int range", name, f)
+	}
+	return int64(r)
+}
+
+// minMax returns whichever numeric argument of go.math.min or go.math.max
+// better prefers. The result is an int when both are ints, a float otherwise.
+func minMax(name string, args []interface{}, better func(a, b float64) bool) interface{} {
+	a, b := numberArg(name, args, 0), numberArg(name, args, 1)
+	x, _ := toFloat(a)
+	y, _ := toFloat(b)
+	result := a
+	if better(y, x) {
+		result = b
+	}
+	_, aInt := a.(int64)
+	_, bInt := b.(int64)
+	if aInt && bInt {
+		return result
+	}
+	f, _ := toFloat(result)
+	return f
+}
+
+// compileRegex returns the compiled pattern, compiling it on first use. An
+// invalid pattern is reported on stderr and gives nil.
+func compileRegex(name, pattern string) *regexp.Regexp {
+	regexMu.Lock()
+	defer regexMu.Unlock()
+	if re, ok := regexCache[pattern]; ok {
+		return re
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Regex error: %s: invalid pattern %q: %v\n", name, pattern, err)
+		return nil
+	}
+	regexCache[pattern] = re
+	return re
+}
+
+// stringArgs extracts the n string arguments of a builtin.
+func stringArgs(name string, args []interface{}, n int) []string {
+	if len(args) != n && n == 1 {
+		builtinError("%s expects 1 argument, got %d", name, len(args))
+	}
+	if len(args) != n {
+		builtinError("%s expects %d arguments, got %d", name, n, len(args))
+	}
+	strs := make([]string, n)
+	for i, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			builtinError("%s expects string arguments, got %s", name, typeName(arg))
+		}
+		strs[i] = s
+	}
+	return strs
+}
+
+// convArg extracts the single string argument of the go.conv builtins,
+// trimmed of surrounding whitespace.
+func convArg(name string, args []interface{}) string {
+	if len(args) != 1 {
+		builtinError("%s expects 1 argument, got %d", name, len(args))
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		builtinError("%s expects a string argument", name)
+	}
+	return strings.TrimSpace(s)
+}
+
+// extremeOf returns the element of a numeric array argument for which better
+// holds against every other element, keeping the element's original type.
+func extremeOf(name string, args []interface{}, better func(a, b float64) bool) interface{} {
+	nums, _ := numericArrayArg(name, args)
+	if len(nums) == 0 {
+		builtinError("%s of an empty array", name)
+	}
+	best := 0
+	for i := range nums {
+		if better(nums[i], nums[best]) {
+			best = i
+		}
+	}
+	return args[0].([]interface{})[best]
+}
+
+// titleCase uppercases the first letter of every word and lowercases the rest,
+// keeping the original whitespace between words.
+func titleCase(s string) string {
+	runes := []rune(s)
+	startOfWord := true
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			startOfWord = true
+			continue
+		}
+		if startOfWord {
+			runes[i] = unicode.ToUpper(r)
+		} else {
+			runes[i] = unicode.ToLower(r)
+		}
+		startOfWord = false
+	}
+	return string(runes)
+}
+
+// capitalize uppercases the first non-whitespace letter of s and leaves the
+// rest untouched.
+func capitalize(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if !unicode.IsSpace(r) {
+			runes[i] = unicode.ToUpper(r)
+			break
+		}
+	}
+	return string(runes)
+}
+
+// scan parses s according to format with fmt.Sscanf, returning one value per
+// verb: %d gives an int, %f, %e and %g a float, %s a string and %t a bool.
+func scan(s, format string) []interface{} {
+	var targets []interface{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(format) {
+			builtinError("go.strings.scan: format %q ends with '%%'", format)
+		}
+		switch format[i] {
+		case '%':
+		case 'd':
+			targets = append(targets, new(int64))
+		case 'f', 'e', 'g':
+			targets = append(targets, new(float64))
+		case 's':
+			targets = append(targets, new(string))
+		case 't':
+			targets = append(targets, new(bool))
+		default:
+			builtinError("go.strings.scan: unsupported verb %%%c in format %q", format[i], format)
+		}
+	}
+	if _, err := fmt.Sscanf(s, format, targets...); err != nil {
+		builtinError("go.strings.scan: cannot parse %q with format %q: %v", s, format, err)
+	}
+	values := make([]interface{}, len(targets))
+	for i, target := range targets {
+		switch t := target.(type) {
+		case *int64:
+			values[i] = *t
+		case *float64:
+			values[i] = *t
+		case *string:
+			values[i] = *t
+		case *bool:
+			values[i] = *t
+		}
+	}
+	return values
+}
@@ -0,0 +1,473 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// builtinCase is a call to a builtin and the value it should return.
+type builtinCase struct {
+	name    string
+	builtin string
+	args    []interface{}
+	want    interface{}
+}
+
+func runBuiltinCases(t *testing.T, tests []builtinCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Builtins[tt.builtin](tt.args)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s(%v) = %#v, want %#v", tt.builtin, tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+// builtinErrorCase is a call to a builtin and the runtime error it should raise.
+type builtinErrorCase struct {
+	name    string
+	builtin string
+	args    []interface{}
+	want    string
+}
+
+func runBuiltinErrorCases(t *testing.T, tests []builtinErrorCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				err, ok := recover().(*RuntimeError)
+				if !ok {
+					t.Fatalf("%s(%v) did not raise a runtime error", tt.builtin, tt.args)
+				}
+				if !strings.Contains(err.Message, tt.want) {
+					t.Errorf("%s(%v) error = %q, want it to contain %q", tt.builtin, tt.args, err.Message, tt.want)
+				}
+			}()
+			Builtins[tt.builtin](tt.args)
+		})
+	}
+}
+
+func TestRegexBuiltins(t *testing.T) {
+	runBuiltinCases(t, []builtinCase{
+		{"match", "go.regex.match", []interface{}{"b+", "abbc"}, true},
+		{"no match", "go.regex.match", []interface{}{"x", "abc"}, false},
+		{"find", "go.regex.find", []interface{}{"[0-9]+", "ab12cd345"}, "12"},
+		{"find nothing", "go.regex.find", []interface{}{"[0-9]+", "abc"}, ""},
+		{"findAll", "go.regex.findAll", []interface{}{"[0-9]+", "ab12cd345"}, []interface{}{"12", "345"}},
+		{"findAll nothing", "go.regex.findAll", []interface{}{"[0-9]+", "abc"}, []interface{}{}},
+		{"invalid pattern match", "go.regex.match", []interface{}{"(", "abc"}, false},
+		{"invalid pattern find", "go.regex.find", []interface{}{"[", "abc"}, ""},
+		{"invalid pattern findAll", "go.regex.findAll", []interface{}{"*", "abc"}, nil},
+	})
+}
+
+func TestRandBuiltins(t *testing.T) {
+	Builtins["go.rand.seed"]([]interface{}{int64(1)})
+	for i := 0; i < 100; i++ {
+		n := Builtins["go.rand.int"]([]interface{}{int64(3)}).(int64)
+		if n < 0 || n >= 3 {
+			t.Fatalf("go.rand.int(3) = %d, want 0 to 2", n)
+		}
+	}
+	runBuiltinCases(t, []builtinCase{
+		{"bound 1", "go.rand.int", []interface{}{int64(1)}, int64(0)},
+		{"zero bound", "go.rand.int", []interface{}{int64(0)}, int64(-1)},
+		{"negative bound", "go.rand.int", []interface{}{int64(-5)}, int64(-1)},
+	})
+	f := Builtins["go.rand.float"](nil).(float64)
+	if f < 0 || f >= 1 {
+		t.Errorf("go.rand.float() = %v, want [0, 1)", f)
+	}
+}
+
+func TestMathBuiltins(t *testing.T) {
+	runBuiltinCases(t, []builtinCase{
+		{"abs int", "go.math.abs", []interface{}{int64(-3)}, int64(3)},
+		{"abs float", "go.math.abs", []interface{}{-2.5}, 2.5},
+		{"min ints", "go.math.min", []interface{}{int64(2), int64(1)}, int64(1)},
+		{"max mixed", "go.math.max", []interface{}{int64(2), 2.5}, 2.5},
+		{"pow", "go.math.pow", []interface{}{int64(2), int64(10)}, 1024.0},
+		{"sqrt", "go.math.sqrt", []interface{}{int64(9)}, 3.0},
+		{"floor", "go.math.floor", []interface{}{-1.5}, int64(-2)},
+		{"ceil", "go.math.ceil", []interface{}{1.2}, int64(2)},
+		{"floor int", "go.math.floor", []interface{}{int64(math.MaxInt64)}, int64(math.MaxInt64)},
+		{"ceil smallest int", "go.math.ceil", []interface{}{float64(math.MinInt64)}, int64(math.MinInt64)},
+	})
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"sqrt negative", "go.math.sqrt", []interface{}{int64(-1)}, "go.math.sqrt of a negative number"},
+		{"floor NaN", "go.math.floor", []interface{}{math.NaN()}, "go.math.floor of NaN is out of the int range"},
+		{"floor +Inf", "go.math.floor", []interface{}{math.Inf(1)}, "go.math.floor of +Inf is out of the int range"},
+		{"ceil -Inf", "go.math.ceil", []interface{}{math.Inf(-1)}, "go.math.ceil of -Inf is out of the int range"},
+		{"ceil too large", "go.math.ceil", []interface{}{1e19}, "go.math.ceil of 1e+19 is out of the int range"},
+		{"floor too small", "go.math.floor", []interface{}{-1e19}, "go.math.floor of -1e+19 is out of the int range"},
+		{"floor string", "go.math.floor", []interface{}{"1"}, "go.math.floor expects int or float arguments"},
+	})
+}
+
+func TestFileWriteBuiltins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+	src := fmt.Sprintf(`
+let path string >> %q
+fnc write() >> bool {
+    return go.file.writeAll(path, "one\nstale")
+}
+fnc rewrite() >> bool {
+    return go.file.writeAll(path, "one\n")
+}
+fnc add() >> bool {
+    return go.file.append(path, "two\tend\n")
+}
+fnc missingDir() >> bool {
+    return go.file.writeAll(path + ".d/out.txt", "x")
+}
+`, path)
+	runCallCases(t, src, []callCase{
+		{"writeAll", "write", true},
+		{"writeAll truncates", "rewrite", true},
+		{"append", "add", true},
+		{"missing directory", "missingDir", false},
+	})
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "one\ntwo\tend\n"; string(data) != want {
+		t.Errorf("file holds %q, want %q", data, want)
+	}
+}
+
+func TestMapMergeBuiltin(t *testing.T) {
+	a := map[interface{}]interface{}{"a": int64(1), "b": int64(2)}
+	b := map[interface{}]interface{}{"b": int64(20), "c": int64(30)}
+	runBuiltinCases(t, []builtinCase{
+		{"later wins", "go.map.merge", []interface{}{a, b},
+			map[interface{}]interface{}{"a": int64(1), "b": int64(20), "c": int64(30)}},
+		{"empty", "go.map.merge", []interface{}{a, map[interface{}]interface{}{}}, a},
+		{"not maps", "go.map.merge", []interface{}{a, int64(1)}, nil},
+		{"one argument", "go.map.merge", []interface{}{a}, nil},
+	})
+	if len(a) != 2 || a["b"] != int64(2) {
+		t.Errorf("go.map.merge changed its first argument to %v", a)
+	}
+}
+
+func TestAggregateBuiltins(t *testing.T) {
+	ints := []interface{}{int64(3), int64(-1), int64(4)}
+	floats := []interface{}{1.5, 2.5}
+	runBuiltinCases(t, []builtinCase{
+		{"sum ints", "go.math.sum", []interface{}{ints}, int64(6)},
+		{"sum floats", "go.math.sum", []interface{}{floats}, 4.0},
+		{"sum empty", "go.math.sum", []interface{}{[]interface{}{}}, int64(0)},
+		{"avg ints", "go.math.avg", []interface{}{ints}, 2.0},
+		{"avg floats", "go.math.avg", []interface{}{floats}, 2.0},
+		{"minOf", "go.math.minOf", []interface{}{ints}, int64(-1)},
+		{"maxOf", "go.math.maxOf", []interface{}{ints}, int64(4)},
+		{"maxOf floats", "go.math.maxOf", []interface{}{floats}, 2.5},
+	})
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"avg empty", "go.math.avg", []interface{}{[]interface{}{}}, "go.math.avg of an empty array"},
+		{"minOf empty", "go.math.minOf", []interface{}{[]interface{}{}}, "go.math.minOf of an empty array"},
+		{"sum strings", "go.math.sum", []interface{}{[]interface{}{"a"}}, "go.math.sum expects an int[] or float[] argument"},
+		{"maxOf count", "go.math.maxOf", []interface{}{ints, ints}, "go.math.maxOf expects 1 argument, got 2"},
+	})
+}
+
+func TestTitleCaseBuiltins(t *testing.T) {
+	runBuiltinCases(t, []builtinCase{
+		{"title", "go.strings.title", []interface{}{"hello wORLD"}, "Hello World"},
+		{"title keeps whitespace", "go.strings.title", []interface{}{" a\tb  c"}, " A\tB  C"},
+		{"title multi-byte", "go.strings.title", []interface{}{"élan über"}, "Élan Über"},
+		{"title empty", "go.strings.title", []interface{}{""}, ""},
+		{"capitalize", "go.strings.capitalize", []interface{}{"hello wORLD"}, "Hello wORLD"},
+		{"capitalize leading space", "go.strings.capitalize", []interface{}{"  ok"}, "  Ok"},
+		{"capitalize empty", "go.strings.capitalize", []interface{}{""}, ""},
+		{"title non-string", "go.strings.title", []interface{}{int64(1)}, nil},
+	})
+}
+
+func TestScanBuiltin(t *testing.T) {
+	runBuiltinCases(t, []builtinCase{
+		{"mixed verbs", "go.strings.scan", []interface{}{"Ann 42 1.5 true", "%s %d %f %t"},
+			[]interface{}{"Ann", int64(42), 1.5, true}},
+		{"literal text", "go.strings.scan", []interface{}{"x=3,y=4", "x=%d,y=%d"}, []interface{}{int64(3), int64(4)}},
+		{"percent sign", "go.strings.scan", []interface{}{"50%", "%d%%"}, []interface{}{int64(50)}},
+		{"no verbs", "go.strings.scan", []interface{}{"", ""}, []interface{}{}},
+	})
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"mismatch", "go.strings.scan", []interface{}{"abc", "%d"}, `go.strings.scan: cannot parse "abc" with format "%d"`},
+		{"unsupported verb", "go.strings.scan", []interface{}{"1", "%x"}, "go.strings.scan: unsupported verb %x"},
+		{"trailing percent", "go.strings.scan", []interface{}{"1", "%d%"}, `go.strings.scan: format "%d%" ends with '%'`},
+		{"non-string", "go.strings.scan", []interface{}{int64(1), "%d"}, "go.strings.scan expects a string and a format string"},
+		{"count", "go.strings.scan", []interface{}{"1"}, "go.strings.scan expects 2 arguments, got 1"},
+	})
+}
+
+func TestConvBuiltins(t *testing.T) {
+	runBuiltinCases(t, []builtinCase{
+		{"toInt", "go.conv.toInt", []interface{}{"42"}, int64(42)},
+		{"toInt negative with spaces", "go.conv.toInt", []interface{}{" -7\n"}, int64(-7)},
+		{"toFloat", "go.conv.toFloat", []interface{}{"2.5"}, 2.5},
+		{"toFloat of an int", "go.conv.toFloat", []interface{}{"3"}, 3.0},
+		{"toBool", "go.conv.toBool", []interface{}{"true\n"}, true},
+		{"toBool false", "go.conv.toBool", []interface{}{"false"}, false},
+	})
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"toInt of a float", "go.conv.toInt", []interface{}{"2.5"}, `go.conv.toInt: cannot convert "2.5" to int`},
+		{"toInt of text", "go.conv.toInt", []interface{}{"abc"}, `go.conv.toInt: cannot convert "abc" to int`},
+		{"toFloat of text", "go.conv.toFloat", []interface{}{""}, `go.conv.toFloat: cannot convert "" to float`},
+		{"toBool of yes", "go.conv.toBool", []interface{}{"yes"}, `go.conv.toBool: cannot convert "yes" to bool`},
+		{"toInt of an int", "go.conv.toInt", []interface{}{int64(1)}, "go.conv.toInt expects a string argument"},
+		{"toInt count", "go.conv.toInt", nil, "go.conv.toInt expects 1 argument, got 0"},
+	})
+}
+
+func TestArrayBuiltins(t *testing.T) {
+	xs := []interface{}{int64(1), int64(2)}
+	runBuiltinCases(t, []builtinCase{
+		{"push", "go.array.push", []interface{}{xs, int64(3)}, []interface{}{int64(1), int64(2), int64(3)}},
+		{"push to empty", "go.array.push", []interface{}{[]interface{}{}, "a"}, []interface{}{"a"}},
+		{"pop", "go.array.pop", []interface{}{xs}, int64(2)},
+		{"append", "go.array.append", []interface{}{xs, []interface{}{int64(3)}}, []interface{}{int64(1), int64(2), int64(3)}},
+		{"append empty", "go.array.append", []interface{}{[]interface{}{}, []interface{}{}}, []interface{}{}},
+		{"contains", "go.array.contains", []interface{}{xs, int64(2)}, true},
+		{"does not contain", "go.array.contains", []interface{}{xs, int64(5)}, false},
+		{"contains an array", "go.array.contains", []interface{}{[]interface{}{xs, []interface{}{int64(3)}}, []interface{}{int64(3)}}, true},
+		{"does not contain an array", "go.array.contains", []interface{}{[]interface{}{xs, []interface{}{int64(3)}}, []interface{}{int64(2), int64(1)}}, false},
+	})
+	if !reflect.DeepEqual(xs, []interface{}{int64(1), int64(2)}) {
+		t.Errorf("the go.array builtins changed their argument to %v", xs)
+	}
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"pop empty", "go.array.pop", []interface{}{[]interface{}{}}, "go.array.pop of an empty array"},
+		{"push to a non-array", "go.array.push", []interface{}{int64(1), int64(2)}, "go.array.push expects an array, got int"},
+		{"append count", "go.array.append", []interface{}{xs}, "go.array.append expects 2 arguments, got 1"},
+		{"contains in a string", "go.array.contains", []interface{}{"abc", "a"}, "go.array.contains expects an array, got string"},
+	})
+}
+
+func TestSortBuiltin(t *testing.T) {
+	runBuiltinCases(t, []builtinCase{
+		{"ints", "go.array.sort", []interface{}{[]interface{}{int64(3), int64(-1), int64(2)}}, []interface{}{int64(-1), int64(2), int64(3)}},
+		{"floats", "go.array.sort", []interface{}{[]interface{}{2.5, 0.5}}, []interface{}{0.5, 2.5}},
+		{"strings", "go.array.sort", []interface{}{[]interface{}{"b", "B", "a"}}, []interface{}{"B", "a", "b"}},
+		{"empty", "go.array.sort", []interface{}{[]interface{}{}}, []interface{}{}},
+	})
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"bools", "go.array.sort", []interface{}{[]interface{}{true, false}}, "go.array.sort cannot order bool and bool"},
+		{"mixed", "go.array.sort", []interface{}{[]interface{}{int64(1), "a"}}, "go.array.sort cannot order"},
+		{"sortBy without a function", "go.array.sortBy", []interface{}{[]interface{}{}, int64(1)}, "go.array.sortBy expects a function as its second argument, got int"},
+	})
+}
+
+func TestSortBy(t *testing.T) {
+	src := `
+struct Person {
+    name string
+    age int
+}
+fnc byLength() >> string[] {
+    return go.array.sortBy(["ccc", "a", "bb", "d"], fnc(a string, b string) >> bool {
+        return go.strings.length(a) < go.strings.length(b)
+    })
+}
+fnc descending(a int, b int) >> bool {
+    return a > b
+}
+fnc named() >> int[] {
+    return go.array.sortBy([1, 3, 2], descending)
+}
+fnc oldest() >> string {
+    let people Person[] >> [Person{ name: "Ann", age: 30 }, Person{ name: "Bo", age: 41 }]
+    let sorted Person[] >> go.array.sortBy(people, fnc(a Person, b Person) >> bool {
+        return a.age > b.age
+    })
+    let first Person >> sorted[0]
+    return first.name
+}
+`
+	runCallCases(t, src, []callCase{
+		{"stable", "byLength", []interface{}{"a", "d", "bb", "ccc"}},
+		{"named comparator", "named", []interface{}{int64(3), int64(2), int64(1)}},
+		{"structs", "oldest", "Bo"},
+	})
+}
+
+func TestStringBuiltins(t *testing.T) {
+	runBuiltinCases(t, []builtinCase{
+		{"replace", "go.strings.replace", []interface{}{"a-b-c", "-", "+"}, "a+b+c"},
+		{"replace nothing", "go.strings.replace", []interface{}{"abc", "x", "y"}, "abc"},
+		{"contains", "go.strings.contains", []interface{}{"hello", "ell"}, true},
+		{"contains empty", "go.strings.contains", []interface{}{"hello", ""}, true},
+		{"indexOf", "go.strings.indexOf", []interface{}{"hello", "l"}, int64(2)},
+		{"indexOf multi-byte", "go.strings.indexOf", []interface{}{"héllo", "l"}, int64(2)},
+		{"indexOf missing", "go.strings.indexOf", []interface{}{"hello", "z"}, int64(-1)},
+		{"repeat", "go.strings.repeat", []interface{}{"ab", int64(3)}, "ababab"},
+		{"repeat zero", "go.strings.repeat", []interface{}{"ab", int64(0)}, ""},
+		{"join", "go.strings.join", []interface{}{[]interface{}{"a", "b"}, ", "}, "a, b"},
+		{"join empty", "go.strings.join", []interface{}{[]interface{}{}, ","}, ""},
+	})
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"replace count", "go.strings.replace", []interface{}{"a", "b"}, "go.strings.replace expects 3 arguments, got 2"},
+		{"contains non-string", "go.strings.contains", []interface{}{"a", int64(1)}, "go.strings.contains expects string arguments, got int"},
+		{"repeat negative", "go.strings.repeat", []interface{}{"a", int64(-1)}, "go.strings.repeat count must not be negative, got -1"},
+		{"repeat float count", "go.strings.repeat", []interface{}{"a", 1.5}, "go.strings.repeat expects a string and an int"},
+		{"join non-strings", "go.strings.join", []interface{}{[]interface{}{int64(1)}, ","}, "go.strings.join expects a string[], found int"},
+	})
+}
+
+func TestPrefixBuiltins(t *testing.T) {
+	runBuiltinCases(t, []builtinCase{
+		{"hasPrefix", "go.strings.hasPrefix", []interface{}{"filename.go", "file"}, true},
+		{"no prefix", "go.strings.hasPrefix", []interface{}{"filename.go", "name"}, false},
+		{"hasSuffix", "go.strings.hasSuffix", []interface{}{"filename.go", ".go"}, true},
+		{"empty suffix", "go.strings.hasSuffix", []interface{}{"x", ""}, true},
+		{"length", "go.strings.length", []interface{}{"hello"}, int64(5)},
+		{"length in characters", "go.strings.length", []interface{}{"héllo 日本"}, int64(8)},
+		{"length empty", "go.strings.length", []interface{}{""}, int64(0)},
+	})
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"hasPrefix count", "go.strings.hasPrefix", []interface{}{"a"}, "go.strings.hasPrefix expects 2 arguments, got 1"},
+		{"length count", "go.strings.length", []interface{}{"a", "b"}, "go.strings.length expects 1 argument, got 2"},
+		{"length of an int", "go.strings.length", []interface{}{int64(1)}, "go.strings.length expects string arguments, got int"},
+	})
+}
+
+func TestEnvBuiltins(t *testing.T) {
+	t.Setenv("TOX_TEST_VAR", "value")
+	saved := ProgramArgs
+	ProgramArgs = []string{"a", "b c"}
+	defer func() { ProgramArgs = saved }()
+
+	runBuiltinCases(t, []builtinCase{
+		{"get", "go.env.get", []interface{}{"TOX_TEST_VAR"}, "value"},
+		{"get unset", "go.env.get", []interface{}{"TOX_TEST_UNSET"}, ""},
+		{"set", "go.env.set", []interface{}{"TOX_TEST_VAR", "changed"}, true},
+		{"get after set", "go.env.get", []interface{}{"TOX_TEST_VAR"}, "changed"},
+		{"set invalid name", "go.env.set", []interface{}{"", "x"}, false},
+		{"args", "go.os.args", nil, []interface{}{"a", "b c"}},
+	})
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"get name type", "go.env.get", []interface{}{int64(1)}, "go.env.get expects string arguments, got int"},
+		{"args count", "go.os.args", []interface{}{int64(1)}, "go.os.args expects no arguments, got 1"},
+	})
+}
+
+func TestTimeBuiltins(t *testing.T) {
+	runBuiltinCases(t, []builtinCase{
+		{"format", "go.time.format", []interface{}{int64(1700000000), "2006-01-02 15:04:05"}, "2023-11-14 22:13:20"},
+		{"epoch", "go.time.format", []interface{}{int64(0), time.RFC3339}, "1970-01-01T00:00:00Z"},
+		{"before the epoch", "go.time.format", []interface{}{int64(-86400), "2006-01-02"}, "1969-12-31"},
+		{"sleep zero seconds", "go.time.sleepSeconds", []interface{}{int64(0)}, nil},
+	})
+	before := time.Now().Unix()
+	unix := Builtins["go.time.unix"](nil).(int64)
+	millis := Builtins["go.time.unixMillis"](nil).(int64)
+	after := time.Now().Unix()
+	if unix < before || unix > after {
+		t.Errorf("go.time.unix() = %d, want %d to %d", unix, before, after)
+	}
+	if millis/1000 < before || millis/1000 > after {
+		t.Errorf("go.time.unixMillis() = %d, want %d to %d in seconds", millis, before, after)
+	}
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"format of a string", "go.time.format", []interface{}{"now", "2006"}, "go.time.format expects a unix time and a layout string"},
+		{"format count", "go.time.format", []interface{}{int64(0)}, "go.time.format expects 2 arguments, got 1"},
+		{"sleep float", "go.time.sleepSeconds", []interface{}{0.5}, "go.time.sleepSeconds expects an int, got float"},
+	})
+}
+
+func TestMapBuiltins(t *testing.T) {
+	m := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{"b": int64(2), "a": int64(1), "c": int64(3)}
+	}
+	runBuiltinCases(t, []builtinCase{
+		{"keys in order", "go.map.keys", []interface{}{m()}, []interface{}{"a", "b", "c"}},
+		{"int keys in order", "go.map.keys", []interface{}{map[interface{}]interface{}{int64(10): "x", int64(-1): "y", int64(2): "z"}},
+			[]interface{}{int64(-1), int64(2), int64(10)}},
+		{"values in key order", "go.map.values", []interface{}{m()}, []interface{}{int64(1), int64(2), int64(3)}},
+		{"keys of an empty map", "go.map.keys", []interface{}{map[interface{}]interface{}{}}, []interface{}{}},
+		{"has", "go.map.has", []interface{}{m(), "a"}, true},
+		{"has not", "go.map.has", []interface{}{m(), "z"}, false},
+	})
+
+	deleted := m()
+	Builtins["go.map.delete"]([]interface{}{deleted, "b"})
+	if !reflect.DeepEqual(deleted, map[interface{}]interface{}{"a": int64(1), "c": int64(3)}) {
+		t.Errorf("after go.map.delete(m, \"b\"), m = %v", deleted)
+	}
+	Builtins["go.map.delete"]([]interface{}{deleted, "missing"})
+	if len(deleted) != 2 {
+		t.Errorf("deleting a missing key changed the map to %v", deleted)
+	}
+
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"keys of an array", "go.map.keys", []interface{}{[]interface{}{}}, "go.map.keys expects a map"},
+		{"has count", "go.map.has", []interface{}{m()}, "go.map.has expects 2 arguments, got 1"},
+		{"delete from a non-map", "go.map.delete", []interface{}{"m", "a"}, "go.map.delete expects a map"},
+	})
+}
+
+func TestReadLinesBuiltin(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	runBuiltinCases(t, []builtinCase{
+		{"lines", "go.file.readLines", []interface{}{write("lines.txt", "one\ntwo\n")}, []interface{}{"one", "two"}},
+		{"no final newline", "go.file.readLines", []interface{}{write("last.txt", "one\ntwo")}, []interface{}{"one", "two"}},
+		{"CRLF", "go.file.readLines", []interface{}{write("crlf.txt", "one\r\ntwo\r\n")}, []interface{}{"one", "two"}},
+		{"blank lines", "go.file.readLines", []interface{}{write("blank.txt", "one\n\ntwo\n")}, []interface{}{"one", "", "two"}},
+		{"empty file", "go.file.readLines", []interface{}{write("empty.txt", "")}, []interface{}{}},
+		{"missing file", "go.file.readLines", []interface{}{filepath.Join(dir, "missing.txt")}, nil},
+		{"directory", "go.file.readLines", []interface{}{dir}, nil},
+	})
+}
+
+func TestDirListBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.txt", "a.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("xy"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	runBuiltinCases(t, []builtinCase{
+		{"list sorted", "go.dir.list", []interface{}{dir}, []interface{}{"a.txt", "b.txt", "sub"}},
+		{"list empty", "go.dir.list", []interface{}{filepath.Join(dir, "sub")}, []interface{}{}},
+		{"list a file", "go.dir.list", []interface{}{filepath.Join(dir, "a.txt")}, nil},
+		{"list missing", "go.dir.list", []interface{}{filepath.Join(dir, "missing")}, nil},
+		{"listDetailed a file", "go.dir.listDetailed", []interface{}{filepath.Join(dir, "a.txt")}, nil},
+	})
+
+	details, ok := Builtins["go.dir.listDetailed"]([]interface{}{dir}).([]interface{})
+	if !ok || len(details) != 3 {
+		t.Fatalf("go.dir.listDetailed(dir) = %v, want 3 entries", details)
+	}
+	first := details[0].(map[interface{}]interface{})
+	if first["name"] != "a.txt" || first["size"] != int64(2) || first["isDir"] != false {
+		t.Errorf("first entry = name %v, size %v, isDir %v, want a.txt, 2, false", first["name"], first["size"], first["isDir"])
+	}
+	if sub := details[2].(map[interface{}]interface{}); sub["name"] != "sub" || sub["isDir"] != true {
+		t.Errorf("last entry = name %v, isDir %v, want sub, true", sub["name"], sub["isDir"])
+	}
+
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"list path type", "go.dir.list", []interface{}{int64(1)}, "go.dir.list expects string arguments, got int"},
+	})
+}
@@ -0,0 +1,104 @@
+package evaluator
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Concurrency builtins: go.async runs a function on its own goroutine, and
+// channels, referred to by int handles like open files, let goroutines pass
+// values to each other.
+//
+// Environments are safe for concurrent use, but arrays, maps and structs
+// shared between goroutines are not: hand them over through a channel.
+
+var (
+	channelsMu        sync.Mutex
+	channels                = map[int64]chan interface{}{}
+	nextChannelHandle int64 = 1
+)
+
+// go.async calls back into the evaluator, which itself refers to Builtins, so
+// these are registered in init to avoid an initialization cycle.
+func init() {
+	Builtins["go.async"] = builtinAsync
+	Builtins["go.channel.new"] = builtinChannelNew
+	Builtins["go.channel.send"] = builtinChannelSend
+	Builtins["go.channel.recv"] = builtinChannelRecv
+}
+
+// go.async(fn) runs a zero-argument function on a new goroutine. A runtime
+// error in it is reported on stderr without stopping the rest of the program.
+func builtinAsync(args []interface{}) interface{} {
+	if len(args) != 1 {
+		builtinError("go.async expects 1 argument, got %d", len(args))
+	}
+	fn, ok := args[0].(*Function)
+	if !ok {
+		builtinError("go.async expects a function, got %s", typeName(args[0]))
+	}
+	if len(fn.Stmt.Params) != 0 {
+		builtinError("go.async expects a function without parameters, '%s' takes %d", fn.Stmt.Name, len(fn.Stmt.Params))
+	}
+	go func() {
+		var err error
+		defer func() {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Runtime error in go.async:", err)
+			}
+		}()
+		defer catchRuntimeError(&err)
+		callFunction(fn, nil, nil)
+	}()
+	return true
+}
+
+// go.channel.new([capacity]) creates a channel and returns its handle.
+func builtinChannelNew(args []interface{}) interface{} {
+	capacity := int64(0)
+	if len(args) == 1 {
+		n, ok := args[0].(int64)
+		if !ok || n < 0 {
+			builtinError("go.channel.new expects a non-negative int capacity")
+		}
+		capacity = n
+	}
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	handle := nextChannelHandle
+	nextChannelHandle++
+	channels[handle] = make(chan interface{}, capacity)
+	return handle
+}
+
+// go.channel.send(ch, value) blocks until value is received (or buffered).
+func builtinChannelSend(args []interface{}) interface{} {
+	if len(args) != 2 {
+		builtinError("go.channel.send expects 2 arguments, got %d", len(args))
+	}
+	channelArg("go.channel.send", args[0]) <- args[1]
+	return true
+}
+
+// go.channel.recv(ch) blocks until a value is sent and returns it.
+func builtinChannelRecv(args []interface{}) interface{} {
+	if len(args) != 1 {
+		builtinError("go.channel.recv expects 1 argument, got %d", len(args))
+	}
+	return <-channelArg("go.channel.recv", args[0])
+}
+
+func channelArg(name string, arg interface{}) chan interface{} {
+	handle, ok := arg.(int64)
+	if !ok {
+		builtinError("%s expects a channel handle, got %s", name, typeName(arg))
+	}
+	channelsMu.Lock()
+	ch, ok := channels[handle]
+	channelsMu.Unlock()
+	if !ok {
+		builtinError("%s: unknown channel %d", name, handle)
+	}
+	return ch
+}
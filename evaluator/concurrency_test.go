@@ -0,0 +1,45 @@
+package evaluator
+
+import "testing"
+
+func TestChannels(t *testing.T) {
+	src := `
+let ch int >> go.channel.new()
+let buffered int >> go.channel.new(2)
+fnc worker() {
+    go.channel.send(ch, 21)
+}
+fnc workers() >> int {
+    go.async(worker)
+    go.async(worker)
+    return go.channel.recv(ch) + go.channel.recv(ch)
+}
+fnc closure() >> string {
+    let done int >> go.channel.new()
+    go.async(fnc() {
+        go.channel.send(done, "done")
+    })
+    return go.channel.recv(done)
+}
+fnc inOrder() >> string {
+    go.channel.send(buffered, "a")
+    go.channel.send(buffered, "b")
+    return go.channel.recv(buffered) + go.channel.recv(buffered)
+}
+`
+	runCallCases(t, src, []callCase{
+		{"named function", "workers", int64(42)},
+		{"function literal", "closure", "done"},
+		{"buffered", "inOrder", "ab"},
+	})
+}
+
+func TestConcurrencyErrors(t *testing.T) {
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"async of a non-function", "go.async", []interface{}{int64(1)}, "go.async expects a function, got int"},
+		{"async count", "go.async", nil, "go.async expects 1 argument, got 0"},
+		{"negative capacity", "go.channel.new", []interface{}{int64(-1)}, "go.channel.new expects a non-negative int capacity"},
+		{"unknown channel", "go.channel.recv", []interface{}{int64(1 << 40)}, "go.channel.recv: unknown channel 1099511627776"},
+		{"non-handle", "go.channel.send", []interface{}{"ch", int64(1)}, "go.channel.send expects a channel handle, got string"},
+	})
+}
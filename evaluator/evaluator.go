@@ -5,21 +5,60 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/notrealandy/tox/ast"
 	"github.com/notrealandy/tox/token"
 )
 
-// Environment stores variable values and supports lexical scoping
+// Environment stores variable values and supports lexical scoping. It is safe
+// for concurrent use, since go.async functions share their declaring scope.
 type Environment struct {
+	mu     sync.RWMutex
 	store  map[string]interface{}
 	parent *Environment
 }
 
+// Function is a declared function together with the environment it was
+// declared in, so its body resolves names lexically (e.g. sibling functions
+// declared in the same block).
+type Function struct {
+	Stmt *ast.FunctionStatement
+	Env  *Environment
+}
+
 type breakSignal struct{}
 type continueSignal struct{}
 
+// returnSignal carries the value of a return statement out of the blocks it
+// is nested in, up to evalFunctionBody.
+type returnSignal struct {
+	value interface{}
+}
+
+// RuntimeError is raised while evaluating a program and carries the source
+// position of the failing node when one is known.
+type RuntimeError struct {
+	Message string
+	Line    int
+	Col     int
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s on line %d:%d", e.Message, e.Line, e.Col)
+	}
+	return e.Message
+}
+
+// runtimeError aborts evaluation; the error is recovered by Run.
+func runtimeError(line, col int, format string, args ...interface{}) {
+	panic(&RuntimeError{Message: fmt.Sprintf(format, args...), Line: line, Col: col})
+}
+
 func NewEnvironment() *Environment {
 	return &Environment{store: make(map[string]interface{}), parent: nil}
 }
@@ -29,7 +68,9 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 }
 
 func (env *Environment) Get(name string) (interface{}, bool) {
+	env.mu.RLock()
 	val, ok := env.store[name]
+	env.mu.RUnlock()
 	if !ok && env.parent != nil {
 		return env.parent.Get(name)
 	}
@@ -37,91 +78,244 @@ func (env *Environment) Get(name string) (interface{}, bool) {
 }
 
 func (env *Environment) Set(name string, val interface{}) {
+	env.mu.Lock()
 	env.store[name] = val
+	env.mu.Unlock()
 }
 
 func (env *Environment) SetExisting(name string, val interface{}) bool {
+	env.mu.Lock()
 	if _, ok := env.store[name]; ok {
 		env.store[name] = val
+		env.mu.Unlock()
 		return true
 	}
+	env.mu.Unlock()
 	if env.parent != nil {
 		return env.parent.SetExisting(name, val)
 	}
 	return false
 }
 
-func getGlobalEnv(env *Environment) *Environment {
-	for env.parent != nil {
-		env = env.parent
+// Run evaluates a program in a fresh environment, calls every init function in
+// the order they were loaded (imported packages first), then calls main if it
+// is declared. It returns main's return value (or the value of the last
+// top-level statement when there is no main) and any runtime error raised on
+// the way.
+func Run(stmts []ast.Statement) (result interface{}, err error) {
+	defer catchRuntimeError(&err)
+
+	env := NewEnvironment()
+
+	// Evaluate all top-level statements to populate env
+	result = Eval(stmts, env)
+
+	// Every file may declare its own init, so they're called from the
+	// statements rather than looked up by name in env
+	for _, s := range stmts {
+		if fn, ok := s.(*ast.FunctionStatement); ok && fn.Name == "init" && fn.ReceiverType == "" {
+			callFunction(&Function{Stmt: fn, Env: env}, nil, nil)
+		}
+	}
+
+	// Now run main if it exists
+	if mainFn, ok := env.Get("main"); ok {
+		if fn, ok := mainFn.(*Function); ok {
+			result = callFunction(fn, nil, nil)
+		}
+	}
+	return result, nil
+}
+
+// CallFunction calls the function called name declared in env with already
+// evaluated arguments, returning its result and any runtime error it raised.
+func CallFunction(env *Environment, name string, args []interface{}) (result interface{}, err error) {
+	defer catchRuntimeError(&err)
+
+	fnObj, _ := env.Get(name)
+	fn, ok := fnObj.(*Function)
+	if !ok {
+		return nil, &RuntimeError{Message: fmt.Sprintf("'%s' is not a function", name)}
+	}
+	return callFunction(fn, nil, args), nil
+}
+
+// CallFunctionLimited is CallFunction for calls made before the program runs,
+// such as the optimizer's: evaluating more than maxSteps expressions or
+// nesting calls more than maxDepth deep stops the call with a runtime error.
+// It must not run alongside any other evaluation.
+func CallFunctionLimited(env *Environment, name string, args []interface{}, maxSteps, maxDepth int) (interface{}, error) {
+	hooksMu.Lock()
+	limit = &budget{steps: maxSteps, depth: maxDepth}
+	hooksMu.Unlock()
+	defer func() {
+		hooksMu.Lock()
+		limit = nil
+		hooksMu.Unlock()
+	}()
+	return CallFunction(env, name, args)
+}
+
+// budget is what is left of the limits of a CallFunctionLimited call. Calls
+// started with go.async spend it too, hence mu.
+type budget struct {
+	mu    sync.Mutex
+	steps int
+	depth int
+}
+
+// limit is nil unless a CallFunctionLimited call is running, so evalExpr and
+// callFunction only pay for a nil check.
+var limit *budget
+
+// hooksMu guards limit and profiler, which go.async goroutines read while
+// they may be set or cleared.
+var hooksMu sync.RWMutex
+
+// hooks returns the profiler and budget in effect; either may be nil.
+func hooks() (*Profiler, *budget) {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	return profiler, limit
+}
+
+func (b *budget) step() {
+	b.mu.Lock()
+	b.steps--
+	exceeded := b.steps < 0
+	b.mu.Unlock()
+	if exceeded {
+		runtimeError(0, 0, "evaluation step limit exceeded")
 	}
-	return env
 }
 
-// Eval evaluates a program (list of statements)
+func (b *budget) enter() {
+	b.mu.Lock()
+	b.depth--
+	exceeded := b.depth < 0
+	b.mu.Unlock()
+	if exceeded {
+		runtimeError(0, 0, "call depth limit exceeded")
+	}
+}
+
+func (b *budget) leave() {
+	b.mu.Lock()
+	b.depth++
+	b.mu.Unlock()
+}
+
+// undeclared is the value of a name that isn't in scope: the error message
+// itself. Inside a CallFunctionLimited call it stops the call instead, so the
+// optimizer never folds the message into the program as a constant.
+func undeclared(name string, line, col int) string {
+	if _, limit := hooks(); limit != nil {
+		runtimeError(line, col, "variable '%s' is not public or does not exist", name)
+	}
+	return fmt.Sprintf("Error: variable '%s' is not public or does not exist", name)
+}
+
+// catchRuntimeError is deferred by entry points to turn a raised RuntimeError
+// into a returned error. Any other panic is a bug and keeps unwinding.
+func catchRuntimeError(err *error) {
+	if r := recover(); r != nil {
+		rtErr, ok := r.(*RuntimeError)
+		if !ok {
+			panic(r)
+		}
+		*err = rtErr
+	}
+}
+
+// Eval evaluates a program (list of statements) and returns the value of the
+// last evaluated statement, a break/continue signal inside loops, or a return
+// signal inside functions.
 func Eval(stmts []ast.Statement, env *Environment) interface{} {
+	var result interface{}
 	for _, s := range stmts {
 		switch stmt := s.(type) {
 		case *ast.LetStatement:
 			val := evalExpr(stmt.Value, env)
 			env.Set(stmt.Name, val)
+			result = val
 		case *ast.FunctionStatement:
-			env.Set(stmt.Name, stmt)
+			env.Set(stmt.Name, &Function{Stmt: stmt, Env: env})
 		case *ast.LogFunction:
 			val := evalExpr(stmt.Value, env)
 			printValue(val)
 		case *ast.ExpressionStatement:
-			evalExpr(stmt.Expr, env)
+			result = evalExpr(stmt.Expr, env)
 		case *ast.IfStatement:
+			// Each branch runs in its own scope, so its lets don't outlive it
+			var res interface{}
 			handled := false
-			if isTruthy(evalExpr(stmt.IfCond, env)) {
-				Eval(stmt.IfBody, env)
+			if isTruthy(evalExpr(stmt.IfCond, env), stmt.Line, stmt.Col) {
+				res = Eval(stmt.IfBody, NewEnclosedEnvironment(env))
 				handled = true
 			}
 			if !handled {
 				for i, elifCond := range stmt.ElifConds {
-					if isTruthy(evalExpr(elifCond, env)) {
-						Eval(stmt.ElifBodies[i], env)
+					if isTruthy(evalExpr(elifCond, env), stmt.Line, stmt.Col) {
+						res = Eval(stmt.ElifBodies[i], NewEnclosedEnvironment(env))
 						handled = true
 						break
 					}
 				}
 			}
 			if !handled && stmt.ElseBody != nil && len(stmt.ElseBody) > 0 {
-				Eval(stmt.ElseBody, env)
+				res = Eval(stmt.ElseBody, NewEnclosedEnvironment(env))
 			}
+			// break and continue belong to the enclosing loop
+			if isSignal(res) {
+				return res
+			}
+		case *ast.MatchStatement:
+			if res := evalMatch(stmt, env); isSignal(res) {
+				return res
+			}
+		case *ast.ReturnStatement:
+			return returnSignal{value: evalExpr(stmt.Value, env)}
 		case *ast.AssignmentStatement:
-			// Field assignment: e.g., u.name >> "NewValue"
+			// Field assignment: e.g., u.name >> "NewValue" or order.customer.name >> "Andy"
 			if ident, ok := stmt.Left.(*ast.Identifier); ok && strings.Contains(ident.Value, ".") {
-				parts := strings.SplitN(ident.Value, ".", 2)
-				baseName := parts[0]
-				fieldName := parts[1]
+				baseName, fields := splitFieldPath(ident.Value, env)
 				base, ok := env.Get(baseName)
 				if !ok || base == nil {
 					fmt.Printf("Error: variable '%s' is not public or does not exist\n", baseName)
 					break
 				}
-				if obj, ok := base.(map[string]interface{}); ok {
+				// Walk down to the struct holding the field being set
+				obj, isStruct := base.(map[string]interface{})
+				holder := baseName
+				for _, fieldName := range fields[:len(fields)-1] {
+					if !isStruct {
+						break
+					}
+					obj, isStruct = obj[fieldName].(map[string]interface{})
+					holder += "." + fieldName
+				}
+				if isStruct {
 					val := evalExpr(stmt.Value, env)
-					obj[fieldName] = val
-					// Optionally, update the base variable in the environment:
-					env.Set(baseName, obj)
+					obj[fields[len(fields)-1]] = val
 				} else {
-					fmt.Printf("Error: variable '%s' is not a struct\n", baseName)
+					fmt.Printf("Error: variable '%s' is not a struct\n", holder)
 				}
 			} else if idxExpr, ok := stmt.Left.(*ast.IndexExpression); ok {
 				// Evaluate the collection and index
 				coll := evalExpr(idxExpr.Left, env)
 				idx := evalExpr(idxExpr.Index, env)
-				val := evalExpr(stmt.Value, env)
+				var val interface{}
+				if bin, ok := stmt.Value.(*ast.BinaryExpression); ok && stmt.Compound != "" && bin.Left == stmt.Left {
+					// xs[f()] += 1 reads the element through the index already
+					// evaluated, so f runs once
+					val = evalBinary(bin, indexValue(coll, idx, idxExpr), evalExpr(bin.Right, env), env)
+				} else {
+					val = evalExpr(stmt.Value, env)
+				}
 
-				// Array mutation: xs[0] >> v
+				// Array mutation: xs[0] >> v, grid[i][j] >> v
 				if arrSlice, ok := coll.([]interface{}); ok {
-					if idxInt, ok2 := idx.(int64); ok2 && int(idxInt) >= 0 && int(idxInt) < len(arrSlice) {
-						arrSlice[int(idxInt)] = val
-					} else {
-						// Optionally: print error for out-of-bounds or wrong type.
-					}
+					arrSlice[arrayIndex(arrSlice, idx, idxExpr.Line, idxExpr.Col)] = val
 				}
 
 				// Map mutation: m["key"] >> v
@@ -134,14 +328,25 @@ func Eval(stmts []ast.Statement, env *Environment) interface{} {
 				if !env.SetExisting(ident.Value, val) {
 					env.Set(ident.Value, val)
 				}
+				result = val
+			}
+		case *ast.AssertStatement:
+			if cond, ok := evalExpr(stmt.Cond, env).(bool); !ok || !cond {
+				if stmt.Message != nil {
+					runtimeError(stmt.Line, stmt.Col, "assertion failed: %v", evalExpr(stmt.Message, env))
+				}
+				runtimeError(stmt.Line, stmt.Col, "assertion failed")
 			}
 		case *ast.BreakStatement:
 			return breakSignal{}
 		case *ast.ContinueStatement:
 			return continueSignal{}
 		case *ast.WhileStatement:
-			for isTruthy(evalExpr(stmt.Condition, env)) {
-				res := Eval(stmt.Body, env)
+			for isTruthy(evalExpr(stmt.Condition, env), stmt.Line, stmt.Col) {
+				res := Eval(stmt.Body, NewEnclosedEnvironment(env))
+				if _, ok := res.(returnSignal); ok {
+					return res
+				}
 				if _, ok := res.(breakSignal); ok {
 					break
 				}
@@ -154,8 +359,11 @@ func Eval(stmts []ast.Statement, env *Environment) interface{} {
 			if stmt.Init != nil {
 				Eval([]ast.Statement{stmt.Init}, forEnv)
 			}
-			for isTruthy(evalExpr(stmt.Condition, forEnv)) {
+			for isTruthy(evalExpr(stmt.Condition, forEnv), stmt.Line, stmt.Col) {
 				res := Eval(stmt.Body, forEnv)
+				if _, ok := res.(returnSignal); ok {
+					return res
+				}
 				if _, ok := res.(breakSignal); ok {
 					break
 				}
@@ -169,20 +377,38 @@ func Eval(stmts []ast.Statement, env *Environment) interface{} {
 					Eval([]ast.Statement{stmt.Post}, forEnv)
 				}
 			}
+		case *ast.WithStatement:
+			if res := evalWith(stmt, env); isSignal(res) {
+				return res
+			}
 		case *ast.CImportStatement:
 			// TODO: Actually load the C header and expose functions/types.
 			fmt.Printf("[CIMPORT] Would import C header: %s\n", stmt.Header)
 		}
 	}
-	return nil
+	return result
 }
 
 func evalExpr(expr ast.Expression, env *Environment) interface{} {
+	profiler, limit := hooks()
+	if profiler != nil && expr != nil {
+		defer profiler.track(expr)()
+	}
+	if limit != nil {
+		limit.step()
+	}
 	switch v := expr.(type) {
 	case *ast.StringLiteral:
+		if v.Raw {
+			return v.Value
+		}
 		return interpolateString(v.Value, env)
 	case *ast.IntegerLiteral:
 		return v.Value
+	case *ast.FloatLiteral:
+		return v.Value
+	case *ast.FunctionLiteral:
+		return &Function{Stmt: v.Fn, Env: env}
 	case *ast.BoolLiteral:
 		return v.Value
 	case *ast.Identifier:
@@ -190,98 +416,44 @@ func evalExpr(expr ast.Expression, env *Environment) interface{} {
 		if val, ok := env.Get(v.Value); ok && val != nil {
 			return val
 		}
-		// If full identifier lookup fails and the identifier is qualified, try field access.
+		// If full identifier lookup fails and the identifier is qualified, try
+		// field access, following nested structs: order.customer.name
 		if strings.Contains(v.Value, ".") {
-			parts := strings.SplitN(v.Value, ".", 2)
-			baseName := parts[0]
-			fieldName := parts[1]
+			baseName, fields := splitFieldPath(v.Value, env)
 			base, ok := env.Get(baseName)
 			if !ok || base == nil {
-				return fmt.Sprintf("Error: variable '%s' is not public or does not exist", baseName)
+				return undeclared(baseName, v.Line, v.Col)
 			}
-			if obj, ok := base.(map[string]interface{}); ok {
-				if fieldVal, exists := obj[fieldName]; exists {
-					return fieldVal
+			val, holder := base, baseName
+			for _, fieldName := range fields {
+				obj, ok := val.(map[string]interface{})
+				if !ok {
+					runtimeError(v.Line, v.Col, "variable '%s' is not a struct", holder)
+				}
+				fieldVal, exists := obj[fieldName]
+				if !exists {
+					// Reading a field the literal never set is an error rather than a silent zero value
+					structName, _ := obj["_struct"].(string)
+					runtimeError(v.Line, v.Col, "field '%s' is not set on '%s' (struct %s)", fieldName, holder, structName)
 				}
-				return fmt.Sprintf("Error: field '%s' not found in '%s'", fieldName, baseName)
+				val, holder = fieldVal, holder+"."+fieldName
 			}
-			return fmt.Sprintf("Error: variable '%s' is not a struct", baseName)
+			return val
 		}
 		// Otherwise, return an error.
-		return fmt.Sprintf("Error: variable '%s' is not public or does not exist", v.Value)
+		return undeclared(v.Value, v.Line, v.Col)
 	case *ast.BinaryExpression:
-		left := evalExpr(v.Left, env)
-		right := evalExpr(v.Right, env)
-		l, lok := left.(int64)
-		r, rok := right.(int64)
-		switch v.Operator {
-		case token.PLUS:
-			// Support int + int and string + string
-			switch lval := left.(type) {
-			case int64:
-				if rval, ok := right.(int64); ok {
-					return lval + rval
-				}
-			case string:
-				if rval, ok := right.(string); ok {
-					return lval + rval
-				}
-			}
-			return nil
-		case token.MINUS:
-			if lok && rok {
-				return l - r
-			}
-		case token.ASTERISK:
-			if lok && rok {
-				return l * r
-			}
-		case token.SLASH:
-			if lok && rok {
-				return l / r
-			}
-		case token.MODULUS:
-			if lok && rok {
-				return l % r
-			}
-		case token.EQ:
-			return left == right
-		case token.NEQ:
-			return left != right
-		case token.LT:
-			if lok && rok {
-				return l < r
-			}
-		case token.LTE:
-			if lok && rok {
-				return l <= r
-			}
-		case token.GT:
-			if lok && rok {
-				return l > r
-			}
-		case token.GTE:
-			if lok && rok {
-				return l >= r
-			}
-		case token.AND:
-			return isTruthy(left) && isTruthy(right)
-		case token.OR:
-			return isTruthy(left) || isTruthy(right)
-		case token.NOT:
-			return !isTruthy(right)
-		}
-		return nil
+		return evalBinary(v, evalExpr(v.Left, env), evalExpr(v.Right, env), env)
 	case *ast.CallExpression:
 		if ident, ok := v.Function.(*ast.Identifier); ok {
 
-			// Built-in functions
-			if fn, ok := Builtins[ident.Value]; ok {
+			// Built-in functions, unless the program overrides them
+			if fn, ok := Builtins[ident.Value]; ok && !isOverridden(env, ident.Value) {
 				args := []interface{}{}
 				for _, argExpr := range v.Arguments {
 					args = append(args, evalExpr(argExpr, env))
 				}
-				return fn(args)
+				return callBuiltin(fn, args, ident.Line, ident.Col)
 			}
 
 			// --- Method call support ---
@@ -295,20 +467,18 @@ func evalExpr(expr ast.Expression, env *Environment) interface{} {
 						structType, _ := obj["_struct"].(string)
 						methodFullName := structType + "." + methodName
 						fnObj, ok := env.Get(methodFullName)
-						fnStmt, isFn := fnObj.(*ast.FunctionStatement)
+						fn, isFn := fnObj.(*Function)
 						if ok && isFn {
-							args := []interface{}{baseVal}
+							// The receiver is bound to this, not passed as a parameter
+							return callFunction(fn, baseVal, functionArgs(v, ident, fn, env))
+						}
+						// A closure stored in a field, e.g. cfg.onDone()
+						if fn, ok := obj[methodName].(*Function); ok {
+							args := []interface{}{}
 							for _, argExpr := range v.Arguments {
 								args = append(args, evalExpr(argExpr, env))
 							}
-							localEnv := NewEnclosedEnvironment(getGlobalEnv(env))
-							localEnv.Set("this", baseVal)
-							for i, param := range fnStmt.Params {
-								if i < len(args) {
-									localEnv.Set(param, args[i])
-								}
-							}
-							return evalFunctionBody(fnStmt.Body, localEnv)
+							return callFunction(fn, nil, args)
 						}
 					}
 				}
@@ -319,7 +489,8 @@ func evalExpr(expr ast.Expression, env *Environment) interface{} {
 				arg := evalExpr(v.Arguments[0], env)
 				switch val := arg.(type) {
 				case string:
-					return int64(len(val))
+					// Characters, not bytes: len("é") is 1
+					return int64(utf8.RuneCountInString(val))
 				case []interface{}:
 					return int64(len(val))
 				default:
@@ -340,34 +511,31 @@ func evalExpr(expr ast.Expression, env *Environment) interface{} {
 			}
 			// User-defined function
 			fnObj, ok := env.Get(ident.Value)
-			fnStmt, isFn := fnObj.(*ast.FunctionStatement)
+			fn, isFn := fnObj.(*Function)
 			if !ok || !isFn {
 				return nil // or error
 			}
-			// Evaluate arguments
-			args := []interface{}{}
-			for _, argExpr := range v.Arguments {
-				args = append(args, evalExpr(argExpr, env))
-			}
-			localEnv := NewEnclosedEnvironment(getGlobalEnv(env))
-			// Bind parameters to arguments
-			for i, param := range fnStmt.Params {
-				if i < len(args) {
-					localEnv.Set(param, args[i])
-				}
-			}
-			return evalFunctionBody(fnStmt.Body, localEnv)
+			return callFunction(fn, nil, functionArgs(v, ident, fn, env))
 		}
 		return nil
+	case *ast.TernaryExpression:
+		// Only the branch taken is evaluated
+		if isTruthy(evalExpr(v.Cond, env), v.Line, v.Col) {
+			return evalExpr(v.Then, env)
+		}
+		return evalExpr(v.Else, env)
 	case *ast.UnaryExpression:
 		right := evalExpr(v.Right, env)
 		switch v.Operator {
 		case token.MINUS:
-			if val, ok := right.(int64); ok {
+			switch val := right.(type) {
+			case int64:
+				return -val
+			case float64:
 				return -val
 			}
 		case token.NOT:
-			return !isTruthy(right)
+			return !isTruthy(right, v.Line, v.Col)
 		}
 		return nil
 	case *ast.ArrayLiteral:
@@ -378,55 +546,81 @@ func evalExpr(expr ast.Expression, env *Environment) interface{} {
 		return arr
 
 	case *ast.IndexExpression:
-		arr := evalExpr(v.Left, env)
-		idx := evalExpr(v.Index, env)
-		// Array indexing
-		if arrSlice, ok := arr.([]interface{}); ok {
-			if idxInt, ok2 := idx.(int64); ok2 && int(idxInt) >= 0 && int(idxInt) < len(arrSlice) {
-				return arrSlice[int(idxInt)]
-			}
-			return nil // or error
-		}
-		// Map indexing (Go built-in returns map[string]interface{})
-		if m, ok := arr.(map[string]interface{}); ok {
-			if key, ok := idx.(string); ok {
-				return m[key]
-			}
-		}
-		// Map indexing (user map)
-		if m, ok := arr.(map[interface{}]interface{}); ok {
-			return m[idx]
-		}
-		return nil // or error
+		return indexValue(evalExpr(v.Left, env), evalExpr(v.Index, env), v)
 	case *ast.SliceExpression:
 		arr := evalExpr(v.Left, env)
 		arrSlice, ok := arr.([]interface{})
 		if !ok {
 			return nil // or error
 		}
+		step := int64(1)
+		if v.Step != nil {
+			if s, ok := evalExpr(v.Step, env).(int64); ok {
+				step = s
+			}
+			if step == 0 {
+				runtimeError(v.Line, v.Col, "slice step cannot be zero")
+			}
+		}
+		length := int64(len(arrSlice))
+		if step < 0 {
+			// Walk backwards: defaults cover the whole array from the last element
+			start, end := length-1, int64(-1)
+			if v.Start != nil {
+				if s, ok := evalExpr(v.Start, env).(int64); ok {
+					start = sliceBound(s, length)
+				}
+			}
+			if v.End != nil {
+				if e, ok := evalExpr(v.End, env).(int64); ok {
+					end = sliceBound(e, length)
+				}
+			}
+			if start > length-1 {
+				start = length - 1
+			}
+			if end < -1 {
+				end = -1
+			}
+			result := []interface{}{}
+			for i := start; i > end; i += step {
+				result = append(result, arrSlice[i])
+			}
+			return result
+		}
 		var start, end int64
 		if v.Start != nil {
 			if s, ok := evalExpr(v.Start, env).(int64); ok {
-				start = s
+				start = sliceBound(s, length)
 			}
 		}
 		if v.End != nil {
 			if e, ok := evalExpr(v.End, env).(int64); ok {
-				end = e
+				end = sliceBound(e, length)
 			}
 		} else {
-			end = int64(len(arrSlice))
+			end = length
 		}
 		if start < 0 {
 			start = 0
 		}
-		if end > int64(len(arrSlice)) {
-			end = int64(len(arrSlice))
+		if end < 0 {
+			end = 0
+		}
+		if end > length {
+			end = length
 		}
 		if start > end {
 			start = end
 		}
-		return arrSlice[start:end]
+		if step == 1 {
+			return arrSlice[start:end]
+		}
+		result := []interface{}{}
+		for i := start; i < end; i += step {
+			result = append(result, arrSlice[i])
+		}
+		return result
 	case *ast.StructLiteral:
 		// Evaluate each field and return a map representing the struct instance.
 		obj := make(map[string]interface{})
@@ -438,6 +632,14 @@ func evalExpr(expr ast.Expression, env *Environment) interface{} {
 		return obj
 	case *ast.MapLiteral:
 		m := make(map[interface{}]interface{})
+		// Spread maps first so explicit pairs override their entries
+		for _, spread := range v.Spreads {
+			if src, ok := evalExpr(spread, env).(map[interface{}]interface{}); ok {
+				for key, val := range src {
+					m[key] = val
+				}
+			}
+		}
 		for k, v := range v.Pairs {
 			key := evalExpr(k, env)
 			val := evalExpr(v, env)
@@ -448,55 +650,536 @@ func evalExpr(expr ast.Expression, env *Environment) interface{} {
 	return nil
 }
 
-func evalFunctionBody(stmts []ast.Statement, env *Environment) interface{} {
-	for _, s := range stmts {
-		switch stmt := s.(type) {
-		case *ast.ReturnStatement:
-			return evalExpr(stmt.Value, env)
-		default:
-			Eval([]ast.Statement{stmt}, env)
+// callFunction binds args to the parameters of fn in a new scope on top of the
+// environment fn was declared in and evaluates its body. A non-nil receiver is
+// bound to `this`.
+func callFunction(fn *Function, receiver interface{}, args []interface{}) interface{} {
+	if _, limit := hooks(); limit != nil {
+		limit.enter()
+		defer limit.leave()
+	}
+	localEnv := NewEnclosedEnvironment(fn.Env)
+	if receiver != nil {
+		localEnv.Set("this", receiver)
+	}
+	if fn.Stmt.Override {
+		localEnv.Set(overrideMarker(fn.Stmt.Name), true)
+	}
+	// Bind parameters to arguments; omitted trailing ones take their default
+	// and a variadic last one gets an array of the arguments left over
+	for i, param := range fn.Stmt.Params {
+		if fn.Stmt.Variadic && i == len(fn.Stmt.Params)-1 {
+			rest := []interface{}{}
+			if i < len(args) {
+				rest = append(rest, args[i:]...)
+			}
+			localEnv.Set(param, rest)
+		} else if i < len(args) {
+			localEnv.Set(param, args[i])
+		} else if i < len(fn.Stmt.Defaults) && fn.Stmt.Defaults[i] != nil {
+			localEnv.Set(param, evalExpr(fn.Stmt.Defaults[i], fn.Env))
+		}
+	}
+	return evalFunctionBody(fn.Stmt.Body, localEnv)
+}
+
+// functionArgs evaluates the arguments of a call to fn, in the order they were
+// written, and returns them in parameter order with named arguments matched to
+// their parameter and omitted ones given their default.
+func functionArgs(call *ast.CallExpression, ident *ast.Identifier, fn *Function, env *Environment) []interface{} {
+	argExprs, err := call.ArgumentsFor(fn.Stmt.Params, fn.Stmt.Defaults)
+	if err != nil {
+		runtimeError(ident.Line, ident.Col, "call to '%s': %v", ident.Value, err)
+	}
+	values := make(map[ast.Expression]interface{}, len(call.Arguments))
+	for _, argExpr := range call.Arguments {
+		values[argExpr] = evalExpr(argExpr, env)
+	}
+	args := []interface{}{}
+	for _, argExpr := range argExprs {
+		val, given := values[argExpr]
+		if !given {
+			// A default, evaluated where the function was declared
+			val = evalExpr(argExpr, fn.Env)
+		}
+		args = append(args, val)
+	}
+	return args
+}
+
+// evalMembership implements `x in xs`, `key in m` and `sub in s`.
+func evalMembership(left, right interface{}, line, col int) bool {
+	switch r := right.(type) {
+	case []interface{}:
+		for _, elem := range r {
+			if valuesEqual(elem, left) {
+				return true
+			}
+		}
+		return false
+	case map[interface{}]interface{}:
+		switch left.(type) {
+		case []interface{}, map[interface{}]interface{}, map[string]interface{}:
+			// Can't be a key, and indexing with it would panic
+			return false
+		}
+		_, ok := r[left]
+		return ok
+	case string:
+		if sub, ok := left.(string); ok {
+			return strings.Contains(r, sub)
+		}
+		runtimeError(line, col, "'in' on a string expects a string on the left, got %s", typeName(left))
+	}
+	runtimeError(line, col, "'in' expects an array, map or string on the right, got %s", typeName(right))
+	return false
+}
+
+// valuesEqual compares two values for 'in': arrays, maps and structs element
+// by element, anything else with ==.
+func valuesEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !valuesEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[interface{}]interface{}:
+		bv, ok := b.(map[interface{}]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			if w, ok := bv[k]; !ok || !valuesEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			if w, ok := bv[k]; !ok || !valuesEqual(v, w) {
+				return false
+			}
 		}
+		return true
+	}
+	// a is comparable, so == can't panic even when b isn't
+	return a == b
+}
+
+// floatOperands reports whether a binary operation should be done in floating
+// point: at least one operand is a float and the other is a float or an int.
+func floatOperands(left, right interface{}) (float64, float64, bool) {
+	_, lfloat := left.(float64)
+	_, rfloat := right.(float64)
+	if !lfloat && !rfloat {
+		return 0, 0, false
+	}
+	l, lok := toFloat(left)
+	r, rok := toFloat(right)
+	return l, r, lok && rok
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	switch n := val.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// evalFloatOperator applies an arithmetic or comparison operator to floats.
+func evalFloatOperator(op token.TokenType, l, r float64) interface{} {
+	switch op {
+	case token.PLUS:
+		return l + r
+	case token.MINUS:
+		return l - r
+	case token.ASTERISK:
+		return l * r
+	case token.SLASH:
+		return l / r
+	case token.EQ:
+		return l == r
+	case token.NEQ:
+		return l != r
+	case token.LT:
+		return l < r
+	case token.LTE:
+		return l <= r
+	case token.GT:
+		return l > r
+	case token.GTE:
+		return l >= r
 	}
 	return nil
 }
 
-func isTruthy(val interface{}) bool {
+// evalMatch runs the body of the first case of a match equal to its subject,
+// or its default, and returns what that body returned.
+func evalMatch(stmt *ast.MatchStatement, env *Environment) interface{} {
+	subject := evalExpr(stmt.Subject, env)
+	for i, c := range stmt.Cases {
+		if evalExpr(c, env) == subject {
+			return Eval(stmt.Bodies[i], NewEnclosedEnvironment(env))
+		}
+	}
+	return Eval(stmt.Default, NewEnclosedEnvironment(env))
+}
+
+// splitFieldPath splits a dotted name such as order.customer.name into the
+// variable it starts from and the fields read from it in turn. The variable is
+// the longest prefix declared in env, as imported names hold dots too.
+func splitFieldPath(name string, env *Environment) (string, []string) {
+	parts := strings.Split(name, ".")
+	for i := len(parts) - 1; i >= 1; i-- {
+		if _, ok := env.Get(strings.Join(parts[:i], ".")); ok {
+			return strings.Join(parts[:i], "."), parts[i:]
+		}
+	}
+	return parts[0], parts[1:]
+}
+
+// arrayIndex returns idx as a position in arr, raising a runtime error when it
+// is out of range.
+func arrayIndex(arr []interface{}, idx interface{}, line, col int) int {
+	i, ok := idx.(int64)
+	if !ok {
+		runtimeError(line, col, "array index must be an int, got %s", typeName(idx))
+	}
+	if i < 0 || i >= int64(len(arr)) {
+		runtimeError(line, col, "index %d out of range for array of length %d", i, len(arr))
+	}
+	return int(i)
+}
+
+// sliceBound resolves a slice bound given in the program: negative bounds count
+// from the end, so xs[-2:] is the last two elements. Bounds still out of range
+// are clamped by the caller.
+func sliceBound(i, length int64) int64 {
+	if i < 0 {
+		return i + length
+	}
+	return i
+}
+
+// overrideMarker names the variable bound inside an @override function, so
+// that calls it makes to the builtin it replaces reach the builtin.
+func overrideMarker(builtin string) string {
+	return "@override " + builtin
+}
+
+// isOverridden reports whether calls to a builtin should go to an @override
+// function instead: one is declared, and the call isn't made from inside it.
+func isOverridden(env *Environment, builtin string) bool {
+	if _, inside := env.Get(overrideMarker(builtin)); inside {
+		return false
+	}
+	fnObj, ok := env.Get(builtin)
+	fn, isFn := fnObj.(*Function)
+	return ok && isFn && fn.Stmt.Override
+}
+
+// indexValue reads arr[idx] for the index expression v.
+func indexValue(arr, idx interface{}, v *ast.IndexExpression) interface{} {
+	// Array indexing
+	if arrSlice, ok := arr.([]interface{}); ok {
+		return arrSlice[arrayIndex(arrSlice, idx, v.Line, v.Col)]
+	}
+	// Map indexing (Go built-in returns map[string]interface{})
+	if m, ok := arr.(map[string]interface{}); ok {
+		if key, ok := idx.(string); ok {
+			return m[key]
+		}
+	}
+	// Map indexing (user map)
+	if m, ok := arr.(map[interface{}]interface{}); ok {
+		return m[idx]
+	}
+	return nil // or error
+}
+
+// evalBinary applies the operator of v to operands that are already evaluated.
+func evalBinary(v *ast.BinaryExpression, left, right interface{}, env *Environment) interface{} {
+	if result, ok := evalOperatorMethod(v, left, right, env); ok {
+		return result
+	}
+	if lf, rf, ok := floatOperands(left, right); ok {
+		return evalFloatOperator(v.Operator, lf, rf)
+	}
+	l, lok := left.(int64)
+	r, rok := right.(int64)
+	switch v.Operator {
+	case token.PLUS:
+		// Support int + int and string + string
+		switch lval := left.(type) {
+		case int64:
+			if rval, ok := right.(int64); ok {
+				return lval + rval
+			}
+		case string:
+			if rval, ok := right.(string); ok {
+				return lval + rval
+			}
+		}
+		return nil
+	case token.MINUS:
+		if lok && rok {
+			return l - r
+		}
+	case token.ASTERISK:
+		if lok && rok {
+			return l * r
+		}
+	case token.SLASH:
+		if lok && rok {
+			if r == 0 {
+				runtimeError(v.Line, v.Col, "division by zero")
+			}
+			return l / r
+		}
+	case token.MODULUS:
+		if lok && rok {
+			if r == 0 {
+				runtimeError(v.Line, v.Col, "modulo by zero")
+			}
+			return l % r
+		}
+	case token.EQ:
+		return left == right
+	case token.NEQ:
+		return left != right
+	case token.LT:
+		if lok && rok {
+			return l < r
+		}
+	case token.LTE:
+		if lok && rok {
+			return l <= r
+		}
+	case token.GT:
+		if lok && rok {
+			return l > r
+		}
+	case token.GTE:
+		if lok && rok {
+			return l >= r
+		}
+	case token.AND:
+		return isTruthy(left, v.Line, v.Col) && isTruthy(right, v.Line, v.Col)
+	case token.OR:
+		return isTruthy(left, v.Line, v.Col) || isTruthy(right, v.Line, v.Col)
+	case token.NOT:
+		return !isTruthy(right, v.Line, v.Col)
+	case token.IN:
+		return evalMembership(left, right, v.Line, v.Col)
+	}
+	return nil
+}
+
+// operatorMethods maps overloadable operators to the struct method names they
+// dispatch to, e.g. a + b calls a.add(b) when a and b are instances of the same
+// struct. The typechecker keeps the same list.
+var operatorMethods = map[token.TokenType]string{
+	token.PLUS:  "add",
+	token.MINUS: "sub",
+	token.EQ:    "eq",
+	token.NEQ:   "eq",
+}
+
+// evalOperatorMethod dispatches an overloadable operator to a method on the
+// left operand's struct (see operatorMethods). It reports false when the
+// operands aren't instances of the same struct or no such method is declared.
+func evalOperatorMethod(v *ast.BinaryExpression, left, right interface{}, env *Environment) (interface{}, bool) {
+	methodName, ok := operatorMethods[v.Operator]
+	if !ok {
+		return nil, false
+	}
+	lobj, lok := left.(map[string]interface{})
+	robj, rok := right.(map[string]interface{})
+	if !lok || !rok {
+		return nil, false
+	}
+	structType, _ := lobj["_struct"].(string)
+	if otherType, _ := robj["_struct"].(string); structType == "" || otherType != structType {
+		return nil, false
+	}
+	fnObj, _ := env.Get(structType + "." + methodName)
+	fn, isFn := fnObj.(*Function)
+	if !isFn {
+		return nil, false
+	}
+	result := callFunction(fn, left, []interface{}{right})
+	if v.Operator == token.EQ || v.Operator == token.NEQ {
+		eq, isBool := result.(bool)
+		if !isBool {
+			runtimeError(v.Line, v.Col, "%s.eq must return bool, got %s", structType, typeName(result))
+		}
+		if v.Operator == token.NEQ {
+			return !eq, true
+		}
+	}
+	return result, true
+}
+
+// resourceClosers maps the builtins that open a resource usable in a with
+// statement to the builtin that closes it. The typechecker keeps the same list.
+var resourceClosers = map[string]string{
+	"go.file.open":   "go.file.close",
+	"go.file.create": "go.file.close",
+}
+
+// evalWith runs the body of a with statement in its own scope and closes the
+// resource afterwards, even if the body breaks out or raises a runtime error.
+func evalWith(stmt *ast.WithStatement, env *Environment) interface{} {
+	resource := evalExpr(stmt.Value, env)
+	if resource == nil {
+		runtimeError(stmt.Line, stmt.Col, "with: could not open resource for '%s'", stmt.Name)
+	}
+	if call, ok := stmt.Value.(*ast.CallExpression); ok {
+		if ident, ok := call.Function.(*ast.Identifier); ok {
+			if closer, ok := resourceClosers[ident.Value]; ok {
+				defer callBuiltin(Builtins[closer], []interface{}{resource}, stmt.Line, stmt.Col)
+			}
+		}
+	}
+	withEnv := NewEnclosedEnvironment(env)
+	withEnv.Set(stmt.Name, resource)
+	return Eval(stmt.Body, withEnv)
+}
+
+// isSignal reports whether res is a break, continue or return signal, which
+// a statement holding blocks passes on to its enclosing loop or function.
+func isSignal(res interface{}) bool {
+	switch res.(type) {
+	case breakSignal, continueSignal, returnSignal:
+		return true
+	}
+	return false
+}
+
+// evalFunctionBody runs a function body and returns the value of the return
+// statement that ends it, wherever it is nested, or nil if none does.
+func evalFunctionBody(stmts []ast.Statement, env *Environment) interface{} {
+	if ret, ok := Eval(stmts, env).(returnSignal); ok {
+		return ret.value
+	}
+	return nil
+}
+
+// isTruthy reports whether a condition holds. There is no implicit coercion:
+// like the typechecker, the evaluator only accepts real booleans in conditions
+// and logical operators, so `if "false" { }` is an error rather than true.
+func isTruthy(val interface{}, line, col int) bool {
+	b, ok := val.(bool)
+	if !ok {
+		runtimeError(line, col, "condition must be a bool, got %s", typeName(val))
+	}
+	return b
+}
+
+// typeName describes the Tox type of a runtime value for error messages.
+func typeName(val interface{}) string {
 	switch v := val.(type) {
+	case nil:
+		return "nil"
 	case bool:
-		return v
-	case int64:
-		return v != 0
+		return "bool"
+	case int64, int:
+		return "int"
+	case float64:
+		return "float"
 	case string:
-		return v != ""
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		if name, ok := v["_struct"].(string); ok {
+			return name
+		}
+		return "struct"
+	case map[interface{}]interface{}:
+		return "map"
+	case *Function:
+		return "function"
 	default:
-		return v != nil
+		return fmt.Sprintf("%T", val)
 	}
 }
 
 func printValue(val interface{}) {
+	fmt.Println(formatValue(val))
+}
+
+// formatValue renders a runtime value the way Tox prints it: arrays as
+// [1, 2], maps as {a: 1}, struct instances as User{age: 22, name: Andy} and
+// nil as nil. Map entries and struct fields are sorted so output is deterministic.
+func formatValue(val interface{}) string {
 	switch v := val.(type) {
+	case nil:
+		return "nil"
+	case *Function:
+		if v.Stmt.Name == "" {
+			return "<fnc>"
+		}
+		return "<fnc " + v.Stmt.Name + ">"
 	case []interface{}:
 		elems := make([]string, len(v))
 		for i, e := range v {
-			elems[i] = fmt.Sprint(e)
+			elems[i] = formatValue(e)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case map[string]interface{}:
+		structName, _ := v["_struct"].(string)
+		fields := []string{}
+		for name, fieldVal := range v {
+			if name == "_struct" {
+				continue
+			}
+			fields = append(fields, name+": "+formatValue(fieldVal))
 		}
-		fmt.Printf("[%s]\n", strings.Join(elems, ", "))
+		sort.Strings(fields)
+		return structName + "{" + strings.Join(fields, ", ") + "}"
+	case map[interface{}]interface{}:
+		pairs := []string{}
+		for key, pairVal := range v {
+			pairs = append(pairs, formatValue(key)+": "+formatValue(pairVal))
+		}
+		sort.Strings(pairs)
+		return "{" + strings.Join(pairs, ", ") + "}"
 	default:
-		fmt.Println(v)
+		return fmt.Sprint(v)
 	}
 }
 
-// Interpolates <%var%> or <%var.field%> in a string using the current environment
+// Interpolates <%var%> or <%var.field%> in a string using the current environment.
+// An optional format spec after a colon, e.g. <%price:.2f%> or <%n:5d%>, is
+// applied with fmt.Sprintf; placeholders with an invalid spec are left as-is.
 func interpolateString(s string, env *Environment) string {
 	re := regexp.MustCompile(`<%([^%>]+)%>`)
 	return re.ReplaceAllStringFunc(s, func(match string) string {
 		inner := re.FindStringSubmatch(match)
 		if len(inner) == 2 {
-			expr := strings.TrimSpace(inner[1])
+			expr, spec, hasSpec := strings.Cut(strings.TrimSpace(inner[1]), ":")
+			expr = strings.TrimSpace(expr)
+			var val interface{}
 			// Support dot notation for struct fields
 			if strings.Contains(expr, ".") {
 				parts := strings.Split(expr, ".")
-				val, ok := env.Get(parts[0])
+				var ok bool
+				val, ok = env.Get(parts[0])
 				if !ok {
 					return match
 				}
@@ -511,14 +1194,52 @@ func interpolateString(s string, env *Environment) string {
 						return match
 					}
 				}
-				return fmt.Sprint(val)
 			} else {
-				val, ok := env.Get(expr)
-				if ok {
-					return fmt.Sprint(val)
+				var ok bool
+				val, ok = env.Get(expr)
+				if !ok {
+					return match // leave as-is if not found
 				}
 			}
+			if !hasSpec {
+				return fmt.Sprint(val)
+			}
+			if formatted, ok := formatWithSpec(val, strings.TrimSpace(spec)); ok {
+				return formatted
+			}
 		}
-		return match // leave as-is if not found
+		return match
 	})
 }
+
+var formatSpecRe = regexp.MustCompile(`^[-+# 0]*[0-9]*(\.[0-9]+)?[dxXobfeEgGstv]$`)
+
+// formatWithSpec formats val with a printf-style spec such as ".2f", "5d" or
+// "-10s". It reports false if the spec is malformed or its verb doesn't fit
+// the value, e.g. "d" for a string. Ints are accepted by the float verbs.
+func formatWithSpec(val interface{}, spec string) (string, bool) {
+	if !formatSpecRe.MatchString(spec) {
+		return "", false
+	}
+	switch verb := spec[len(spec)-1]; verb {
+	case 'd', 'x', 'X', 'o', 'b':
+		if _, ok := val.(int64); !ok {
+			return "", false
+		}
+	case 'f', 'e', 'E', 'g', 'G':
+		f, ok := toFloat(val)
+		if !ok {
+			return "", false
+		}
+		val = f
+	case 't':
+		if _, ok := val.(bool); !ok {
+			return "", false
+		}
+	case 's', 'v':
+		// Any value, rendered the same way log prints it
+		val = formatValue(val)
+		spec = spec[:len(spec)-1] + "s"
+	}
+	return fmt.Sprintf("%"+spec, val), true
+}
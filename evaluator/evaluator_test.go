@@ -0,0 +1,979 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/notrealandy/tox/lexer"
+	"github.com/notrealandy/tox/parser"
+)
+
+// evalProgram parses and evaluates src, returning the environment holding its
+// declarations.
+func evalProgram(t *testing.T, src string) *Environment {
+	t.Helper()
+	p := parser.New(lexer.New(src))
+	stmts := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors)
+	}
+	env := NewEnvironment()
+	Eval(stmts, env)
+	return env
+}
+
+// callCase is a call to a function of a program and the value it should return.
+type callCase struct {
+	name string
+	fn   string
+	want interface{}
+}
+
+func runCallCases(t *testing.T, src string, tests []callCase) {
+	t.Helper()
+	env := evalProgram(t, src)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CallFunction(env, tt.fn, nil)
+			if err != nil {
+				t.Fatalf("%s(): %v", tt.fn, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s() = %#v, want %#v", tt.fn, got, tt.want)
+			}
+		})
+	}
+}
+
+// callErrorCase is a call to a function of a program and the runtime error it
+// should stop with.
+type callErrorCase struct {
+	name string
+	fn   string
+	want string
+}
+
+func runCallErrorCases(t *testing.T, src string, tests []callErrorCase) {
+	t.Helper()
+	env := evalProgram(t, src)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CallFunction(env, tt.fn, nil)
+			if err == nil {
+				t.Fatalf("%s() = %#v, want error %q", tt.fn, got, tt.want)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("%s() error = %q, want %q", tt.fn, err, tt.want)
+			}
+		})
+	}
+}
+
+// captureOutput returns what running body as the body of main prints to
+// standard output.
+func captureOutput(t *testing.T, body string) string {
+	t.Helper()
+	env := evalProgram(t, "fnc main() {\n"+body+"\n}\n")
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	_, callErr := CallFunction(env, "main", nil)
+	w.Close()
+	if callErr != nil {
+		t.Fatalf("main(): %v", callErr)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestPrintOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"log true", "log(true)", "true\n"},
+		{"log false", "let b bool >> false\nlog(b)", "false\n"},
+		{"log nil", "log(nil)", "nil\n"},
+		{"println true", "go.println(true)", "true\n"},
+		{"println false", "let b bool >> false\ngo.println(b)", "false\n"},
+		{"println nil", "go.println(nil)", "nil\n"},
+		{"println several", "go.println(true, nil, false)", "true nil false\n"},
+		{"nil in an array", "let xs any[] >> [nil, true]\nlog(xs)\ngo.println(xs)", "[nil, true]\n[nil, true]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := captureOutput(t, tt.src); got != tt.want {
+				t.Errorf("%s printed %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamedArguments(t *testing.T) {
+	src := `
+struct Greeter {
+    prefix string
+}
+fnc Greeter.greet(name string, punct string >> "!") >> string {
+    return this.prefix + name + punct
+}
+fnc greet(name string, punct string >> "!") >> string {
+    return name + punct
+}
+let g Greeter >> { prefix: "Hi " }
+fnc function() >> string {
+    return greet(punct: "?", name: "Ann")
+}
+fnc method() >> string {
+    return g.greet(punct: "?", name: "Ann")
+}
+fnc methodDefault() >> string {
+    return g.greet(name: "Bob")
+}
+fnc methodPositional() >> string {
+    return g.greet("Cy", ".")
+}
+`
+	runCallCases(t, src, []callCase{
+		{"function", "function", "Ann?"},
+		{"method", "method", "Hi Ann?"},
+		{"method default", "methodDefault", "Hi Bob!"},
+		{"method positional", "methodPositional", "Hi Cy."},
+	})
+}
+
+func TestCompoundIndexAssignment(t *testing.T) {
+	src := `
+let calls int >> 0
+fnc next() >> int {
+    calls += 1
+    return 1
+}
+fnc key() >> string {
+    calls += 1
+    return "a"
+}
+fnc array() >> int {
+    calls >> 0
+    let xs int[] >> [10, 20, 30]
+    xs[next()] += 5
+    return xs[1] * 10 + calls
+}
+fnc mapValue() >> int {
+    calls >> 0
+    let m :>> map[string] >> int { "a": 1 }
+    m[key()] *= 7
+    return m["a"] * 10 + calls
+}
+`
+	runCallCases(t, src, []callCase{
+		{"array", "array", int64(251)},
+		{"map", "mapValue", int64(71)},
+	})
+}
+
+func TestMapSpread(t *testing.T) {
+	src := `
+let defaults :>> map[string] >> int { "a": 1, "b": 2 }
+fnc overridden() >> int {
+    let m :>> map[string] >> int { ...defaults, "b": 20 }
+    return m["a"] + m["b"]
+}
+fnc spreadLast() >> int {
+    let m :>> map[string] >> int { "b": 20, ...defaults }
+    return m["b"]
+}
+fnc copied() >> int {
+    let m :>> map[string] >> int { ...defaults }
+    m["a"] >> 100
+    return defaults["a"]
+}
+`
+	runCallCases(t, src, []callCase{
+		{"literal pairs override spreads", "overridden", int64(21)},
+		{"pairs override spreads written after them", "spreadLast", int64(20)},
+		{"spread copies", "copied", int64(1)},
+	})
+}
+
+func TestWith(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "with.txt")
+	src := fmt.Sprintf(`
+let path string >> %q
+let handle int >> 0
+fnc write() >> string[] {
+    with f >> go.file.create(path) {
+        go.file.write(f, "one")
+    }
+    return go.file.readLines(path)
+}
+fnc closed() >> bool {
+    with f >> go.file.open(path) {
+        handle >> f
+    }
+    return go.file.write(handle, "two")
+}
+fnc early() >> bool {
+    with f >> go.file.open(path) {
+        handle >> f
+        return true
+    }
+    return false
+}
+fnc closedAfterReturn() >> bool {
+    return go.file.write(handle, "two")
+}
+`, path)
+	runCallCases(t, src, []callCase{
+		{"writes are flushed", "write", []interface{}{"one"}},
+		{"closed after the block", "closed", false},
+		{"return from the block", "early", true},
+		{"closed after a return", "closedAfterReturn", false},
+	})
+}
+
+func TestOverrides(t *testing.T) {
+	src := `
+@override
+fnc go.strings.toUpper(s string) >> string {
+    return "<" + go.strings.toUpper(s) + ">"
+}
+fnc shout() >> string {
+    return go.strings.toUpper("hi")
+}
+fnc other() >> string {
+    return go.strings.toLower("HI")
+}
+`
+	runCallCases(t, src, []callCase{
+		{"calls reach the override", "shout", "<HI>"},
+		{"other builtins are untouched", "other", "hi"},
+	})
+}
+
+func TestFormatSpecs(t *testing.T) {
+	src := `
+struct User {
+    name string
+    age int
+}
+let price float >> 3.14159
+let count int >> 42
+let name string >> "tox"
+let ok bool >> true
+let u User >> User{ name: "Ann", age: 7 }
+let xs int[] >> [1, 2]
+fnc fixed() >> string {
+    return "<%price:.2f%>"
+}
+fnc intAsFloat() >> string {
+    return "<%count:.1f%>"
+}
+fnc decimal() >> string {
+    return "<%count:d%>"
+}
+fnc width() >> string {
+    return "[<%count:5d%>]"
+}
+fnc zeroPadded() >> string {
+    return "<%u.age:03d%>"
+}
+fnc leftAligned() >> string {
+    return "[<%name:-5s%>]"
+}
+fnc hex() >> string {
+    return "<%count:x%>"
+}
+fnc boolean() >> string {
+    return "<%ok:t%>"
+}
+fnc value() >> string {
+    return "<%xs:v%>"
+}
+fnc noSpec() >> string {
+    return "<%price%>"
+}
+fnc invalid() >> string {
+    return "<%price:q%>"
+}
+fnc wrongVerb() >> string {
+    return "<%name:d%>"
+}
+`
+	runCallCases(t, src, []callCase{
+		{".2f", "fixed", "3.14"},
+		{".1f of an int", "intAsFloat", "42.0"},
+		{"d", "decimal", "42"},
+		{"width", "width", "[   42]"},
+		{"zero padded field", "zeroPadded", "007"},
+		{"left aligned", "leftAligned", "[tox  ]"},
+		{"x", "hex", "2a"},
+		{"t", "boolean", "true"},
+		{"v", "value", "[1, 2]"},
+		{"no spec", "noSpec", "3.14159"},
+		{"invalid spec", "invalid", "<%price:q%>"},
+		{"verb for another type", "wrongVerb", "<%name:d%>"},
+	})
+}
+
+func TestLogicalNot(t *testing.T) {
+	env := evalProgram(t, "let x bool >> !false\nlet y bool >> !x\nlet z bool >> !!x\n")
+	for name, want := range map[string]bool{"x": true, "y": false, "z": true} {
+		if got, _ := env.Get(name); got != want {
+			t.Errorf("%s = %#v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLen(t *testing.T) {
+	src := `
+fnc ascii() >> int {
+    return len("hello")
+}
+fnc runes() >> int {
+    let s string >> "héllo wörld"
+    return len(s)
+}
+fnc emoji() >> int {
+    return len("👋🌍")
+}
+fnc empty() >> int {
+    return len("")
+}
+fnc array() >> int {
+    return len([1, 2, 3])
+}
+`
+	runCallCases(t, src, []callCase{
+		{"ascii", "ascii", int64(5)},
+		{"characters, not bytes", "runes", int64(11)},
+		{"emoji", "emoji", int64(2)},
+		{"empty string", "empty", int64(0)},
+		{"array", "array", int64(3)},
+	})
+}
+
+func TestTernary(t *testing.T) {
+	src := `
+let calls int >> 0
+fnc count(n int) >> int {
+    calls += 1
+    return n
+}
+fnc pick() >> int {
+    return 2 > 1 ? count(10) : count(20)
+}
+fnc onlyOneBranch() >> int {
+    calls >> 0
+    let n int >> false ? count(1) : count(2)
+    return calls
+}
+fnc nested() >> string {
+    let n int >> 0
+    return n < 0 ? "negative" : n == 0 ? "zero" : "positive"
+}
+`
+	runCallCases(t, src, []callCase{
+		{"then branch", "pick", int64(10)},
+		{"only the chosen branch runs", "onlyOneBranch", int64(1)},
+		{"nested", "nested", "zero"},
+	})
+}
+
+func TestFunctionLiterals(t *testing.T) {
+	src := `
+fnc counter() >> fnc() >> int {
+    let n int >> 0
+    return fnc() >> int {
+        n += 1
+        return n
+    }
+}
+fnc closure() >> int {
+    let next fnc() >> int >> counter()
+    next()
+    return next()
+}
+fnc separate() >> int {
+    let a fnc() >> int >> counter()
+    let b fnc() >> int >> counter()
+    a()
+    a()
+    return b()
+}
+fnc apply(f fnc(int) >> int, n int) >> int {
+    return f(n)
+}
+fnc argument() >> int {
+    return apply(fnc(n int) >> int {
+        return n * n
+    }, 7)
+}
+struct Config {
+    name string
+    onDone fnc() >> int
+}
+let done int >> 0
+fnc callback() >> int {
+    let cfg Config >> Config{ name: "job", onDone: fnc() >> int {
+        done += 1
+        return done * 10
+    } }
+    cfg.onDone()
+    return cfg.onDone()
+}
+`
+	runCallCases(t, src, []callCase{
+		{"captures its scope", "closure", int64(2)},
+		{"each call has its own scope", "separate", int64(1)},
+		{"passed as an argument", "argument", int64(49)},
+		{"stored in a struct field", "callback", int64(20)},
+	})
+}
+
+func TestNestedFieldAssignment(t *testing.T) {
+	src := `
+struct Address {
+    city string
+}
+struct User {
+    name string
+    addr Address
+}
+struct Order {
+    user User
+}
+fnc rename() >> string {
+    let o Order >> { user: User{ name: "Ann", addr: Address{ city: "Oslo" } } }
+    o.user.addr.city >> "Bergen"
+    return o.user.addr.city
+}
+fnc append() >> string {
+    let u User >> { name: "Ann", addr: Address{ city: "Oslo" } }
+    u.addr.city += "!"
+    return u.addr.city
+}
+`
+	runCallCases(t, src, []callCase{
+		{"three levels", "rename", "Bergen"},
+		{"compound", "append", "Oslo!"},
+	})
+}
+
+func TestRecursion(t *testing.T) {
+	src := `
+fnc factorial(n int) >> int {
+    if n <= 1 {
+        return 1
+    }
+    return n * factorial(n - 1)
+}
+fnc isEven(n int) >> bool {
+    if n == 0 {
+        return true
+    }
+    return isOdd(n - 1)
+}
+fnc isOdd(n int) >> bool {
+    if n == 0 {
+        return false
+    }
+    return isEven(n - 1)
+}
+fnc nestedEven() >> bool {
+    fnc even(n int) >> bool {
+        if n == 0 {
+            return true
+        }
+        return odd(n - 1)
+    }
+    fnc odd(n int) >> bool {
+        if n == 0 {
+            return false
+        }
+        return even(n - 1)
+    }
+    return even(10) and odd(7) and not even(3)
+}
+fnc topLevel() >> bool {
+    return isEven(10) and isOdd(7) and not isEven(3)
+}
+fnc fact() >> int {
+    return factorial(10)
+}
+`
+	runCallCases(t, src, []callCase{
+		{"direct", "fact", int64(3628800)},
+		{"mutual", "topLevel", true},
+		{"nested mutual", "nestedEven", true},
+	})
+}
+
+func TestIfChains(t *testing.T) {
+	src := `
+fnc firstTrueElif() >> string {
+    let ran string >> ""
+    let n int >> 5
+    if n > 10 {
+        ran += "if"
+    } elif n > 3 {
+        ran += "elif1"
+    } elif n > 1 {
+        ran += "elif2"
+    } else {
+        ran += "else"
+    }
+    return ran
+}
+fnc elseIf() >> string {
+    let n int >> 2
+    if n > 10 {
+        return "if"
+    } else if n > 1 {
+        return "else if"
+    } elif n > 0 {
+        return "elif"
+    }
+    return "none"
+}
+fnc nested(a int, b int, c int) >> string {
+    if a > 0 {
+        if b > 0 {
+            if c > 0 {
+                return "+++"
+            } elif c == 0 {
+                if a == b {
+                    return "++0 same"
+                }
+                return "++0"
+            } else {
+                return "++-"
+            }
+        } else {
+            return "+-"
+        }
+    }
+    return "-"
+}
+fnc deep() >> string {
+    return nested(1, 1, 1) + " " + nested(2, 2, 0) + " " + nested(1, 2, 0) + " " + nested(1, 1, -1) + " " + nested(1, 0, 1) + " " + nested(0, 1, 1)
+}
+`
+	runCallCases(t, src, []callCase{
+		{"only the first true elif runs", "firstTrueElif", "elif1"},
+		{"else if", "elseIf", "else if"},
+		{"deeply nested", "deep", "+++ ++0 same ++0 ++- +- -"},
+	})
+}
+
+func TestReturnFromBlocks(t *testing.T) {
+	src := `
+fnc fromIf(n int) >> string {
+    if n > 0 {
+        return "positive"
+    }
+    return "other"
+}
+fnc positive() >> string {
+    return fromIf(1)
+}
+fnc fromWhile() >> int {
+    let i int >> 0
+    while true {
+        i += 1
+        if i == 3 {
+            return i
+        }
+    }
+    return -1
+}
+fnc fromFor() >> int {
+    for let i int >> 0; i < 10; i += 1 {
+        if i * i > 20 {
+            return i
+        }
+    }
+    return -1
+}
+fnc fromNestedLoop() >> int {
+    for let i int >> 1; i < 5; i += 1 {
+        for let j int >> 1; j < 5; j += 1 {
+            if i * j == 6 {
+                return i * 10 + j
+            }
+        }
+    }
+    return -1
+}
+`
+	runCallCases(t, src, []callCase{
+		{"if", "positive", "positive"},
+		{"while", "fromWhile", int64(3)},
+		{"for", "fromFor", int64(5)},
+		{"nested loops", "fromNestedLoop", int64(23)},
+	})
+}
+
+func TestBreakAndContinueInIf(t *testing.T) {
+	src := `
+fnc breakWhile() >> int {
+    let i int >> 0
+    while true {
+        if i == 4 {
+            break
+        }
+        i += 1
+    }
+    return i
+}
+fnc continueFor() >> int {
+    let sum int >> 0
+    for let i int >> 0; i < 6; i += 1 {
+        if i % 2 == 1 {
+            continue
+        }
+        sum += i
+    }
+    return sum
+}
+fnc innerLoopOnly() >> int {
+    let count int >> 0
+    for let i int >> 0; i < 3; i += 1 {
+        for let j int >> 0; j < 3; j += 1 {
+            if j == 1 {
+                break
+            }
+            count += 1
+        }
+    }
+    return count
+}
+fnc inElse() >> int {
+    let i int >> 0
+    while true {
+        if i < 2 {
+            i += 1
+        } else {
+            break
+        }
+    }
+    return i
+}
+`
+	runCallCases(t, src, []callCase{
+		{"break in while", "breakWhile", int64(4)},
+		{"continue in for", "continueFor", int64(6)},
+		{"break leaves the inner loop", "innerLoopOnly", int64(3)},
+		{"break in else", "inElse", int64(2)},
+	})
+}
+
+func TestDivisionByZero(t *testing.T) {
+	src := `
+let zero int >> 0
+fnc divide() >> int {
+    return 1 / zero
+}
+fnc modulo() >> int {
+    return 7 % zero
+}
+fnc compound() >> int {
+    let n int >> 4
+    n /= zero
+    return n
+}
+fnc floatDivide() >> float {
+    return 1.5 / 0.0
+}
+fnc nonZero() >> int {
+    return 7 / 2 + 7 % 2
+}
+`
+	runCallCases(t, src, []callCase{
+		{"non-zero divisor", "nonZero", int64(4)},
+		{"floats follow IEEE 754", "floatDivide", math.Inf(1)},
+	})
+	runCallErrorCases(t, src, []callErrorCase{
+		{"division", "divide", "division by zero on line 4:14"},
+		{"modulo", "modulo", "modulo by zero on line 7:14"},
+		{"compound", "compound", "division by zero on line 11:5"},
+	})
+}
+
+func TestOperatorOverloading(t *testing.T) {
+	src := `
+struct Vec {
+    x int
+    y int
+}
+fnc Vec.add(o Vec) >> Vec {
+    return Vec{ x: this.x + o.x, y: this.y + o.y }
+}
+fnc Vec.sub(o Vec) >> Vec {
+    return Vec{ x: this.x - o.x, y: this.y - o.y }
+}
+fnc Vec.eq(o Vec) >> bool {
+    return this.x == o.x and this.y == o.y
+}
+let a Vec >> Vec{ x: 1, y: 2 }
+let b Vec >> Vec{ x: 10, y: 20 }
+fnc add() >> int {
+    let c Vec >> a + b
+    return c.x + c.y
+}
+fnc sub() >> int {
+    let c Vec >> b - a
+    return c.x * 100 + c.y
+}
+fnc equal() >> bool {
+    return a + b == Vec{ x: 11, y: 22 }
+}
+fnc notEqual() >> bool {
+    return a != Vec{ x: 1, y: 2 }
+}
+`
+	runCallCases(t, src, []callCase{
+		{"add", "add", int64(33)},
+		{"sub", "sub", int64(918)},
+		{"eq", "equal", true},
+		{"not eq", "notEqual", false},
+	})
+
+	src = `
+struct Money {
+    cents int
+}
+fnc Money.eq(o Money) >> int {
+    return this.cents - o.cents
+}
+let m Money >> Money{ cents: 1 }
+fnc compare() >> bool {
+    return m == m
+}
+fnc compareNot() >> bool {
+    return m != m
+}
+`
+	runCallErrorCases(t, src, []callErrorCase{
+		{"eq returning an int", "compare", "Money.eq must return bool, got int on line 10:14"},
+		{"eq returning an int for !=", "compareNot", "Money.eq must return bool, got int on line 13:14"},
+	})
+}
+
+func TestSliceSteps(t *testing.T) {
+	src := `
+let xs int[] >> [0, 1, 2, 3, 4, 5]
+fnc whole() >> int[] {
+    return xs[:]
+}
+fnc ranged() >> int[] {
+    return xs[1:4]
+}
+fnc everyOther() >> int[] {
+    return xs[0:10:2]
+}
+fnc stepped() >> int[] {
+    return xs[::2]
+}
+fnc reversed() >> int[] {
+    return xs[::-1]
+}
+fnc reversedStep() >> int[] {
+    return xs[::-2]
+}
+`
+	runCallCases(t, src, []callCase{
+		{"whole", "whole", []interface{}{int64(0), int64(1), int64(2), int64(3), int64(4), int64(5)}},
+		{"range", "ranged", []interface{}{int64(1), int64(2), int64(3)}},
+		{"step past the end", "everyOther", []interface{}{int64(0), int64(2), int64(4)}},
+		{"step", "stepped", []interface{}{int64(0), int64(2), int64(4)}},
+		{"negative step", "reversed", []interface{}{int64(5), int64(4), int64(3), int64(2), int64(1), int64(0)}},
+		{"negative step of 2", "reversedStep", []interface{}{int64(5), int64(3), int64(1)}},
+	})
+}
+
+func TestNegativeSliceBounds(t *testing.T) {
+	src := `
+let xs int[] >> [0, 1, 2, 3, 4, 5]
+fnc lastTwo() >> int[] {
+    return xs[-2:]
+}
+fnc allButLast() >> int[] {
+    return xs[:-1]
+}
+fnc middle() >> int[] {
+    return xs[-3:-1]
+}
+fnc beforeTheStart() >> int[] {
+    return xs[-100:2]
+}
+fnc empty() >> int[] {
+    return xs[-1:-3]
+}
+`
+	runCallCases(t, src, []callCase{
+		{"xs[-2:]", "lastTwo", []interface{}{int64(4), int64(5)}},
+		{"xs[:-1]", "allButLast", []interface{}{int64(0), int64(1), int64(2), int64(3), int64(4)}},
+		{"xs[-3:-1]", "middle", []interface{}{int64(3), int64(4)}},
+		{"clamped to the start", "beforeTheStart", []interface{}{int64(0), int64(1)}},
+		{"start after end", "empty", []interface{}{}},
+	})
+}
+
+func TestInOperator(t *testing.T) {
+	src := `
+let xs int[] >> [1, 2, 3]
+let m :>> map[string] >> int { "a": 1 }
+fnc inArray() >> bool {
+    return 2 in xs
+}
+fnc notInArray() >> bool {
+    return 5 in xs
+}
+fnc keyInMap() >> bool {
+    return "a" in m
+}
+fnc valueNotKey() >> bool {
+    return "1" in m
+}
+fnc substring() >> bool {
+    return "ell" in "hello"
+}
+fnc negated() >> bool {
+    return not (4 in xs)
+}
+fnc leftOperand() >> string {
+    return 1 in "abc"
+}
+let grid int[][] >> [[1, 2], [3]]
+fnc nestedArray() >> bool {
+    return [1, 2] in grid
+}
+fnc nestedArrayMissing() >> bool {
+    return [2, 1] in grid
+}
+fnc arrayKey() >> bool {
+    return [1] in m
+}
+`
+	runCallCases(t, src, []callCase{
+		{"array", "inArray", true},
+		{"array of arrays", "nestedArray", true},
+		{"array of arrays, other order", "nestedArrayMissing", false},
+		{"array as a map key", "arrayKey", false},
+		{"not in array", "notInArray", false},
+		{"map key", "keyInMap", true},
+		{"map value", "valueNotKey", false},
+		{"substring", "substring", true},
+		{"negated", "negated", true},
+	})
+	runCallErrorCases(t, src, []callErrorCase{
+		{"int in a string", "leftOperand", "'in' on a string expects a string on the left, got int on line 23:14"},
+	})
+}
+
+func TestReceiverMutation(t *testing.T) {
+	src := `
+struct Counter {
+    n int
+}
+fnc Counter.inc() {
+    this.n += 1
+}
+fnc Counter.reset(to int) {
+    this.n >> to
+}
+let c Counter >> Counter{ n: 0 }
+fnc increment() >> int {
+    c.inc()
+    c.inc()
+    return c.n
+}
+fnc reset() >> int {
+    c.reset(10)
+    c.inc()
+    return c.n
+}
+`
+	runCallCases(t, src, []callCase{
+		{"compound", "increment", int64(2)},
+		{"assignment", "reset", int64(11)},
+	})
+}
+
+func TestNestedArrays(t *testing.T) {
+	src := `
+fnc grid() >> int {
+    let g int[][] >> [[1, 2], [3, 4]]
+    g[1][0] >> 30
+    return g[0][1] + g[1][0]
+}
+fnc rows() >> int {
+    let g int[][] >> [[1], [2, 3], []]
+    return len(g) * 10 + len(g[1])
+}
+`
+	runCallCases(t, src, []callCase{
+		{"element assignment", "grid", int64(32)},
+		{"lengths", "rows", int64(32)},
+	})
+}
+
+func TestNestedCollections(t *testing.T) {
+	src := `
+fnc mapOfArrays() >> int {
+    let m :>> map[string] >> int[] { "a": [1, 2], "b": [3] }
+    m["b"][0] >> 30
+    return m["a"][1] + m["b"][0]
+}
+fnc arrayOfMaps() >> int {
+    let xs (map[string]int)[] >> [map[string]int{ "a": 1 }, map[string]int{ "b": 2 }]
+    xs[1]["c"] >> 3
+    return xs[0]["a"] * 100 + xs[1]["b"] * 10 + xs[1]["c"]
+}
+fnc mapInArray() >> bool {
+    let xs (map[string]int)[] >> [map[string]int{ "a": 1 }]
+    return "a" in xs[0]
+}
+`
+	runCallCases(t, src, []callCase{
+		{"map of arrays", "mapOfArrays", int64(32)},
+		{"array of maps", "arrayOfMaps", int64(123)},
+		{"key of a map in an array", "mapInArray", true},
+	})
+}
+
+func TestBudgetConcurrentSteps(t *testing.T) {
+	b := &budget{steps: 1000, depth: 1000}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b.step()
+				b.enter()
+				b.leave()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if b.steps != 200 || b.depth != 1000 {
+		t.Errorf("steps, depth = %d, %d, want 200, 1000", b.steps, b.depth)
+	}
+}
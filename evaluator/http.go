@@ -0,0 +1,74 @@
+package evaluator
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTP builtins: go.http.get and go.http.post return the response as a map
+// with its status code and body, {"status": 200, "body": "...", "error": ""}.
+// A request that fails before a response arrives (a bad URL, a refused
+// connection, a timeout) returns status 0 and an empty body, with "error"
+// describing what went wrong. Error statuses such as 404 are responses like
+// any other: check "status".
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func init() {
+	Builtins["go.http.get"] = builtinHTTPGet
+	Builtins["go.http.post"] = builtinHTTPPost
+}
+
+// go.http.get(url) sends a GET request.
+func builtinHTTPGet(args []interface{}) interface{} {
+	if len(args) != 1 {
+		builtinError("go.http.get expects 1 argument, got %d", len(args))
+	}
+	url, ok := args[0].(string)
+	if !ok {
+		builtinError("go.http.get expects a string url, got %s", typeName(args[0]))
+	}
+	return httpResponse(httpClient.Get(url))
+}
+
+// go.http.post(url, body, contentType) sends a POST request with body.
+func builtinHTTPPost(args []interface{}) interface{} {
+	if len(args) != 3 {
+		builtinError("go.http.post expects 3 arguments, got %d", len(args))
+	}
+	url, ok1 := args[0].(string)
+	body, ok2 := args[1].(string)
+	contentType, ok3 := args[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		builtinError("go.http.post expects a url, a body and a content type, all strings")
+	}
+	return httpResponse(httpClient.Post(url, contentType, strings.NewReader(body)))
+}
+
+// httpResponse turns a response, or the error that prevented one, into the
+// map returned to the program.
+func httpResponse(resp *http.Response, err error) interface{} {
+	if err != nil {
+		return httpFailure(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return httpFailure(err)
+	}
+	return map[interface{}]interface{}{
+		"status": int64(resp.StatusCode),
+		"body":   string(body),
+		"error":  "",
+	}
+}
+
+func httpFailure(err error) interface{} {
+	return map[interface{}]interface{}{
+		"status": int64(0),
+		"body":   "",
+		"error":  err.Error(),
+	}
+}
@@ -0,0 +1,71 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPBuiltins(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hello":
+			fmt.Fprint(w, "hello")
+		case "/echo":
+			body, _ := io.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("Content-Type"), body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	response := func(status int64, body string) map[interface{}]interface{} {
+		return map[interface{}]interface{}{"status": status, "body": body, "error": ""}
+	}
+	runBuiltinCases(t, []builtinCase{
+		{"get", "go.http.get", []interface{}{srv.URL + "/hello"}, response(200, "hello")},
+		{"not found", "go.http.get", []interface{}{srv.URL + "/missing"}, response(404, "404 page not found\n")},
+		{"post", "go.http.post", []interface{}{srv.URL + "/echo", `{"a":1}`, "application/json"},
+			response(200, `POST application/json {"a":1}`)},
+	})
+
+	// A closed server refuses the connection
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	got := Builtins["go.http.get"]([]interface{}{closed.URL}).(map[interface{}]interface{})
+	if got["status"] != int64(0) || got["body"] != "" || !strings.Contains(got["error"].(string), "refused") {
+		t.Errorf("go.http.get of a closed server = %v, want status 0 and a connection refused error", got)
+	}
+	got = Builtins["go.http.get"]([]interface{}{"://bad"}).(map[interface{}]interface{})
+	if got["status"] != int64(0) || got["error"] == "" {
+		t.Errorf("go.http.get of a bad url = %v, want status 0 and an error", got)
+	}
+
+	runBuiltinErrorCases(t, []builtinErrorCase{
+		{"get count", "go.http.get", nil, "go.http.get expects 1 argument, got 0"},
+		{"get url type", "go.http.get", []interface{}{int64(1)}, "go.http.get expects a string url, got int"},
+		{"post count", "go.http.post", []interface{}{srv.URL}, "go.http.post expects 3 arguments, got 1"},
+		{"post body type", "go.http.post", []interface{}{srv.URL, int64(1), "text/plain"}, "go.http.post expects a url, a body and a content type, all strings"},
+	})
+}
+
+func TestHTTPFromProgram(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "pong")
+	}))
+	defer srv.Close()
+	src := fmt.Sprintf(`
+fnc ping() >> string {
+    let resp map[string]any >> go.http.get(%q)
+    if resp["status"] == 200 {
+        return resp["body"]
+    }
+    return resp["error"]
+}
+`, srv.URL)
+	runCallCases(t, src, []callCase{{"status and body", "ping", "pong"}})
+}
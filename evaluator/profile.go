@@ -0,0 +1,77 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NodeStats is how often one kind of AST node was evaluated and for how long.
+type NodeStats struct {
+	Node  string
+	Count int
+	Total time.Duration
+}
+
+// Profiler accumulates evaluation time per expression node type. Times are
+// inclusive: a CallExpression's time includes the body of the called function,
+// a BinaryExpression's the time of its operands. Goroutines started with
+// go.async are timed too, so the stats are guarded by mu.
+type Profiler struct {
+	mu    sync.Mutex
+	stats map[string]*NodeStats
+}
+
+// profiler is nil unless profiling was enabled, so evalExpr only pays for a
+// nil check. It is guarded by hooksMu.
+var profiler *Profiler
+
+// EnableProfiling starts timing every expression evaluated from now on.
+func EnableProfiling() *Profiler {
+	p := &Profiler{stats: map[string]*NodeStats{}}
+	hooksMu.Lock()
+	profiler = p
+	hooksMu.Unlock()
+	return p
+}
+
+// track starts timing node and returns the function that stops it.
+func (p *Profiler) track(node interface{}) func() {
+	name := reflect.TypeOf(node).Elem().Name()
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		s, ok := p.stats[name]
+		if !ok {
+			s = &NodeStats{Node: name}
+			p.stats[name] = s
+		}
+		s.Count++
+		s.Total += elapsed
+	}
+}
+
+// Stats returns the collected timings, most expensive node type first.
+func (p *Profiler) Stats() []NodeStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]NodeStats, 0, len(p.stats))
+	for _, s := range p.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Total > stats[j].Total })
+	return stats
+}
+
+// WriteSummary prints a table of the collected timings to w.
+func (p *Profiler) WriteSummary(w io.Writer) {
+	fmt.Fprintln(w, "Evaluation time per node type (inclusive):")
+	for _, s := range p.Stats() {
+		fmt.Fprintf(w, "  %-20s %10d calls %14s\n", s.Node, s.Count, s.Total)
+	}
+}
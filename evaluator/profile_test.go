@@ -0,0 +1,34 @@
+package evaluator
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/notrealandy/tox/ast"
+)
+
+func TestProfilerConcurrentTracking(t *testing.T) {
+	p := &Profiler{stats: map[string]*NodeStats{}}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p.track(&ast.Identifier{})()
+				p.track(&ast.IntegerLiteral{})()
+			}
+		}()
+	}
+	wg.Wait()
+
+	counts := map[string]int{}
+	for _, s := range p.Stats() {
+		counts[s.Node] = s.Count
+	}
+	for _, node := range []string{"Identifier", "IntegerLiteral"} {
+		if counts[node] != 800 {
+			t.Errorf("%s count = %d, want 800", node, counts[node])
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package lexer
 
 import (
+	"fmt"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/notrealandy/tox/token"
 )
@@ -12,18 +15,33 @@ type Lexer struct {
 	readPosition int  // next char position
 	ch           byte // current char under examination
 	line         int  // track line number
-	col          int  // track column number
+	col          int  // track column number (in runes, tab-aware)
+	tabWidth     int  // columns a tab advances to the next stop of
+	Errors       []string
 }
 
-// prepares the string for tokenization
+// prepares the string for tokenization, counting a tab as a single column
 func New(input string) *Lexer {
-	l := &Lexer{input: input, line: 1, col: 0}
+	return NewWithTabWidth(input, 1)
+}
+
+// prepares the string for tokenization, expanding tabs to the given width when
+// computing columns so that positions line up with what editors display.
+// A leading UTF-8 byte order mark, added by some Windows editors, is dropped.
+func NewWithTabWidth(input string, tabWidth int) *Lexer {
+	if tabWidth < 1 {
+		tabWidth = 1
+	}
+	input = strings.TrimPrefix(input, "\uFEFF")
+	l := &Lexer{input: input, line: 1, col: 0, tabWidth: tabWidth}
 	l.readChar()
 	return l
 }
 
-// a function to read characters in string
+// a function to read characters in string. l.col is the 1-based column of l.ch:
+// a newline itself is column 0, so the first character of every line is column 1.
 func (l *Lexer) readChar() {
+	prev := l.ch
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -32,6 +50,11 @@ func (l *Lexer) readChar() {
 
 	if l.ch == '\n' {
 		l.col = 0
+	} else if isContinuationByte(l.ch) {
+		// Trailing byte of a multi-byte UTF-8 rune: same column as its lead byte
+	} else if prev == '\t' {
+		// Advance to the next tab stop
+		l.col = ((l.col-1)/l.tabWidth+1)*l.tabWidth + 1
 	} else {
 		l.col++
 	}
@@ -40,14 +63,20 @@ func (l *Lexer) readChar() {
 	l.readPosition++
 }
 
-// a function to skip whitespaces and other non-important characters in code
+// a function to skip whitespaces and other non-important characters in code.
+// `//` and `/* */` comments are skipped here too, so they may appear between any
+// two tokens, including inside multi-line array, map and struct literals.
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' || (l.ch == '/' && l.peekChar() == '/') {
+	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' || (l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*')) {
 		if l.ch == '/' && l.peekChar() == '/' {
 			// Skip the comment
 			for l.ch != '\n' && l.ch != 0 {
 				l.readChar()
 			}
+		} else if l.ch == '/' && l.peekChar() == '*' {
+			if !l.skipBlockComment() {
+				return
+			}
 		} else {
 			if l.ch == '\n' {
 				l.line++
@@ -57,6 +86,27 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
+// skipBlockComment skips a /* ... */ comment, counting the lines it spans.
+// It reports false, and records a lexer error, if EOF is reached first.
+func (l *Lexer) skipBlockComment() bool {
+	line, col := l.line, l.col
+	l.readChar() // skip '/'
+	l.readChar() // skip '*'
+	for !(l.ch == '*' && l.peekChar() == '/') {
+		if l.ch == 0 {
+			l.Errors = append(l.Errors, fmt.Sprintf("unterminated block comment starting on line %d:%d", line, col))
+			return false
+		}
+		if l.ch == '\n' {
+			l.line++
+		}
+		l.readChar()
+	}
+	l.readChar() // skip '*'
+	l.readChar() // skip '/'
+	return true
+}
+
 // a function that allows you to look which character is next
 func (l *Lexer) peekChar() byte {
 	if l.readPosition >= len(l.input) {
@@ -65,27 +115,130 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPosition]
 }
 
-// a function that reads strings
-func (l *Lexer) readString() string {
-	position := l.position + 1
+// a function that reads strings, decoding escape sequences (\n, \t, \r, \", \\)
+// and reporting false if EOF is reached before the closing quote
+func (l *Lexer) readString() (string, bool) {
+	var out strings.Builder
 	for {
 		l.readChar()
+		if l.ch == '\n' {
+			l.line++
+		}
 		if l.ch == '"' || l.ch == 0 {
 			break
 		}
+		if l.ch == '\\' {
+			if esc, ok := escapes[l.peekChar()]; ok {
+				l.readChar()
+				out.WriteByte(esc)
+				continue
+			}
+		}
+		out.WriteByte(l.ch)
+	}
+	if l.ch == 0 {
+		return out.String(), false
+	}
+	l.readChar()
+	return out.String(), true
+}
+
+// a function that reads a character literal such as 'A' or '\n', decoding
+// escapes like readString. It reports false if the line or input ends before
+// the closing quote; checking that exactly one character was read is up to the caller.
+func (l *Lexer) readCharLiteral() (string, bool) {
+	var out strings.Builder
+	for {
+		l.readChar()
+		if l.ch == '\'' {
+			break
+		}
+		if l.ch == '\n' || l.ch == 0 {
+			return out.String(), false
+		}
+		if l.ch == '\\' {
+			if esc, ok := escapes[l.peekChar()]; ok {
+				l.readChar()
+				out.WriteByte(esc)
+				continue
+			}
+		}
+		out.WriteByte(l.ch)
 	}
-	str := l.input[position:l.position]
 	l.readChar()
-	return str
+	return out.String(), true
+}
+
+// escapes maps the character after a backslash to the byte it stands for.
+// Unknown escapes are kept as written.
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'"':  '"',
+	'\'': '\'',
+	'\\': '\\',
+}
+
+// illegal records a lexer error and returns an ILLEGAL token for it
+func (l *Lexer) illegal(literal string, line, col int, format string, args ...interface{}) token.Token {
+	l.Errors = append(l.Errors, fmt.Sprintf(format, args...))
+	return token.Token{Type: token.ILLEGAL, Literal: literal, Line: line, Col: col}
 }
 
-// a function that reads numbers
-func (l *Lexer) readNumber() string {
+// a function that reads numbers, returning INT for 3 and FLOAT for 3.14 or 2.5e-4.
+// A type suffix pins the type: 3f is a FLOAT, 10L an INT; the suffix is not
+// part of the returned literal. A second decimal point (1.2.3), an exponent
+// without digits (1e, 1e+) or an L on a float makes the literal ILLEGAL.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	pos := l.position
+	typ := token.TokenType(token.INT)
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[pos:l.position]
+	for l.ch == '.' && isDigit(l.peekChar()) {
+		if typ == token.INT {
+			typ = token.FLOAT
+		} else {
+			typ = token.ILLEGAL
+		}
+		l.readChar()
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		if typ == token.INT {
+			typ = token.FLOAT
+		}
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		if !isDigit(l.ch) {
+			typ = token.ILLEGAL
+		}
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+	literal := l.input[pos:l.position]
+	switch l.ch {
+	case 'f', 'F':
+		l.readChar()
+		if typ == token.INT {
+			typ = token.FLOAT
+		}
+	case 'L':
+		l.readChar()
+		if typ == token.FLOAT {
+			return l.input[pos:l.position], token.ILLEGAL
+		}
+	}
+	if typ == token.ILLEGAL {
+		return l.input[pos:l.position], typ
+	}
+	return literal, typ
 }
 
 func isIdentChar(ch byte) bool {
@@ -99,17 +252,18 @@ func isIdentChar(ch byte) bool {
 // 3. string
 func (l *Lexer) readIdentifier() string {
 	pos := l.position
-	if !isLetter(l.ch) && l.ch != '_' {
+	if !isLetter(l.ch) && l.ch != '_' && !l.isUnicodeLetter() {
 		return ""
 	}
-	l.readChar()
-	for isIdentChar(l.ch) {
-		l.readChar()
-	}
-	// If the next two characters are '[' and ']', include them
-	if l.ch == '[' && l.peekChar() == ']' {
-		l.readChar() // skip '['
-		l.readChar() // skip ']'
+	for isIdentChar(l.ch) || l.isUnicodeLetter() || l.isUnicodeDigit() {
+		if l.ch < utf8.RuneSelf {
+			l.readChar()
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(l.input[l.position:])
+		for i := 0; i < size; i++ {
+			l.readChar()
+		}
 	}
 	return l.input[pos:l.position]
 }
@@ -119,26 +273,61 @@ func isLetter(ch byte) bool {
 	return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ch == '_'
 }
 
+// a function that checks if the multi-byte rune at the current position is a
+// Unicode letter, so identifiers such as café or 名前 are allowed
+func (l *Lexer) isUnicodeLetter() bool {
+	if l.ch < utf8.RuneSelf {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(l.input[l.position:])
+	return unicode.IsLetter(r)
+}
+
+// a function that checks if the multi-byte rune at the current position is a
+// Unicode digit, which may appear in identifiers after the first character
+func (l *Lexer) isUnicodeDigit() bool {
+	if l.ch < utf8.RuneSelf {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(l.input[l.position:])
+	return unicode.IsDigit(r)
+}
+
+// a function that checks if the byte continues a multi-byte UTF-8 sequence
+func isContinuationByte(ch byte) bool {
+	return ch&0xC0 == 0x80
+}
+
 // a function that checks if the character is a digit
 func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+// IsKeyword reports whether tok is a keyword or a built-in type name, such as
+// match or map, rather than an identifier or a literal.
+func IsKeyword(tok token.Token) bool {
+	return tok.Type != token.IDENT && lookupIdent(tok.Literal) == tok.Type
+}
+
 // a function to validate token types
 func lookupIdent(ident string) token.TokenType {
 	switch strings.ToLower(ident) {
 	case "let":
 		return token.LET
+	case "const":
+		return token.CONST
 	case "fnc":
 		return token.FNC
 	case "log":
 		return token.LOG
-	case "string", "int", "bool", "any", "void", "int[]", "string[]", "bool[]", "any[]":
+	case "string", "int", "float", "bool", "any", "void":
 		return token.TYPE
 	case "true", "false":
 		return token.BOOL
 	case "return":
 		return token.RETURN
+	case "assert":
+		return token.ASSERT
 	case "nil":
 		return token.NIL
 	case "if":
@@ -149,8 +338,18 @@ func lookupIdent(ident string) token.TokenType {
 		return token.ELSE
 	case "while":
 		return token.WHILE
+	case "with":
+		return token.WITH
 	case "for":
 		return token.FOR
+	case "in":
+		return token.IN
+	case "not":
+		return token.NOT
+	case "and":
+		return token.AND
+	case "or":
+		return token.OR
 	case "len":
 		return token.LEN
 	case "input":
@@ -163,12 +362,20 @@ func lookupIdent(ident string) token.TokenType {
 		return token.PUB
 	case "struct":
 		return token.STRUCT
+	case "type":
+		return token.TYPEDEF
 	case "map":
 		return token.TYPE
 	case "break":
 		return token.BREAK
 	case "continue":
 		return token.CONTINUE
+	case "match":
+		return token.MATCH
+	case "case":
+		return token.CASE
+	case "default":
+		return token.DEFAULT
 	default:
 		return token.IDENT
 	}
@@ -205,26 +412,50 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			tok = token.Token{Type: token.EQ, Literal: "==", Line: l.line, Col: startCol}
 		} else {
-			tok = token.Token{Type: token.ILLEGAL, Literal: string(l.ch), Line: l.line, Col: startCol}
+			tok = l.illegal(string(l.ch), l.line, startCol, "illegal character '%c' on line %d:%d", l.ch, l.line, startCol)
 		}
 	case '!':
 		if l.peekChar() == '=' {
 			l.readChar()
 			tok = token.Token{Type: token.NEQ, Literal: "!=", Line: l.line, Col: startCol}
 		} else {
-			tok = token.Token{Type: token.ILLEGAL, Literal: string(l.ch), Line: l.line, Col: startCol}
+			tok = token.Token{Type: token.NOT, Literal: "!", Line: l.line, Col: startCol}
 		}
 	case '"':
+		startLine := l.line
+		str, ok := l.readString()
+		if !ok {
+			return l.illegal(str, startLine, startCol, "unterminated string literal on line %d:%d", startLine, startCol)
+		}
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok.Literal = str
+		tok.Line = startLine
+		tok.Col = startCol
+		return tok
+	case '\'':
+		literal, ok := l.readCharLiteral()
+		if !ok {
+			return l.illegal(literal, l.line, startCol, "unterminated character literal on line %d:%d", l.line, startCol)
+		}
+		if utf8.RuneCountInString(literal) != 1 {
+			return l.illegal(literal, l.line, startCol, "character literal must contain exactly one character on line %d:%d", l.line, startCol)
+		}
+		tok.Type = token.CHAR
+		tok.Literal = literal
 		tok.Line = l.line
 		tok.Col = startCol
 		return tok
 	case '`':
+		startLine := l.line
+		str, ok := l.readBacktickString()
+		if !ok {
+			return l.illegal(str, startLine, startCol, "unterminated raw string literal on line %d:%d", startLine, startCol)
+		}
 		tok.Type = token.STRING
-		tok.Literal = l.readBacktickString()
-		tok.Line = l.line
+		tok.Literal = str
+		tok.Line = startLine
 		tok.Col = startCol
+		tok.Raw = true
 		return tok
 	case '(':
 		tok = token.Token{Type: token.LPAREN, Literal: "(", Line: l.line, Col: startCol}
@@ -235,13 +466,33 @@ func (l *Lexer) NextToken() token.Token {
 	case '}':
 		tok = token.Token{Type: token.RBRACE, Literal: "}", Line: l.line, Col: startCol}
 	case '+':
-		tok = token.Token{Type: token.PLUS, Literal: "+", Line: l.line, Col: startCol}
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.PLUS_ASSIGN, Literal: "+=", Line: l.line, Col: startCol}
+		} else {
+			tok = token.Token{Type: token.PLUS, Literal: "+", Line: l.line, Col: startCol}
+		}
 	case '-':
-		tok = token.Token{Type: token.MINUS, Literal: "-", Line: l.line, Col: startCol}
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.MINUS_ASSIGN, Literal: "-=", Line: l.line, Col: startCol}
+		} else {
+			tok = token.Token{Type: token.MINUS, Literal: "-", Line: l.line, Col: startCol}
+		}
 	case '*':
-		tok = token.Token{Type: token.ASTERISK, Literal: "*", Line: l.line, Col: startCol}
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.ASTERISK_ASSIGN, Literal: "*=", Line: l.line, Col: startCol}
+		} else {
+			tok = token.Token{Type: token.ASTERISK, Literal: "*", Line: l.line, Col: startCol}
+		}
 	case '/':
-		tok = token.Token{Type: token.SLASH, Literal: "/", Line: l.line, Col: startCol}
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.SLASH_ASSIGN, Literal: "/=", Line: l.line, Col: startCol}
+		} else {
+			tok = token.Token{Type: token.SLASH, Literal: "/", Line: l.line, Col: startCol}
+		}
 	case '%':
 		tok = token.Token{Type: token.MODULUS, Literal: "%", Line: l.line, Col: startCol}
 	case '&':
@@ -249,14 +500,14 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			tok = token.Token{Type: token.AND, Literal: "&&", Line: l.line, Col: startCol}
 		} else {
-			tok = token.Token{Type: token.ILLEGAL, Literal: string(l.ch), Line: l.line, Col: startCol}
+			tok = l.illegal(string(l.ch), l.line, startCol, "illegal character '%c' on line %d:%d", l.ch, l.line, startCol)
 		}
 	case '|':
 		if l.peekChar() == '|' {
 			l.readChar()
 			tok = token.Token{Type: token.OR, Literal: "||", Line: l.line, Col: startCol}
 		} else {
-			tok = token.Token{Type: token.ILLEGAL, Literal: string(l.ch), Line: l.line, Col: startCol}
+			tok = l.illegal(string(l.ch), l.line, startCol, "illegal character '%c' on line %d:%d", l.ch, l.line, startCol)
 		}
 	case ',':
 		tok = token.Token{Type: token.COMMA, Literal: ",", Line: l.line, Col: startCol}
@@ -268,13 +519,28 @@ func (l *Lexer) NextToken() token.Token {
 		tok = token.Token{Type: token.RBRACKET, Literal: "]", Line: l.line, Col: startCol}
 	case ':':
 		tok = token.Token{Type: token.COLON, Literal: ":", Line: l.line, Col: startCol}
+	case '?':
+		tok = token.Token{Type: token.QUESTION, Literal: "?", Line: l.line, Col: startCol}
+	case '@':
+		tok = token.Token{Type: token.AT, Literal: "@", Line: l.line, Col: startCol}
 	case '.':
-		tok = token.Token{Type: token.DOT, Literal: ".", Line: l.line, Col: startCol}
+		if l.peekChar() == '.' && l.readPosition+1 < len(l.input) && l.input[l.readPosition+1] == '.' {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "...", Line: l.line, Col: startCol}
+		} else if l.peekChar() == '.' {
+			l.readChar()
+			tok = token.Token{Type: token.RANGE, Literal: "..", Line: l.line, Col: startCol}
+		} else {
+			tok = token.Token{Type: token.DOT, Literal: ".", Line: l.line, Col: startCol}
+		}
 	case 0:
 		tok.Type = token.EOF
 		tok.Literal = ""
+		tok.Line = l.line
+		tok.Col = startCol
 	default:
-		if isLetter(l.ch) {
+		if isLetter(l.ch) || l.isUnicodeLetter() {
 			literal := l.readIdentifier()
 			tok.Type = lookupIdent(literal)
 			tok.Literal = literal
@@ -282,30 +548,50 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Col = startCol
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			literal, typ := l.readNumber()
+			if typ == token.ILLEGAL {
+				return l.illegal(literal, l.line, startCol, "malformed number '%s' on line %d:%d", literal, l.line, startCol)
+			}
+			tok.Type = typ
+			tok.Literal = literal
 			tok.Line = l.line
 			tok.Col = startCol
 			return tok
+		} else if l.ch >= utf8.RuneSelf {
+			// Report a multi-byte character once, not once per byte
+			r, size := utf8.DecodeRuneInString(l.input[l.position:])
+			tok = l.illegal(string(r), l.line, startCol, "illegal character '%c' on line %d:%d", r, l.line, startCol)
+			for i := 0; i < size; i++ {
+				l.readChar()
+			}
+			return tok
 		} else {
-			tok = token.Token{Type: token.ILLEGAL, Literal: string(l.ch), Line: l.line, Col: startCol}
+			tok = l.illegal(string(l.ch), l.line, startCol, "illegal character '%c' on line %d:%d", l.ch, l.line, startCol)
 		}
 	}
 	l.readChar()
 	return tok
 }
 
-func (l *Lexer) readBacktickString() string {
-    position := l.position + 1 // skip opening `
-    for {
-        l.readChar()
-        if l.ch == '`' || l.ch == 0 {
-            break
-        }
-    }
-    str := l.input[position:l.position]
-    l.readChar() // skip closing `
-    return dedentMultilineString(str)
+// a function that reads raw `...` strings, reporting false if EOF is reached
+// before the closing backtick
+func (l *Lexer) readBacktickString() (string, bool) {
+	position := l.position + 1 // skip opening `
+	for {
+		l.readChar()
+		if l.ch == '\n' {
+			l.line++
+		}
+		if l.ch == '`' || l.ch == 0 {
+			break
+		}
+	}
+	str := l.input[position:l.position]
+	if l.ch == 0 {
+		return str, false
+	}
+	l.readChar() // skip closing `
+	return dedentMultilineString(str), true
 }
 
 func dedentMultilineString(s string) string {
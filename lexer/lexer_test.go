@@ -0,0 +1,409 @@
+package lexer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/notrealandy/tox/token"
+)
+
+func TestIsKeyword(t *testing.T) {
+	tests := []struct {
+		tok  token.Token
+		want bool
+	}{
+		{token.Token{Type: token.MATCH, Literal: "match"}, true},
+		{token.Token{Type: token.TYPE, Literal: "map"}, true},
+		{token.Token{Type: token.TYPE, Literal: "string"}, true},
+		{token.Token{Type: token.IDENT, Literal: "regex"}, false},
+		{token.Token{Type: token.STRING, Literal: "match"}, false},
+		{token.Token{Type: token.INT, Literal: "3"}, false},
+	}
+	for _, tt := range tests {
+		if got := IsKeyword(tt.tok); got != tt.want {
+			t.Errorf("IsKeyword(%s %q) = %v, want %v", tt.tok.Type, tt.tok.Literal, got, tt.want)
+		}
+	}
+}
+
+// tok builds the token expected at line:col.
+func tok(typ token.TokenType, literal string, line, col int) token.Token {
+	return token.Token{Type: typ, Literal: literal, Line: line, Col: col}
+}
+
+// lexAll returns the tokens of l up to, but not including, EOF.
+func lexAll(l *Lexer) []token.Token {
+	var toks []token.Token
+	for i := 0; i < 1000; i++ {
+		t := l.NextToken()
+		if t.Type == token.EOF {
+			break
+		}
+		toks = append(toks, t)
+	}
+	return toks
+}
+
+// lexCase is an input and the tokens it should lex to.
+type lexCase struct {
+	name  string
+	input string
+	want  []token.Token
+}
+
+func runLexCases(t *testing.T, tests []lexCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.input)
+			got := lexAll(l)
+			if len(l.Errors) > 0 {
+				t.Fatalf("unexpected errors: %v", l.Errors)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokens of %q:\n got %v\nwant %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPositions(t *testing.T) {
+	runLexCases(t, []lexCase{
+		{"let statement", "let x int >> 10", []token.Token{
+			tok(token.LET, "let", 1, 1),
+			tok(token.IDENT, "x", 1, 5),
+			tok(token.TYPE, "int", 1, 7),
+			tok(token.ASSIGN_OP, ">>", 1, 11),
+			tok(token.INT, "10", 1, 14),
+		}},
+		{"two-character operators", "a>=b<=c==d!=e&&f||g", []token.Token{
+			tok(token.IDENT, "a", 1, 1),
+			tok(token.GTE, ">=", 1, 2),
+			tok(token.IDENT, "b", 1, 4),
+			tok(token.LTE, "<=", 1, 5),
+			tok(token.IDENT, "c", 1, 7),
+			tok(token.EQ, "==", 1, 8),
+			tok(token.IDENT, "d", 1, 10),
+			tok(token.NEQ, "!=", 1, 11),
+			tok(token.IDENT, "e", 1, 13),
+			tok(token.AND, "&&", 1, 14),
+			tok(token.IDENT, "f", 1, 16),
+			tok(token.OR, "||", 1, 17),
+			tok(token.IDENT, "g", 1, 19),
+		}},
+		{"lines", "fnc main() {\n    log(\"hi\")\n}", []token.Token{
+			tok(token.FNC, "fnc", 1, 1),
+			tok(token.IDENT, "main", 1, 5),
+			tok(token.LPAREN, "(", 1, 9),
+			tok(token.RPAREN, ")", 1, 10),
+			tok(token.LBRACE, "{", 1, 12),
+			tok(token.LOG, "log", 2, 5),
+			tok(token.LPAREN, "(", 2, 8),
+			tok(token.STRING, "hi", 2, 9),
+			tok(token.RPAREN, ")", 2, 13),
+			tok(token.RBRACE, "}", 3, 1),
+		}},
+		{"comment", "x // note\ny", []token.Token{
+			tok(token.IDENT, "x", 1, 1),
+			tok(token.IDENT, "y", 2, 1),
+		}},
+		{"comments in a multi-line array", "[1, // first\n 2, // second\n 3]", []token.Token{
+			tok(token.LBRACKET, "[", 1, 1),
+			tok(token.INT, "1", 1, 2),
+			tok(token.COMMA, ",", 1, 3),
+			tok(token.INT, "2", 2, 2),
+			tok(token.COMMA, ",", 2, 3),
+			tok(token.INT, "3", 3, 2),
+			tok(token.RBRACKET, "]", 3, 3),
+		}},
+		{"multi-byte runes", `"héllo" + x`, []token.Token{
+			tok(token.STRING, "héllo", 1, 1),
+			tok(token.PLUS, "+", 1, 9),
+			tok(token.IDENT, "x", 1, 11),
+		}},
+	})
+}
+
+func TestTabWidth(t *testing.T) {
+	tests := []struct {
+		tabWidth int
+		input    string
+		wantCol  int
+	}{
+		{1, "\tx", 2},
+		{4, "\tx", 5},
+		{4, "ab\tx", 5},
+		{4, "abcd\tx", 9},
+		{8, "\t\tx", 17},
+		{0, "\tx", 2},
+	}
+	for _, tt := range tests {
+		toks := lexAll(NewWithTabWidth(tt.input, tt.tabWidth))
+		got := toks[len(toks)-1]
+		if got.Literal != "x" || got.Col != tt.wantCol {
+			t.Errorf("tab width %d, %q: x at column %d, want %d", tt.tabWidth, tt.input, got.Col, tt.wantCol)
+		}
+	}
+}
+
+func TestUnterminatedLiterals(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    token.Token
+		wantErr string
+	}{
+		{"string", `let s string >> "abc`, tok(token.ILLEGAL, "abc", 1, 17),
+			"unterminated string literal on line 1:17"},
+		{"string over lines", "log(\"a\nb", tok(token.ILLEGAL, "a\nb", 1, 5),
+			"unterminated string literal on line 1:5"},
+		{"raw string", "x >> `abc", tok(token.ILLEGAL, "abc", 1, 6),
+			"unterminated raw string literal on line 1:6"},
+		{"character", "x >> 'a", tok(token.ILLEGAL, "a", 1, 6),
+			"unterminated character literal on line 1:6"},
+		{"block comment", "x /* note", tok(token.IDENT, "x", 1, 1),
+			"unterminated block comment starting on line 1:3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.input)
+			toks := lexAll(l)
+			if got := toks[len(toks)-1]; got != tt.want {
+				t.Errorf("last token = %v, want %v", got, tt.want)
+			}
+			if len(l.Errors) != 1 || l.Errors[0] != tt.wantErr {
+				t.Errorf("errors = %q, want [%q]", l.Errors, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	runLexCases(t, []lexCase{
+		{"newline and tab", `"a\nb\tc"`, []token.Token{tok(token.STRING, "a\nb\tc", 1, 1)}},
+		{"quote and backslash", `"say \"hi\" \\ bye"`, []token.Token{tok(token.STRING, `say "hi" \ bye`, 1, 1)}},
+		{"carriage return", `"a\rb"`, []token.Token{tok(token.STRING, "a\rb", 1, 1)}},
+		{"unknown escape", `"a\qb"`, []token.Token{tok(token.STRING, `a\qb`, 1, 1)}},
+		{"position after escapes", `"\n\n" x`, []token.Token{
+			tok(token.STRING, "\n\n", 1, 1),
+			tok(token.IDENT, "x", 1, 8),
+		}},
+	})
+}
+
+func TestBlockComments(t *testing.T) {
+	runLexCases(t, []lexCase{
+		{"inline", "a /* b */ c", []token.Token{
+			tok(token.IDENT, "a", 1, 1),
+			tok(token.IDENT, "c", 1, 11),
+		}},
+		{"over lines", "a /* b\nc\n*/ d", []token.Token{
+			tok(token.IDENT, "a", 1, 1),
+			tok(token.IDENT, "d", 3, 4),
+		}},
+		{"inside an expression", "[1, /* two */ 2]", []token.Token{
+			tok(token.LBRACKET, "[", 1, 1),
+			tok(token.INT, "1", 1, 2),
+			tok(token.COMMA, ",", 1, 3),
+			tok(token.INT, "2", 1, 15),
+			tok(token.RBRACKET, "]", 1, 16),
+		}},
+		{"stars", "/** doc **/ x", []token.Token{tok(token.IDENT, "x", 1, 13)}},
+	})
+}
+
+func TestCharLiterals(t *testing.T) {
+	runLexCases(t, []lexCase{
+		{"letter", "'A'", []token.Token{tok(token.CHAR, "A", 1, 1)}},
+		{"escape", `'\n'`, []token.Token{tok(token.CHAR, "\n", 1, 1)}},
+		{"quote", `'\''`, []token.Token{tok(token.CHAR, "'", 1, 1)}},
+		{"multi-byte", "'é' x", []token.Token{
+			tok(token.CHAR, "é", 1, 1),
+			tok(token.IDENT, "x", 1, 5),
+		}},
+	})
+
+	l := New("'ab'")
+	if got := lexAll(l); len(got) != 1 || got[0].Type != token.ILLEGAL {
+		t.Errorf("'ab' lexed to %v, want one ILLEGAL token", got)
+	}
+	want := "character literal must contain exactly one character on line 1:1"
+	if len(l.Errors) != 1 || l.Errors[0] != want {
+		t.Errorf("errors = %q, want [%q]", l.Errors, want)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	runLexCases(t, []lexCase{
+		{"int", "42", []token.Token{tok(token.INT, "42", 1, 1)}},
+		{"float", "3.14", []token.Token{tok(token.FLOAT, "3.14", 1, 1)}},
+		{"exponent", "2.5e-4 1E3", []token.Token{
+			tok(token.FLOAT, "2.5e-4", 1, 1),
+			tok(token.FLOAT, "1E3", 1, 8),
+		}},
+		{"float suffix", "3f 1.5F", []token.Token{
+			tok(token.FLOAT, "3", 1, 1),
+			tok(token.FLOAT, "1.5", 1, 4),
+		}},
+		{"int suffix", "10L", []token.Token{tok(token.INT, "10", 1, 1)}},
+		{"method on an int", "1.x", []token.Token{
+			tok(token.INT, "1", 1, 1),
+			tok(token.DOT, ".", 1, 2),
+			tok(token.IDENT, "x", 1, 3),
+		}},
+	})
+
+	for _, input := range []string{"1.2.3", "1e", "1e+", "1.5L"} {
+		l := New(input)
+		got := lexAll(l)
+		if len(got) != 1 || got[0] != tok(token.ILLEGAL, input, 1, 1) {
+			t.Errorf("%q lexed to %v, want one ILLEGAL token", input, got)
+		}
+		want := "malformed number '" + input + "' on line 1:1"
+		if len(l.Errors) != 1 || l.Errors[0] != want {
+			t.Errorf("%q errors = %q, want [%q]", input, l.Errors, want)
+		}
+	}
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	runLexCases(t, []lexCase{
+		{"operators", "a += 1 b -= 2 c *= 3 d /= 4", []token.Token{
+			tok(token.IDENT, "a", 1, 1),
+			tok(token.PLUS_ASSIGN, "+=", 1, 3),
+			tok(token.INT, "1", 1, 6),
+			tok(token.IDENT, "b", 1, 8),
+			tok(token.MINUS_ASSIGN, "-=", 1, 10),
+			tok(token.INT, "2", 1, 13),
+			tok(token.IDENT, "c", 1, 15),
+			tok(token.ASTERISK_ASSIGN, "*=", 1, 17),
+			tok(token.INT, "3", 1, 20),
+			tok(token.IDENT, "d", 1, 22),
+			tok(token.SLASH_ASSIGN, "/=", 1, 24),
+			tok(token.INT, "4", 1, 27),
+		}},
+		{"before a comment", "x/=2// halve", []token.Token{
+			tok(token.IDENT, "x", 1, 1),
+			tok(token.SLASH_ASSIGN, "/=", 1, 2),
+			tok(token.INT, "2", 1, 4),
+		}},
+	})
+}
+
+func TestRawStrings(t *testing.T) {
+	raw := func(literal string, line, col int) token.Token {
+		t := tok(token.STRING, literal, line, col)
+		t.Raw = true
+		return t
+	}
+	runLexCases(t, []lexCase{
+		{"no escapes", "`a\\nb`", []token.Token{raw(`a\nb`, 1, 1)}},
+		{"quotes", "`say \"hi\"`", []token.Token{raw(`say "hi"`, 1, 1)}},
+		{"dedented lines", "`\n    one\n      two\n    ` x", []token.Token{
+			raw("one\n  two", 1, 1),
+			tok(token.IDENT, "x", 4, 7),
+		}},
+	})
+}
+
+func TestDots(t *testing.T) {
+	runLexCases(t, []lexCase{
+		{"field", "u.name", []token.Token{
+			tok(token.IDENT, "u", 1, 1),
+			tok(token.DOT, ".", 1, 2),
+			tok(token.IDENT, "name", 1, 3),
+		}},
+		{"range", "0..10", []token.Token{
+			tok(token.INT, "0", 1, 1),
+			tok(token.RANGE, "..", 1, 2),
+			tok(token.INT, "10", 1, 4),
+		}},
+		{"spread", "...xs", []token.Token{
+			tok(token.ELLIPSIS, "...", 1, 1),
+			tok(token.IDENT, "xs", 1, 4),
+		}},
+		{"float method", "1.5.x", []token.Token{
+			tok(token.FLOAT, "1.5", 1, 1),
+			tok(token.DOT, ".", 1, 4),
+			tok(token.IDENT, "x", 1, 5),
+		}},
+	})
+}
+
+func TestLogicalOperators(t *testing.T) {
+	runLexCases(t, []lexCase{
+		{"bang", "!ok", []token.Token{
+			tok(token.NOT, "!", 1, 1),
+			tok(token.IDENT, "ok", 1, 2),
+		}},
+		{"bang before a paren", "!(a)", []token.Token{
+			tok(token.NOT, "!", 1, 1),
+			tok(token.LPAREN, "(", 1, 2),
+			tok(token.IDENT, "a", 1, 3),
+			tok(token.RPAREN, ")", 1, 4),
+		}},
+		{"words", "not a and b or c", []token.Token{
+			tok(token.NOT, "not", 1, 1),
+			tok(token.IDENT, "a", 1, 5),
+			tok(token.AND, "and", 1, 7),
+			tok(token.IDENT, "b", 1, 11),
+			tok(token.OR, "or", 1, 13),
+			tok(token.IDENT, "c", 1, 16),
+		}},
+		{"word prefixes stay identifiers", "note android order", []token.Token{
+			tok(token.IDENT, "note", 1, 1),
+			tok(token.IDENT, "android", 1, 6),
+			tok(token.IDENT, "order", 1, 14),
+		}},
+	})
+}
+
+func TestLineEndings(t *testing.T) {
+	runLexCases(t, []lexCase{
+		{"CRLF", "a\r\nb\r\n", []token.Token{
+			tok(token.IDENT, "a", 1, 1),
+			tok(token.IDENT, "b", 2, 1),
+		}},
+		{"byte order mark", "\uFEFFpackage main", []token.Token{
+			tok(token.PACKAGE, "package", 1, 1),
+			tok(token.IDENT, "main", 1, 9),
+		}},
+		{"byte order mark and CRLF", "\uFEFFx\r\n  y", []token.Token{
+			tok(token.IDENT, "x", 1, 1),
+			tok(token.IDENT, "y", 2, 3),
+		}},
+	})
+}
+
+func TestUnicode(t *testing.T) {
+	runLexCases(t, []lexCase{
+		{"identifiers", "let café >> 名前", []token.Token{
+			tok(token.LET, "let", 1, 1),
+			tok(token.IDENT, "café", 1, 5),
+			tok(token.ASSIGN_OP, ">>", 1, 10),
+			tok(token.IDENT, "名前", 1, 13),
+		}},
+		{"digits after the first letter", "x١ y", []token.Token{
+			tok(token.IDENT, "x١", 1, 1),
+			tok(token.IDENT, "y", 1, 4),
+		}},
+		{"string content", `"日本語" x`, []token.Token{
+			tok(token.STRING, "日本語", 1, 1),
+			tok(token.IDENT, "x", 1, 7),
+		}},
+	})
+
+	l := New("a → b")
+	got := lexAll(l)
+	want := []token.Token{
+		tok(token.IDENT, "a", 1, 1),
+		tok(token.ILLEGAL, "→", 1, 3),
+		tok(token.IDENT, "b", 1, 5),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokens = %v, want %v", got, want)
+	}
+	if len(l.Errors) != 1 || l.Errors[0] != "illegal character '→' on line 1:3" {
+		t.Errorf("errors = %q, want one illegal character error", l.Errors)
+	}
+}
@@ -0,0 +1,100 @@
+package optimizer
+
+import (
+	"strings"
+
+	"github.com/notrealandy/tox/ast"
+	"github.com/notrealandy/tox/evaluator"
+)
+
+// Optimize rewrites a typechecked program before evaluation. Calls to @pure
+// functions whose arguments are all constants are evaluated once, at compile
+// time, and replaced by their result. Calls that fail at compile time, or
+// take more than foldSteps steps or nest calls more than foldDepth deep, are
+// left alone so they are run (and any error reported) when they are reached.
+func Optimize(stmts []ast.Statement) []ast.Statement {
+	pure := map[string]bool{}
+	var fns []ast.Statement
+	for _, s := range stmts {
+		if fn, ok := s.(*ast.FunctionStatement); ok {
+			fns = append(fns, fn)
+			if fn.Pure && fn.ReceiverType == "" {
+				pure[fn.Name] = true
+			}
+		}
+	}
+	if len(pure) == 0 {
+		return stmts
+	}
+
+	// Only declare the functions: top-level lets may have side effects. A call
+	// that reads one fails on the undeclared name and is left alone.
+	env := evaluator.NewEnvironment()
+	evaluator.Eval(fns, env)
+
+	ast.Rewrite(stmts, func(expr ast.Expression) ast.Expression {
+		call, ok := expr.(*ast.CallExpression)
+		if !ok {
+			return expr
+		}
+		ident, ok := call.Function.(*ast.Identifier)
+		if !ok || !pure[ident.Value] {
+			return expr
+		}
+		args := make([]interface{}, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			val, ok := constantValue(arg)
+			if !ok {
+				return expr
+			}
+			args[i] = val
+		}
+		result, err := evaluator.CallFunctionLimited(env, ident.Value, args, foldSteps, foldDepth)
+		if err != nil {
+			return expr
+		}
+		if lit, ok := literalFor(result); ok {
+			return lit
+		}
+		return expr
+	})
+	return stmts
+}
+
+// Limits on the work of a single folded call, so that compiling a program
+// never hangs or overflows the stack on a call that would at run time.
+const (
+	foldSteps = 1000000
+	foldDepth = 1000
+)
+
+// constantValue returns the runtime value of a literal expression.
+func constantValue(expr ast.Expression) (interface{}, bool) {
+	switch v := expr.(type) {
+	case *ast.IntegerLiteral:
+		return v.Value, true
+	case *ast.BoolLiteral:
+		return v.Value, true
+	case *ast.StringLiteral:
+		// Interpolated strings depend on the environment they're evaluated in
+		if !v.Raw && strings.Contains(v.Value, "<%") {
+			return nil, false
+		}
+		return v.Value, true
+	}
+	return nil, false
+}
+
+// literalFor turns a folded result back into a literal expression.
+func literalFor(val interface{}) (ast.Expression, bool) {
+	switch v := val.(type) {
+	case int64:
+		return &ast.IntegerLiteral{Value: v}, true
+	case bool:
+		return &ast.BoolLiteral{Value: v}, true
+	case string:
+		// Raw, so a folded "<%x%>" isn't interpolated a second time
+		return &ast.StringLiteral{Value: v, Raw: true}, true
+	}
+	return nil, false
+}
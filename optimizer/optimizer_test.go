@@ -0,0 +1,85 @@
+package optimizer
+
+import (
+	"testing"
+
+	"github.com/notrealandy/tox/ast"
+	"github.com/notrealandy/tox/lexer"
+	"github.com/notrealandy/tox/parser"
+)
+
+const pureFuncs = `
+@pure
+fnc double(n int) >> int {
+    return n * 2
+}
+@pure
+fnc spin(n int) >> int {
+    let i int >> n
+    while i >= 0 {
+        i += 1
+    }
+    return i
+}
+@pure
+fnc down(n int) >> int {
+    if n == 0 {
+        return 0
+    }
+    return down(n - 1) + 1
+}
+`
+
+func TestOptimizeFoldsPureCalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		call   string
+		folded bool
+		value  int64
+	}{
+		{"constant arguments", "double(21)", true, 42},
+		{"variable argument", "double(x)", false, 0},
+		{"endless loop", "spin(0)", false, 0},
+		{"shallow recursion", "down(10)", true, 10},
+		{"deep recursion", "down(100000)", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := pureFuncs + "let x int >> 1\nlet y int >> " + tt.call + "\n"
+			p := parser.New(lexer.New(src))
+			stmts := p.ParseProgram()
+			if len(p.Errors) > 0 {
+				t.Fatalf("parse errors: %v", p.Errors)
+			}
+			stmts = Optimize(stmts)
+			let := stmts[len(stmts)-1].(*ast.LetStatement)
+			lit, ok := let.Value.(*ast.IntegerLiteral)
+			if ok != tt.folded {
+				t.Fatalf("%s folded = %v, want %v (value %#v)", tt.call, ok, tt.folded, let.Value)
+			}
+			if ok && lit.Value != tt.value {
+				t.Errorf("%s folded to %d, want %d", tt.call, lit.Value, tt.value)
+			}
+		})
+	}
+}
+
+func TestOptimizeSkipsGlobalReads(t *testing.T) {
+	src := `let greeting string >> "hi"
+@pure
+fnc greet(n int) >> string {
+    return greeting
+}
+let y string >> greet(1)
+`
+	p := parser.New(lexer.New(src))
+	stmts := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors)
+	}
+	stmts = Optimize(stmts)
+	let := stmts[len(stmts)-1].(*ast.LetStatement)
+	if _, ok := let.Value.(*ast.CallExpression); !ok {
+		t.Errorf("greet(1) reads a top-level let and must not be folded, got %#v", let.Value)
+	}
+}
@@ -15,6 +15,9 @@ type Parser struct {
 	curToken  token.Token
 	peekToken token.Token
 	Errors    []string
+	// Set while parsing the header of an if, elif, while, for, with or match,
+	// where '{' opens the body rather than a struct literal.
+	noStructLiteral bool
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -36,28 +39,22 @@ func (p *Parser) ParseProgram() []ast.Statement {
 	var statements []ast.Statement
 
 	for p.curToken.Type != token.EOF {
-		// Check for optional pub modifier for functions or let statements
+		// Annotations such as @pure apply to the declaration that follows
+		if p.curToken.Type == token.AT {
+			if stmt := p.parseAnnotated(); stmt != nil {
+				statements = append(statements, stmt)
+			}
+			continue
+		}
+		// Check for optional pub modifier for functions, let statements and structs
 		if p.curToken.Type == token.PUB {
-			vis := "pub"
-			p.nextToken() // consume 'pub'
-			if p.curToken.Type == token.FNC {
-				fn := p.parseFunctionStatement()
-				fn.Visibility = vis
-				statements = append(statements, fn)
-				continue
-			} else if p.curToken.Type == token.LET {
-				letStmt := p.parseLetStatement()
-				letStmt.Visibility = vis
-				statements = append(statements, letStmt)
-				continue
-			} else {
-				p.Errors = append(p.Errors, fmt.Sprintf("unexpected token '%s' after pub on line %d:%d", p.curToken.Literal, p.curToken.Line, p.curToken.Col))
-				p.nextToken()
-				continue
+			if stmt := p.parsePubDeclaration(); stmt != nil {
+				statements = append(statements, stmt)
 			}
+			continue
 		}
 		var stmt ast.Statement
-		if p.curToken.Type == token.LET {
+		if p.curToken.Type == token.LET || p.curToken.Type == token.CONST {
 			stmt = p.parseLetStatement()
 		} else if p.curToken.Type == token.FNC {
 			stmt = p.parseFunctionStatement()
@@ -65,12 +62,18 @@ func (p *Parser) ParseProgram() []ast.Statement {
 			stmt = p.parseLogFunctionStatement()
 		} else if p.curToken.Type == token.RETURN {
 			stmt = p.parseReturnStatement()
+		} else if p.curToken.Type == token.ASSERT {
+			stmt = p.parseAssertStatement()
 		} else if p.curToken.Type == token.IF {
 			stmt = p.parseIfStatement()
-		} else if p.curToken.Type == token.IDENT && (p.peekToken.Type == token.ASSIGN_OP || p.peekToken.Type == token.LBRACKET) {
+		} else if p.curToken.Type == token.MATCH {
+			stmt = p.parseMatchStatement()
+		} else if p.curToken.Type == token.IDENT && (isAssignOp(p.peekToken.Type) || p.peekToken.Type == token.LBRACKET) {
 			stmt = p.parseAssignmentStatement()
 		} else if p.curToken.Type == token.WHILE {
 			stmt = p.parseWhileStatement()
+		} else if p.curToken.Type == token.WITH {
+			stmt = p.parseWithStatement()
 		} else if p.curToken.Type == token.FOR {
 			stmt = p.parseForStatement()
 		} else if p.curToken.Type == token.PACKAGE {
@@ -87,8 +90,17 @@ func (p *Parser) ParseProgram() []ast.Statement {
 				statements = append(statements, stmt)
 			}
 			continue
+		} else if p.curToken.Type == token.TYPEDEF {
+			stmt := p.parseTypeAliasStatement()
+			if stmt != nil {
+				statements = append(statements, stmt)
+			}
+			continue
 		} else {
-			p.Errors = append(p.Errors, fmt.Sprintf("[PARSE PROGRAM] unexpected token '%s' on line %d:%d", p.curToken.Literal, p.curToken.Line, p.curToken.Col))
+			// ILLEGAL tokens were already reported by the lexer
+			if p.curToken.Type != token.ILLEGAL {
+				p.Errors = append(p.Errors, fmt.Sprintf("[PARSE PROGRAM] unexpected token '%s' on line %d:%d", p.curToken.Literal, p.curToken.Line, p.curToken.Col))
+			}
 			p.nextToken()
 			continue
 		}
@@ -99,14 +111,23 @@ func (p *Parser) ParseProgram() []ast.Statement {
 
 	}
 
+	// Lexer errors come first: parse errors usually cascade from them.
+	if len(p.l.Errors) > 0 {
+		p.Errors = append(append([]string{}, p.l.Errors...), p.Errors...)
+	}
+
 	return statements
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
-	if p.curToken.Type != token.LET {
+	if p.curToken.Type != token.LET && p.curToken.Type != token.CONST {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected 'let' on line %d:%d", p.curToken.Line, p.curToken.Col))
 		return nil
 	}
+	// const NAME type >> value parses like a let
+	isConst := p.curToken.Type == token.CONST
+	// The statement is positioned at 'let', not at whatever follows its value
+	line, col := p.curToken.Line, p.curToken.Col
 	p.nextToken()
 
 	if p.curToken.Type != token.IDENT {
@@ -141,8 +162,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 				return nil
 			}
 			p.nextToken()
-			valueType := p.curToken.Literal
-			p.nextToken()
+			valueType, ok := p.parseType()
+			if !ok {
+				return nil
+			}
 			typ := fmt.Sprintf("map[%s]%s", keyType, valueType)
 
 			// Parse map literal
@@ -155,18 +178,17 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 				Name:  name,
 				Type:  typ,
 				Value: value,
-				Line:  p.curToken.Line,
-				Col:   p.curToken.Col,
+				Const: isConst,
+				Line:  line,
+				Col:   col,
 			}
 		}
 	}
 
-	if p.curToken.Type != token.TYPE && p.curToken.Type != token.IDENT {
-		p.Errors = append(p.Errors, fmt.Sprintf("expected type on line %d:%d", p.curToken.Line, p.curToken.Col))
+	typ, ok := p.parseType()
+	if !ok {
 		return nil
 	}
-	typ := p.curToken.Literal
-	p.nextToken()
 
 	if p.curToken.Type != token.ASSIGN_OP {
 		p.Errors = append(p.Errors, fmt.Sprintf("[PARSE LET STATEMENT] expected assignment operator '>>' on line %d:%d", p.curToken.Line, p.curToken.Col))
@@ -187,11 +209,137 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 		Name:  name,
 		Type:  typ,
 		Value: value,
-		Line:  p.curToken.Line,
-		Col:   p.curToken.Col,
+		Const: isConst,
+		Line:  line,
+		Col:   col,
 	}
 }
 
+// parseType parses a type annotation: a built-in or struct type (int, User),
+// fnc for closures, an array of one (int[], User[]), a map type (map[string]int) or an array of
+// maps ((map[string]int)[]). The parentheses are needed because map[string]int[]
+// is a map of int arrays. Function types (fnc(int) >> bool) are parsed by
+// parseFunctionType.
+func (p *Parser) parseType() (string, bool) {
+	if p.curToken.Type == token.FNC && p.peekToken.Type == token.LPAREN {
+		return p.parseFunctionType()
+	}
+	if p.curToken.Type == token.TYPE && p.curToken.Literal == "map" && p.peekToken.Type == token.LBRACKET {
+		keyType, valueType, ok := p.parseMapType()
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("map[%s]%s", keyType, valueType), true
+	}
+	if p.curToken.Type == token.LPAREN {
+		p.nextToken() // skip '('
+		inner, ok := p.parseType()
+		if !ok {
+			return "", false
+		}
+		if p.curToken.Type != token.RPAREN {
+			p.Errors = append(p.Errors, fmt.Sprintf("expected ')' after type on line %d:%d", p.curToken.Line, p.curToken.Col))
+			return "", false
+		}
+		p.nextToken() // skip ')'
+		if p.curToken.Type != token.LBRACKET || p.peekToken.Type != token.RBRACKET {
+			p.Errors = append(p.Errors, fmt.Sprintf("expected '[]' after parenthesized type on line %d:%d", p.curToken.Line, p.curToken.Col))
+			return "", false
+		}
+		if strings.HasPrefix(inner, "map[") || strings.HasPrefix(inner, "fnc(") {
+			inner = "(" + inner + ")"
+		}
+		return p.parseArraySuffix(inner), true
+	}
+	if p.curToken.Type != token.TYPE && p.curToken.Type != token.IDENT && p.curToken.Type != token.FNC {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected type on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return "", false
+	}
+	typ := p.curToken.Literal
+	p.nextToken()
+	// Imported struct types are qualified by their module, e.g. geo.Point
+	for p.curToken.Type == token.DOT && p.peekToken.Type == token.IDENT {
+		p.nextToken()
+		typ += "." + p.curToken.Literal
+		p.nextToken()
+	}
+	return p.parseArraySuffix(typ), true
+}
+
+// parseFunctionType parses the type of a function taking and returning values
+// of the given types, fnc(int, string) >> bool, as "fnc(int,string)>>bool".
+// A variadic last parameter keeps its dots: fnc(int...) >> int.
+// Without a return type it returns void. The return type is taken greedily,
+// so a let of a void function type has to spell it out:
+// let cb fnc(string) >> void >> ...
+func (p *Parser) parseFunctionType() (string, bool) {
+	p.nextToken() // skip 'fnc'
+	p.nextToken() // skip '('
+	params := []string{}
+	for p.curToken.Type != token.RPAREN {
+		param, ok := p.parseType()
+		if !ok {
+			return "", false
+		}
+		if p.curToken.Type == token.ELLIPSIS {
+			p.nextToken()
+			if p.curToken.Type != token.RPAREN {
+				p.Errors = append(p.Errors, fmt.Sprintf("variadic parameter must be the last one in function type on line %d:%d", p.curToken.Line, p.curToken.Col))
+				return "", false
+			}
+			param += "..."
+		}
+		params = append(params, param)
+		if p.curToken.Type == token.COMMA {
+			p.nextToken()
+		} else if p.curToken.Type != token.RPAREN {
+			p.Errors = append(p.Errors, fmt.Sprintf("expected ',' or ')' in function type on line %d:%d", p.curToken.Line, p.curToken.Col))
+			return "", false
+		}
+	}
+	p.nextToken() // skip ')'
+	ret := "void"
+	if p.curToken.Type == token.ASSIGN_OP {
+		p.nextToken() // skip '>>'
+		var ok bool
+		if ret, ok = p.parseType(); !ok {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("fnc(%s)>>%s", strings.Join(params, ","), ret), true
+}
+
+// parseArraySuffix appends a [] to typ for each [] that follows it, so int[]
+// is lexed as int [ ] and read back as one type.
+func (p *Parser) parseArraySuffix(typ string) string {
+	for p.curToken.Type == token.LBRACKET && p.peekToken.Type == token.RBRACKET {
+		p.nextToken() // skip '['
+		p.nextToken() // skip ']'
+		typ += "[]"
+	}
+	return typ
+}
+
+// parseMapType parses map[K]V, returning its key and value types.
+func (p *Parser) parseMapType() (string, string, bool) {
+	p.nextToken() // skip 'map'
+	p.nextToken() // skip '['
+	keyType, ok := p.parseType()
+	if !ok {
+		return "", "", false
+	}
+	if p.curToken.Type != token.RBRACKET {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected ']' after map key type on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return "", "", false
+	}
+	p.nextToken() // skip ']'
+	valueType, ok := p.parseType()
+	if !ok {
+		return "", "", false
+	}
+	return keyType, valueType, true
+}
+
 func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 	// Assume current token is FNC
 	fn := &ast.FunctionStatement{Line: p.curToken.Line, Col: p.curToken.Col}
@@ -217,6 +365,12 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 	}
 
 	p.nextToken() // move to (
+	return p.parseFunctionRest(fn)
+}
+
+// parseFunctionRest parses the parameters, return type and body of a function
+// statement or literal, starting at its '('.
+func (p *Parser) parseFunctionRest(fn *ast.FunctionStatement) *ast.FunctionStatement {
 	if p.curToken.Type != token.LPAREN {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected '(' after function name on line %d:%d", p.curToken.Line, p.curToken.Col))
 		return nil
@@ -224,6 +378,7 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 
 	params := []string{}
 	paramTypes := []string{}
+	var defaults []ast.Expression
 	p.nextToken() // move to first param or ')'
 	for p.curToken.Type != token.RPAREN && p.curToken.Type != token.EOF {
 		if p.curToken.Type == token.IDENT {
@@ -231,13 +386,47 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 			params = append(params, paramName)
 			p.nextToken() // move to type
 
-			if p.curToken.Type != token.TYPE {
+			// Built-in types come as TYPE, user-defined (struct) types as IDENT
+			if p.curToken.Type != token.TYPE && p.curToken.Type != token.IDENT && p.curToken.Type != token.FNC && p.curToken.Type != token.LPAREN {
 				p.Errors = append(p.Errors, fmt.Sprintf("expected type after parameter '%s' on line %d:%d", paramName, p.curToken.Line, p.curToken.Col))
 				return nil
 			}
 
-			paramTypes = append(paramTypes, p.curToken.Literal)
-			p.nextToken()
+			paramType, ok := p.parseType()
+			if !ok {
+				return nil
+			}
+			// nums int... collects the remaining arguments into an int[]
+			if p.curToken.Type == token.ELLIPSIS {
+				p.nextToken()
+				if p.curToken.Type != token.RPAREN {
+					p.Errors = append(p.Errors, fmt.Sprintf("variadic parameter '%s' must be the last parameter on line %d:%d", paramName, p.curToken.Line, p.curToken.Col))
+					return nil
+				}
+				if strings.HasPrefix(paramType, "map[") || strings.HasPrefix(paramType, "fnc(") {
+					paramType = "(" + paramType + ")"
+				}
+				paramTypes = append(paramTypes, paramType+"[]")
+				fn.Variadic = true
+				break
+			}
+			paramTypes = append(paramTypes, paramType)
+			// Optional default: greeting string >> "Hello". Once a parameter
+			// has one, all those after it need one too.
+			if p.curToken.Type == token.ASSIGN_OP {
+				p.nextToken()
+				value := p.parseExpression()
+				if value == nil {
+					return nil
+				}
+				if defaults == nil {
+					defaults = make([]ast.Expression, len(params)-1)
+				}
+				defaults = append(defaults, value)
+			} else if defaults != nil {
+				p.Errors = append(p.Errors, fmt.Sprintf("parameter '%s' needs a default value, as the parameters before it have one, on line %d:%d", paramName, p.curToken.Line, p.curToken.Col))
+				return nil
+			}
 			if p.curToken.Type == token.COMMA {
 				p.nextToken() // skip comma and continue to next param
 			}
@@ -253,21 +442,31 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 	}
 	fn.Params = params
 	fn.ParamTypes = paramTypes
+	fn.Defaults = defaults
 
-	p.nextToken() // move to >>
+	p.nextToken() // move to >> or {
+	if p.curToken.Type == token.LBRACE {
+		// No return type: fnc f() { ... } is shorthand for fnc f() >> void { ... }
+		fn.ReturnType = "void"
+		fn.Body = p.parseBlock()
+		return fn
+	}
 	if p.curToken.Type != token.ASSIGN_OP {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected '>>' after ')' on line %d:%d", p.curToken.Line, p.curToken.Col))
 		return nil
 	}
 
-	p.nextToken() // move to return type (e.g. string, int, bool, void)
-	if p.curToken.Type != token.TYPE && p.curToken.Type != token.IDENT && p.curToken.Type != token.FNCVOID {
+	p.nextToken() // move to return type (e.g. string, int, bool, void); void is lexed as a TYPE
+	if p.curToken.Type != token.TYPE && p.curToken.Type != token.IDENT && p.curToken.Type != token.LPAREN && p.curToken.Type != token.FNC {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected return type after '>>' on line %d:%d", p.curToken.Line, p.curToken.Col))
 		return nil
 	}
-	fn.ReturnType = p.curToken.Literal
+	returnType, ok := p.parseType()
+	if !ok {
+		return nil
+	}
+	fn.ReturnType = returnType
 
-	p.nextToken() // move to {
 	if p.curToken.Type != token.LBRACE {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected '{' after return type on line %d:%d", p.curToken.Line, p.curToken.Col))
 		return nil
@@ -279,6 +478,180 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 	return fn
 }
 
+// parsePubDeclaration parses `pub fnc ...`, `pub let ...`, `pub const ...` or `pub struct ...`.
+func (p *Parser) parsePubDeclaration() ast.Statement {
+	vis := "pub"
+	p.nextToken() // consume 'pub'
+	switch p.curToken.Type {
+	case token.FNC:
+		if fn := p.parseFunctionStatement(); fn != nil {
+			fn.Visibility = vis
+			return fn
+		}
+	case token.LET, token.CONST:
+		if letStmt := p.parseLetStatement(); letStmt != nil {
+			letStmt.Visibility = vis
+			return letStmt
+		}
+	case token.STRUCT:
+		if structStmt := p.parseStructStatement(); structStmt != nil {
+			structStmt.Visibility = vis
+			return structStmt
+		}
+	case token.TYPEDEF:
+		if aliasStmt := p.parseTypeAliasStatement(); aliasStmt != nil {
+			aliasStmt.Visibility = vis
+			return aliasStmt
+		}
+	default:
+		p.Errors = append(p.Errors, fmt.Sprintf("unexpected token '%s' after pub on line %d:%d", p.curToken.Literal, p.curToken.Line, p.curToken.Col))
+		p.nextToken()
+	}
+	return nil
+}
+
+// parseAnnotated parses a top-level declaration preceded by an annotation:
+// `@pure [pub] fnc ...` or `@when(os == "linux") <declaration>`.
+func (p *Parser) parseAnnotated() ast.Statement {
+	line, col := p.curToken.Line, p.curToken.Col
+	p.nextToken() // skip '@'
+	if p.curToken.Type == token.IDENT && p.curToken.Literal == "pure" {
+		p.nextToken() // skip annotation name
+		if fn := p.parsePureFunction(); fn != nil {
+			return fn
+		}
+		return nil
+	}
+	if p.curToken.Type == token.IDENT && p.curToken.Literal == "override" {
+		p.nextToken() // skip annotation name
+		if fn := p.parseOverride(line, col); fn != nil {
+			return fn
+		}
+		return nil
+	}
+	if p.curToken.Type == token.IDENT && p.curToken.Literal == "when" {
+		p.nextToken() // skip annotation name
+		if when := p.parseWhen(line, col); when != nil {
+			return when
+		}
+		return nil
+	}
+	p.Errors = append(p.Errors, fmt.Sprintf("unknown annotation '@%s' on line %d:%d", p.curToken.Literal, line, col))
+	p.nextToken()
+	return nil
+}
+
+// parseWhen parses the `(os == "linux")` condition of @when and the
+// declaration it guards.
+func (p *Parser) parseWhen(line, col int) *ast.WhenStatement {
+	if p.curToken.Type != token.LPAREN {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected '(' after '@when' on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	p.nextToken()
+	if p.curToken.Type != token.IDENT || (p.curToken.Literal != "os" && p.curToken.Literal != "arch") {
+		p.Errors = append(p.Errors, fmt.Sprintf("unknown build condition '%s', expected os or arch on line %d:%d", p.curToken.Literal, p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	when := &ast.WhenStatement{Key: p.curToken.Literal, Line: line, Col: col}
+	p.nextToken()
+	if p.curToken.Type != token.EQ {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected '==' in build condition on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	p.nextToken()
+	if p.curToken.Type != token.STRING {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected string in build condition on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	when.Value = p.curToken.Literal
+	p.nextToken()
+	if p.curToken.Type != token.RPAREN {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected ')' after build condition on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	p.nextToken()
+
+	var decl ast.Statement
+	switch p.curToken.Type {
+	case token.AT:
+		decl = p.parseAnnotated()
+	case token.PUB:
+		decl = p.parsePubDeclaration()
+	case token.FNC:
+		if fn := p.parseFunctionStatement(); fn != nil {
+			decl = fn
+		}
+	case token.LET, token.CONST:
+		if letStmt := p.parseLetStatement(); letStmt != nil {
+			decl = letStmt
+		}
+	case token.STRUCT:
+		if structStmt := p.parseStructStatement(); structStmt != nil {
+			decl = structStmt
+		}
+	case token.TYPEDEF:
+		if aliasStmt := p.parseTypeAliasStatement(); aliasStmt != nil {
+			decl = aliasStmt
+		}
+	default:
+		p.Errors = append(p.Errors, fmt.Sprintf("expected declaration after '@when' on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	if decl == nil {
+		return nil
+	}
+	when.Decl = decl
+	return when
+}
+
+// parsePureFunction parses the `[pub] fnc ...` following @pure.
+func (p *Parser) parsePureFunction() *ast.FunctionStatement {
+	vis := ""
+	if p.curToken.Type == token.PUB {
+		vis = "pub"
+		p.nextToken()
+	}
+	if p.curToken.Type != token.FNC {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected function declaration after '@pure' on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	fn := p.parseFunctionStatement()
+	if fn == nil {
+		return nil
+	}
+	fn.Visibility = vis
+	fn.Pure = true
+	return fn
+}
+
+// parseOverride parses the function after @override. It is named after the
+// builtin it replaces, e.g. @override fnc go.println(msg string) { ... }
+func (p *Parser) parseOverride(line, col int) *ast.FunctionStatement {
+	if p.curToken.Type != token.FNC {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected function declaration after '@override' on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	p.nextToken() // skip 'fnc'
+	fn := &ast.FunctionStatement{Override: true, Line: line, Col: col}
+	for {
+		// Segments such as map in go.map.merge or match in go.regex.match
+		// are lexed as keywords
+		if p.curToken.Type != token.IDENT && !lexer.IsKeyword(p.curToken) {
+			p.Errors = append(p.Errors, fmt.Sprintf("expected builtin name after '@override fnc' on line %d:%d", p.curToken.Line, p.curToken.Col))
+			return nil
+		}
+		fn.Name += p.curToken.Literal
+		p.nextToken()
+		if p.curToken.Type != token.DOT {
+			break
+		}
+		fn.Name += "."
+		p.nextToken()
+	}
+	return p.parseFunctionRest(fn)
+}
+
 func (p *Parser) parseLogFunctionStatement() *ast.LogFunction {
 	lg := &ast.LogFunction{Line: p.curToken.Line, Col: p.curToken.Col}
 
@@ -301,8 +674,36 @@ func (p *Parser) parseLogFunctionStatement() *ast.LogFunction {
 	return lg
 }
 
+// parseExpression parses a full expression. Newlines are plain whitespace, so an
+// expression keeps going for as long as the next token can extend it: a binary
+// operator may end one line or start the next, and call arguments, array
+// elements and parenthesized expressions may span any number of lines.
 func (p *Parser) parseExpression() ast.Expression {
-	return p.parseUnary()
+	return p.parseTernary()
+}
+
+// parseTernary parses cond ? a : b. It binds loosest of all operators and
+// nests to the right: a ? b : c ? d : e is a ? b : (c ? d : e).
+func (p *Parser) parseTernary() ast.Expression {
+	cond := p.parseLogical()
+	if p.curToken.Type != token.QUESTION {
+		return cond
+	}
+	line, col := p.curToken.Line, p.curToken.Col
+	p.nextToken() // skip '?'
+	then := p.parseTernary()
+	if p.curToken.Type != token.COLON {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected ':' in conditional expression on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	p.nextToken() // skip ':'
+	return &ast.TernaryExpression{
+		Cond: cond,
+		Then: then,
+		Else: p.parseTernary(),
+		Line: line,
+		Col:  col,
+	}
 }
 
 // parseAdditive parses left-associative chains of + and -
@@ -325,12 +726,12 @@ func (p *Parser) parseAdditive() ast.Expression {
 }
 
 func (p *Parser) parseMultiplicitave() ast.Expression {
-	left := p.parsePrimary()
+	left := p.parseUnary()
 	for p.curToken.Type == token.SLASH || p.curToken.Type == token.ASTERISK || p.curToken.Type == token.MODULUS {
 		op := p.curToken.Type
 		line, col := p.curToken.Line, p.curToken.Col
 		p.nextToken()
-		right := p.parsePrimary()
+		right := p.parseUnary()
 		left = &ast.BinaryExpression{
 			Left:     left,
 			Operator: op,
@@ -346,7 +747,7 @@ func (p *Parser) parseMultiplicitave() ast.Expression {
 func (p *Parser) parsePrimary() ast.Expression {
 	switch p.curToken.Type {
 	case token.STRING:
-		lit := &ast.StringLiteral{Value: p.curToken.Literal}
+		lit := &ast.StringLiteral{Value: p.curToken.Literal, Raw: p.curToken.Raw, Line: p.curToken.Line, Col: p.curToken.Col}
 		p.nextToken()
 		return lit
 	case token.INT:
@@ -356,12 +757,27 @@ func (p *Parser) parsePrimary() ast.Expression {
 			p.nextToken()
 			return nil
 		}
-		lit := &ast.IntegerLiteral{Value: intVal}
+		lit := &ast.IntegerLiteral{Value: intVal, Line: p.curToken.Line, Col: p.curToken.Col}
+		p.nextToken()
+		return lit
+	case token.CHAR:
+		// 'A' is just another way to write the int 65
+		lit := &ast.IntegerLiteral{Value: int64([]rune(p.curToken.Literal)[0]), Line: p.curToken.Line, Col: p.curToken.Col}
+		p.nextToken()
+		return lit
+	case token.FLOAT:
+		floatVal, err := strconv.ParseFloat(p.curToken.Literal, 64)
+		if err != nil {
+			p.Errors = append(p.Errors, fmt.Sprintf("invalid float literal '%s' on line %d:%d", p.curToken.Literal, p.curToken.Line, p.curToken.Col))
+			p.nextToken()
+			return nil
+		}
+		lit := &ast.FloatLiteral{Value: floatVal, Line: p.curToken.Line, Col: p.curToken.Col}
 		p.nextToken()
 		return lit
 	case token.BOOL:
 		boolVal := p.curToken.Literal == "true"
-		lit := &ast.BoolLiteral{Value: boolVal}
+		lit := &ast.BoolLiteral{Value: boolVal, Line: p.curToken.Line, Col: p.curToken.Col}
 		p.nextToken()
 		return lit
 	case token.IDENT, token.LEN, token.INPUT:
@@ -372,7 +788,7 @@ func (p *Parser) parsePrimary() ast.Expression {
 		p.nextToken()
 
 		// If immediately a '{' follows, interpret as a struct literal.
-		if p.curToken.Type == token.LBRACE {
+		if p.curToken.Type == token.LBRACE && !p.noStructLiteral {
 			p.nextToken() // skip '{'
 			fields := make(map[string]ast.Expression)
 			for p.curToken.Type != token.RBRACE && p.curToken.Type != token.EOF {
@@ -412,7 +828,9 @@ func (p *Parser) parsePrimary() ast.Expression {
 		// Handle dot notation: App.run or App.foo.bar
 		for p.curToken.Type == token.DOT {
 			p.nextToken()
-			if p.curToken.Type != token.IDENT {
+			// Type names and keywords are valid segments too, e.g. go.map.merge
+			// or go.regex.match
+			if p.curToken.Type != token.IDENT && !lexer.IsKeyword(p.curToken) {
 				p.Errors = append(p.Errors, fmt.Sprintf("expected identifier after '.' on line %d:%d", p.curToken.Line, p.curToken.Col))
 				return nil
 			}
@@ -429,12 +847,12 @@ func (p *Parser) parsePrimary() ast.Expression {
 		// Support function calls: foo(), len(), input(), etc.
 		for p.curToken.Type == token.LPAREN {
 			p.nextToken()
-			args := []ast.Expression{}
+			call := &ast.CallExpression{Function: expr, Arguments: []ast.Expression{}, Line: identLine, Col: identCol}
 			if p.curToken.Type != token.RPAREN {
-				args = append(args, p.parseExpression())
+				p.parseCallArgument(call)
 				for p.curToken.Type == token.COMMA {
 					p.nextToken()
-					args = append(args, p.parseExpression())
+					p.parseCallArgument(call)
 				}
 			}
 			if p.curToken.Type != token.RPAREN {
@@ -442,72 +860,167 @@ func (p *Parser) parsePrimary() ast.Expression {
 				return nil
 			}
 			p.nextToken()
-			expr = &ast.CallExpression{Function: expr, Arguments: args}
+			expr = call
 		}
 		// Support arr[0] and chaining
 		for p.curToken.Type == token.LBRACKET {
+			bracketLine, bracketCol := p.curToken.Line, p.curToken.Col
 			p.nextToken()
-			var start, end ast.Expression
-			// xs[1:4], xs[:4], xs[1:], xs[:]
+			var start, end, step ast.Expression
+			// xs[1:4], xs[:4], xs[1:], xs[:], xs[0:10:2], xs[::-1]
 			if p.curToken.Type != token.COLON && p.curToken.Type != token.RBRACKET {
 				start = p.parseExpression()
 			}
 			if p.curToken.Type == token.COLON {
 				p.nextToken()
-				if p.curToken.Type != token.RBRACKET {
+				if p.curToken.Type != token.RBRACKET && p.curToken.Type != token.COLON {
 					end = p.parseExpression()
 				}
+				if p.curToken.Type == token.COLON {
+					p.nextToken()
+					if p.curToken.Type != token.RBRACKET {
+						step = p.parseExpression()
+					}
+				}
 				if p.curToken.Type != token.RBRACKET {
 					p.Errors = append(p.Errors, fmt.Sprintf("expected ']' after slice on line %d:%d", p.curToken.Line, p.curToken.Col))
 					return nil
 				}
 				p.nextToken()
-				expr = &ast.SliceExpression{Left: expr, Start: start, End: end}
+				expr = &ast.SliceExpression{Left: expr, Start: start, End: end, Step: step, Line: bracketLine, Col: bracketCol}
 			} else {
 				if p.curToken.Type != token.RBRACKET {
 					p.Errors = append(p.Errors, fmt.Sprintf("expected ']' after index on line %d:%d", p.curToken.Line, p.curToken.Col))
 					return nil
 				}
 				p.nextToken()
-				expr = &ast.IndexExpression{Left: expr, Index: start}
+				expr = &ast.IndexExpression{Left: expr, Index: start, Line: bracketLine, Col: bracketCol}
 			}
 		}
 		return expr
 	case token.LPAREN:
 		p.nextToken()
-		expr := p.parseExpression()
+		expr := p.parseNestedExpression()
 		if p.curToken.Type != token.RPAREN {
 			p.Errors = append(p.Errors, fmt.Sprintf("expected ')' after expression on line %d:%d", p.curToken.Line, p.curToken.Col))
 			return nil
 		}
 		p.nextToken()
 		return expr
+	case token.ILLEGAL:
+		// Already reported by the lexer
+		p.nextToken()
+		return nil
 	case token.NIL:
-		expr := &ast.NilLiteral{}
+		expr := &ast.NilLiteral{Line: p.curToken.Line, Col: p.curToken.Col}
 		p.nextToken()
 		return expr
+	case token.FNC:
+		// Function literal: fnc(x int) >> int { return x * 2 }
+		line, col := p.curToken.Line, p.curToken.Col
+		p.nextToken() // skip 'fnc'
+		fn := p.parseFunctionRest(&ast.FunctionStatement{Line: line, Col: col})
+		if fn == nil {
+			return nil
+		}
+		return &ast.FunctionLiteral{Fn: fn, Line: line, Col: col}
+	case token.TYPE:
+		// Typed map literal, e.g. [map[string]int{"a": 1}, map[string]int{"b": 2}]
+		if p.curToken.Literal != "map" || p.peekToken.Type != token.LBRACKET {
+			p.Errors = append(p.Errors, fmt.Sprintf("[PARSE PRIMARY] unexpected type '%s' in expression on line %d:%d", p.curToken.Literal, p.curToken.Line, p.curToken.Col))
+			p.nextToken()
+			return nil
+		}
+		keyType, valueType, ok := p.parseMapType()
+		if !ok {
+			return nil
+		}
+		if p.curToken.Type != token.LBRACE {
+			p.Errors = append(p.Errors, fmt.Sprintf("expected '{' for map literal on line %d:%d", p.curToken.Line, p.curToken.Col))
+			return nil
+		}
+		lit := p.parseMapLiteral(keyType, valueType)
+		if lit == nil {
+			return nil
+		}
+		return lit
 	case token.LBRACKET:
+		line, col := p.curToken.Line, p.curToken.Col
 		elements := []ast.Expression{}
 		p.nextToken()
+		// Comments and newlines between elements are skipped by the lexer, so
+		// literals may span lines: [1, // first
+		//                           2]
 		for p.curToken.Type != token.RBRACKET && p.curToken.Type != token.EOF {
-			elements = append(elements, p.parseExpression())
+			el := p.parseNestedExpression()
+			if el == nil {
+				return nil
+			}
+			elements = append(elements, el)
 			if p.curToken.Type == token.COMMA {
 				p.nextToken()
 			}
 		}
+		if p.curToken.Type != token.RBRACKET {
+			p.Errors = append(p.Errors, fmt.Sprintf("expected ']' at end of array literal on line %d:%d", p.curToken.Line, p.curToken.Col))
+			return nil
+		}
 		p.nextToken() // skip ']'
-		return &ast.ArrayLiteral{Elements: elements}
+		return &ast.ArrayLiteral{Elements: elements, Line: line, Col: col}
 	default:
 		p.Errors = append(p.Errors, fmt.Sprintf("[PARSE PRIMARY] unexpected token '%s' in expression on line %d:%d", p.curToken.Literal, p.curToken.Line, p.curToken.Col))
+		p.nextToken() // always make progress so callers looping over elements terminate
 		return nil
 	}
 }
 
+// parseHeaderExpression parses the expression in a statement header, before
+// the '{' of its body. An identifier followed by '{' ends the expression there
+// (if x > limit { ... }), so struct literals in a header need parentheses.
+func (p *Parser) parseHeaderExpression() ast.Expression {
+	saved := p.noStructLiteral
+	p.noStructLiteral = true
+	expr := p.parseExpression()
+	p.noStructLiteral = saved
+	return expr
+}
+
+// parseNestedExpression parses an expression enclosed in parentheses or
+// brackets, where struct literals are allowed again even inside a header.
+func (p *Parser) parseNestedExpression() ast.Expression {
+	saved := p.noStructLiteral
+	p.noStructLiteral = false
+	expr := p.parseExpression()
+	p.noStructLiteral = saved
+	return expr
+}
+
+// parseCallArgument parses one call argument, positional (x) or named (name: x),
+// and appends it to call. Named arguments must come after positional ones.
+func (p *Parser) parseCallArgument(call *ast.CallExpression) {
+	name := ""
+	if p.curToken.Type == token.IDENT && p.peekToken.Type == token.COLON {
+		name = p.curToken.Literal
+		p.nextToken() // skip name
+		p.nextToken() // skip ':'
+		if call.ArgNames == nil {
+			call.ArgNames = make([]string, len(call.Arguments))
+		}
+	} else if call.ArgNames != nil {
+		p.Errors = append(p.Errors, fmt.Sprintf("positional argument after named argument on line %d:%d", p.curToken.Line, p.curToken.Col))
+	}
+	call.Arguments = append(call.Arguments, p.parseNestedExpression())
+	if call.ArgNames != nil {
+		call.ArgNames = append(call.ArgNames, name)
+	}
+}
+
 func (p *Parser) parseComparison() ast.Expression {
 	left := p.parseAdditive()
 	for p.curToken.Type == token.EQ || p.curToken.Type == token.NEQ ||
 		p.curToken.Type == token.LT || p.curToken.Type == token.GT ||
-		p.curToken.Type == token.LTE || p.curToken.Type == token.GTE {
+		p.curToken.Type == token.LTE || p.curToken.Type == token.GTE ||
+		p.curToken.Type == token.IN {
 		op := p.curToken.Type
 		line, col := p.curToken.Line, p.curToken.Col
 		p.nextToken()
@@ -534,12 +1047,24 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	}
 }
 
+func (p *Parser) parseAssertStatement() *ast.AssertStatement {
+	as := &ast.AssertStatement{Line: p.curToken.Line, Col: p.curToken.Col}
+	p.nextToken() // move to condition
+	as.Cond = p.parseExpression()
+	// Optional message: assert cond, "message"
+	if p.curToken.Type == token.COMMA {
+		p.nextToken()
+		as.Message = p.parseExpression()
+	}
+	return as
+}
+
 func (p *Parser) parseIfStatement() *ast.IfStatement {
 	is := &ast.IfStatement{Line: p.curToken.Line, Col: p.curToken.Col}
 
 	p.nextToken() // move to condition
 	// Parse the condition expression until '{'
-	cond := p.parseExpression()
+	cond := p.parseHeaderExpression()
 	is.IfCond = cond
 
 	if p.curToken.Type != token.LBRACE {
@@ -550,12 +1075,15 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 	// Parse if body
 	ifBody := p.parseBlock()
 
-	// Parse elif blocks
+	// Parse elif blocks; else if is another way to write elif
 	var elifConds []ast.Expression
 	var elifBodies [][]ast.Statement
-	for p.curToken.Type == token.ELIF {
+	for p.curToken.Type == token.ELIF || (p.curToken.Type == token.ELSE && p.peekToken.Type == token.IF) {
+		if p.curToken.Type == token.ELSE {
+			p.nextToken() // skip 'else'
+		}
 		p.nextToken() // move to elif condition
-		elifCond := p.parseExpression()
+		elifCond := p.parseHeaderExpression()
 		elifConds = append(elifConds, elifCond)
 		if p.curToken.Type != token.LBRACE {
 			p.Errors = append(p.Errors, fmt.Sprintf("expected '{' after elif condition on line %d:%d", p.curToken.Line, p.curToken.Col))
@@ -574,6 +1102,27 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 			return is
 		}
 		elseBody = p.parseBlock()
+		// The else branch has to come last: branches after it are reported
+		// and skipped
+		for p.curToken.Type == token.ELIF || p.curToken.Type == token.ELSE {
+			if p.curToken.Type == token.ELIF {
+				p.Errors = append(p.Errors, fmt.Sprintf("'elif' after 'else' on line %d:%d", p.curToken.Line, p.curToken.Col))
+			} else {
+				p.Errors = append(p.Errors, fmt.Sprintf("duplicate 'else' on line %d:%d", p.curToken.Line, p.curToken.Col))
+			}
+			if p.curToken.Type == token.ELSE && p.peekToken.Type == token.IF {
+				p.nextToken() // skip 'else' of else if
+			}
+			hasCond := p.curToken.Type != token.ELSE
+			p.nextToken()
+			if hasCond {
+				p.parseHeaderExpression()
+			}
+			if p.curToken.Type != token.LBRACE {
+				return nil
+			}
+			p.parseBlock()
+		}
 	}
 
 	// Store bodies in the AST node (expand IfStatement struct as needed)
@@ -585,13 +1134,60 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 	return is
 }
 
+// parseMatchStatement parses match subject { case value >> { ... } default >> { ... } }.
+func (p *Parser) parseMatchStatement() *ast.MatchStatement {
+	ms := &ast.MatchStatement{Line: p.curToken.Line, Col: p.curToken.Col}
+	p.nextToken() // move to subject
+	ms.Subject = p.parseHeaderExpression()
+	if p.curToken.Type != token.LBRACE {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected '{' after match subject on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	p.nextToken() // skip '{'
+
+	for p.curToken.Type != token.RBRACE && p.curToken.Type != token.EOF {
+		isDefault := p.curToken.Type == token.DEFAULT
+		if !isDefault && p.curToken.Type != token.CASE {
+			p.Errors = append(p.Errors, fmt.Sprintf("expected 'case' or 'default' in match on line %d:%d", p.curToken.Line, p.curToken.Col))
+			return nil
+		}
+		if isDefault && ms.Default != nil {
+			p.Errors = append(p.Errors, fmt.Sprintf("duplicate default in match on line %d:%d", p.curToken.Line, p.curToken.Col))
+			return nil
+		}
+		p.nextToken() // skip 'case' or 'default'
+		var value ast.Expression
+		if !isDefault {
+			value = p.parseExpression()
+		}
+		if p.curToken.Type != token.ASSIGN_OP {
+			p.Errors = append(p.Errors, fmt.Sprintf("expected '>>' after case on line %d:%d", p.curToken.Line, p.curToken.Col))
+			return nil
+		}
+		p.nextToken()
+		if p.curToken.Type != token.LBRACE {
+			p.Errors = append(p.Errors, fmt.Sprintf("expected '{' after '>>' in match on line %d:%d", p.curToken.Line, p.curToken.Col))
+			return nil
+		}
+		body := p.parseBlock()
+		if isDefault {
+			ms.Default = body
+		} else {
+			ms.Cases = append(ms.Cases, value)
+			ms.Bodies = append(ms.Bodies, body)
+		}
+	}
+	p.nextToken() // skip '}'
+	return ms
+}
+
 func (p *Parser) parseBlock() []ast.Statement {
 	stmts := []ast.Statement{}
 	p.nextToken() // move past '{'
 	for p.curToken.Type != token.RBRACE && p.curToken.Type != token.EOF {
 		var stmt ast.Statement
 		switch p.curToken.Type {
-		case token.LET:
+		case token.LET, token.CONST:
 			stmt = p.parseLetStatement()
 		case token.FNC:
 			stmt = p.parseFunctionStatement()
@@ -599,10 +1195,16 @@ func (p *Parser) parseBlock() []ast.Statement {
 			stmt = p.parseLogFunctionStatement()
 		case token.RETURN:
 			stmt = p.parseReturnStatement()
+		case token.ASSERT:
+			stmt = p.parseAssertStatement()
 		case token.IF:
 			stmt = p.parseIfStatement()
+		case token.MATCH:
+			stmt = p.parseMatchStatement()
 		case token.WHILE:
 			stmt = p.parseWhileStatement()
+		case token.WITH:
+			stmt = p.parseWithStatement()
 		case token.FOR:
 			stmt = p.parseForStatement()
 		case token.BREAK:
@@ -613,17 +1215,14 @@ func (p *Parser) parseBlock() []ast.Statement {
 			// Instead of checking for IDENT with peekToken,
 			// if the current token is IDENT do:
 			if p.curToken.Type == token.IDENT {
-				expr := p.parsePrimary()
+				line, col := p.curToken.Line, p.curToken.Col
+				// '>>' is not an expression operator, so a full expression
+				// stops right before it and may still become an assignment.
+				expr := p.parseExpression()
 				// If the next token is the assignment operator, upgrade.
-				if p.curToken.Type == token.ASSIGN_OP {
+				if isAssignOp(p.curToken.Type) {
 					stmt = p.parseAssignmentStatementFrom(expr)
 				} else {
-					var line, col int
-					if ident, ok := expr.(*ast.Identifier); ok {
-						line, col = ident.Line, ident.Col
-					} else {
-						line, col = p.curToken.Line, p.curToken.Col
-					}
 					stmt = &ast.ExpressionStatement{
 						Expr: expr,
 						Line: line,
@@ -632,11 +1231,12 @@ func (p *Parser) parseBlock() []ast.Statement {
 				}
 			} else {
 				// Otherwise, try to parse an expression normally.
+				line, col := p.curToken.Line, p.curToken.Col
 				expr := p.parseExpression()
 				stmt = &ast.ExpressionStatement{
 					Expr: expr,
-					Line: p.curToken.Line,
-					Col:  p.curToken.Col,
+					Line: line,
+					Col:  col,
 				}
 			}
 		}
@@ -666,6 +1266,8 @@ func (p *Parser) parseLogical() ast.Expression {
 	return left
 }
 
+// parseUnary parses ! and - applied to a single operand, binding tighter than
+// any binary operator: -5 + 3 is (-5) + 3 and !x && y is (!x) && y.
 func (p *Parser) parseUnary() ast.Expression {
 	if p.curToken.Type == token.NOT || p.curToken.Type == token.MINUS {
 		op := p.curToken.Type
@@ -679,7 +1281,7 @@ func (p *Parser) parseUnary() ast.Expression {
 			Col:      col,
 		}
 	}
-	return p.parseLogical()
+	return p.parsePrimary()
 }
 
 func (p *Parser) parseAssignmentStatement() *ast.AssignmentStatement {
@@ -692,6 +1294,7 @@ func (p *Parser) parseAssignmentStatement() *ast.AssignmentStatement {
 		p.nextToken()
 		// Support xs[0] on left side
 		for p.curToken.Type == token.LBRACKET {
+			bracketLine, bracketCol := p.curToken.Line, p.curToken.Col
 			p.nextToken()
 			index := p.parseExpression()
 			if p.curToken.Type != token.RBRACKET {
@@ -699,19 +1302,20 @@ func (p *Parser) parseAssignmentStatement() *ast.AssignmentStatement {
 				return nil
 			}
 			p.nextToken()
-			left = &ast.IndexExpression{Left: left, Index: index}
+			left = &ast.IndexExpression{Left: left, Index: index, Line: bracketLine, Col: bracketCol}
 		}
 	} else {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected identifier or index expression on line %d:%d", line, col))
 		return nil
 	}
 
-	if p.curToken.Type != token.ASSIGN_OP {
+	if !isAssignOp(p.curToken.Type) {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected '>>' after assignment target on line %d:%d", line, col))
 		return nil
 	}
+	op := p.curToken.Type
 	p.nextToken()
-	value := p.parseExpression()
+	value, compound := compoundValue(left, op, p.parseExpression(), line, col)
 
 	// If left is identifier, set Name; if index, set Left
 	name := ""
@@ -720,18 +1324,19 @@ func (p *Parser) parseAssignmentStatement() *ast.AssignmentStatement {
 	}
 
 	return &ast.AssignmentStatement{
-		Name:  name,
-		Left:  left,
-		Value: value,
-		Line:  line,
-		Col:   col,
+		Name:     name,
+		Left:     left,
+		Value:    value,
+		Compound: compound,
+		Line:     line,
+		Col:      col,
 	}
 }
 
 func (p *Parser) parseWhileStatement() *ast.WhileStatement {
 	ws := &ast.WhileStatement{Line: p.curToken.Line, Col: p.curToken.Col}
 	p.nextToken() // move to condition
-	ws.Condition = p.parseExpression()
+	ws.Condition = p.parseHeaderExpression()
 	if p.curToken.Type != token.LBRACE {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected '{' after while condition on line %d:%d", p.curToken.Line, p.curToken.Col))
 		return nil
@@ -740,6 +1345,30 @@ func (p *Parser) parseWhileStatement() *ast.WhileStatement {
 	return ws
 }
 
+// parseWithStatement parses `with h >> go.file.open("a.txt") { ... }`.
+func (p *Parser) parseWithStatement() *ast.WithStatement {
+	ws := &ast.WithStatement{Line: p.curToken.Line, Col: p.curToken.Col}
+	p.nextToken() // move to name
+	if p.curToken.Type != token.IDENT {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected identifier after 'with' on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	ws.Name = p.curToken.Literal
+	p.nextToken()
+	if p.curToken.Type != token.ASSIGN_OP {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected '>>' after '%s' in with statement on line %d:%d", ws.Name, p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	p.nextToken()
+	ws.Value = p.parseHeaderExpression()
+	if p.curToken.Type != token.LBRACE {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected '{' after with value on line %d:%d", p.curToken.Line, p.curToken.Col))
+		return nil
+	}
+	ws.Body = p.parseBlock()
+	return ws
+}
+
 func (p *Parser) parseForStatement() *ast.ForStatement {
 	fs := &ast.ForStatement{Line: p.curToken.Line, Col: p.curToken.Col}
 	p.nextToken() // move to init
@@ -748,7 +1377,7 @@ func (p *Parser) parseForStatement() *ast.ForStatement {
 	var init ast.Statement
 	if p.curToken.Type == token.LET {
 		init = p.parseLetStatement()
-	} else if p.curToken.Type == token.IDENT && p.peekToken.Type == token.ASSIGN_OP {
+	} else if p.curToken.Type == token.IDENT && isAssignOp(p.peekToken.Type) {
 		init = p.parseAssignmentStatement()
 	} else {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected init statement in for loop on line %d:%d", p.curToken.Line, p.curToken.Col))
@@ -763,7 +1392,7 @@ func (p *Parser) parseForStatement() *ast.ForStatement {
 	p.nextToken()
 
 	// Parse condition
-	fs.Condition = p.parseExpression()
+	fs.Condition = p.parseHeaderExpression()
 	if p.curToken.Type != token.SEMICOLON {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected ';' after for-condition on line %d:%d", p.curToken.Line, p.curToken.Col))
 		return nil
@@ -771,8 +1400,11 @@ func (p *Parser) parseForStatement() *ast.ForStatement {
 	p.nextToken()
 
 	// Parse post statement (assignment)
-	if p.curToken.Type == token.IDENT && p.peekToken.Type == token.ASSIGN_OP {
+	if p.curToken.Type == token.IDENT && isAssignOp(p.peekToken.Type) {
+		saved := p.noStructLiteral
+		p.noStructLiteral = true
 		fs.Post = p.parseAssignmentStatement()
+		p.noStructLiteral = saved
 	} else {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected post statement in for loop on line %d:%d", p.curToken.Line, p.curToken.Col))
 		return nil
@@ -790,8 +1422,7 @@ func (p *Parser) parsePackageStatement() *ast.PackageStatement {
 	p.nextToken()
 
 	if p.curToken.Type != token.IDENT {
-		msg := "expected package name after 'package'"
-		p.Errors = append(p.Errors, msg)
+		p.Errors = append(p.Errors, fmt.Sprintf("expected package name after 'package' on line %d:%d", p.curToken.Line, p.curToken.Col))
 		return nil
 	}
 
@@ -802,8 +1433,7 @@ func (p *Parser) parsePackageStatement() *ast.PackageStatement {
 		p.nextToken() // consume '.'
 		p.nextToken() // move to next IDENT
 		if p.curToken.Type != token.IDENT {
-			msg := "expected identifier after '.' in package path"
-			p.Errors = append(p.Errors, msg)
+			p.Errors = append(p.Errors, fmt.Sprintf("expected identifier after '.' in package path on line %d:%d", p.curToken.Line, p.curToken.Col))
 			return nil
 		}
 		parts = append(parts, p.curToken.Literal)
@@ -819,8 +1449,7 @@ func (p *Parser) parseImportStatement() *ast.ImportStatement {
 	p.nextToken()
 
 	if p.curToken.Type != token.IDENT {
-		msg := "expected import path after 'import'"
-		p.Errors = append(p.Errors, msg)
+		p.Errors = append(p.Errors, fmt.Sprintf("expected import path after 'import' on line %d:%d", p.curToken.Line, p.curToken.Col))
 		return nil
 	}
 
@@ -831,8 +1460,7 @@ func (p *Parser) parseImportStatement() *ast.ImportStatement {
 		p.nextToken() // consume '.'
 		p.nextToken() // move to next IDENT
 		if p.curToken.Type != token.IDENT {
-			msg := "expected identifier after '.' in import path"
-			p.Errors = append(p.Errors, msg)
+			p.Errors = append(p.Errors, fmt.Sprintf("expected identifier after '.' in import path on line %d:%d", p.curToken.Line, p.curToken.Col))
 			return nil
 		}
 		parts = append(parts, p.curToken.Literal)
@@ -880,14 +1508,16 @@ func (p *Parser) parseStructStatement() *ast.StructStatement {
 		fieldName := p.curToken.Literal
 		p.nextToken()
 
-		// Expect a type (user-defined types come as IDENT or built-in as TYPE)
-		if p.curToken.Type != token.TYPE && p.curToken.Type != token.IDENT {
+		// Expect a type (user-defined types come as IDENT, built-in as TYPE, closures as FNC)
+		if p.curToken.Type != token.TYPE && p.curToken.Type != token.IDENT && p.curToken.Type != token.FNC && p.curToken.Type != token.LPAREN {
 			p.Errors = append(p.Errors, fmt.Sprintf("expected type after ':' on line %d:%d", p.curToken.Line, p.curToken.Col))
 			return nil
 		}
-		fieldType := p.curToken.Literal
+		fieldType, ok := p.parseType()
+		if !ok {
+			return nil
+		}
 		fields = append(fields, ast.StructField{Name: fieldName, Type: fieldType})
-		p.nextToken()
 
 		// Optional comma
 		if p.curToken.Type == token.COMMA {
@@ -903,6 +1533,31 @@ func (p *Parser) parseStructStatement() *ast.StructStatement {
 	return stmt
 }
 
+// parseTypeAliasStatement parses type Name >> Type. Like in a struct
+// declaration, the >> is optional: type UserId int.
+func (p *Parser) parseTypeAliasStatement() *ast.TypeAliasStatement {
+	stmt := &ast.TypeAliasStatement{Line: p.curToken.Line, Col: p.curToken.Col}
+	p.nextToken() // consume 'type'
+
+	if p.curToken.Type != token.IDENT {
+		p.Errors = append(p.Errors, fmt.Sprintf("expected type alias name on line %d:%d", p.curToken.Line, p.curToken.Col))
+		p.nextToken()
+		return nil
+	}
+	stmt.Name = p.curToken.Literal
+	p.nextToken()
+
+	if p.curToken.Type == token.ASSIGN_OP {
+		p.nextToken()
+	}
+	typ, ok := p.parseType()
+	if !ok {
+		return nil
+	}
+	stmt.Type = typ
+	return stmt
+}
+
 func (p *Parser) parseStructLiteral(expectedType string, line, col int) ast.Expression {
 	// p.curToken should be '{'
 	if p.curToken.Type != token.LBRACE {
@@ -941,6 +1596,22 @@ func (p *Parser) parseStructLiteral(expectedType string, line, col int) ast.Expr
 		Col:        col,
 	}
 }
+// isAssignOp reports whether t is >> or a compound assignment operator.
+func isAssignOp(t token.TokenType) bool {
+	_, compound := token.CompoundAssignOps[t]
+	return t == token.ASSIGN_OP || compound
+}
+
+// compoundValue desugars x += e into the value x + e, returning the binary
+// operator applied ("" for a plain >> assignment).
+func compoundValue(left ast.Expression, op token.TokenType, value ast.Expression, line, col int) (ast.Expression, token.TokenType) {
+	binOp, ok := token.CompoundAssignOps[op]
+	if !ok {
+		return value, ""
+	}
+	return &ast.BinaryExpression{Left: left, Operator: binOp, Right: value, Line: line, Col: col}, binOp
+}
+
 func (p *Parser) parseAssignmentStatementFrom(left ast.Expression) *ast.AssignmentStatement {
 	var line, col int
 	switch l := left.(type) {
@@ -957,13 +1628,14 @@ func (p *Parser) parseAssignmentStatementFrom(left ast.Expression) *ast.Assignme
 		line, col = p.curToken.Line, p.curToken.Col
 	}
 
-	// Expect the assignment operator (>>)
-	if p.curToken.Type != token.ASSIGN_OP {
+	// Expect the assignment operator (>> or a compound one like +=)
+	if !isAssignOp(p.curToken.Type) {
 		p.Errors = append(p.Errors, fmt.Sprintf("expected '>>' after assignment target on line %d:%d", line, col))
 		return nil
 	}
-	p.nextToken() // skip '>>'
-	value := p.parseExpression()
+	op := p.curToken.Type
+	p.nextToken() // skip the operator
+	value, compound := compoundValue(left, op, p.parseExpression(), line, col)
 
 	var name string
 	switch l := left.(type) {
@@ -976,11 +1648,12 @@ func (p *Parser) parseAssignmentStatementFrom(left ast.Expression) *ast.Assignme
 	}
 
 	return &ast.AssignmentStatement{
-		Name:  name,
-		Left:  left,
-		Value: value,
-		Line:  line,
-		Col:   col,
+		Name:     name,
+		Left:     left,
+		Value:    value,
+		Compound: compound,
+		Line:     line,
+		Col:      col,
 	}
 }
 
@@ -994,6 +1667,15 @@ func (p *Parser) parseMapLiteral(keyType, valueType string) *ast.MapLiteral {
 	}
 	p.nextToken() // skip '{'
 	for p.curToken.Type != token.RBRACE && p.curToken.Type != token.EOF {
+		// Spread: { ...defaults, key: override }
+		if p.curToken.Type == token.ELLIPSIS {
+			p.nextToken()
+			lit.Spreads = append(lit.Spreads, p.parseExpression())
+			if p.curToken.Type == token.COMMA {
+				p.nextToken()
+			}
+			continue
+		}
 		key := p.parseExpression()
 		if p.curToken.Type != token.COLON {
 			p.Errors = append(p.Errors, fmt.Sprintf("expected ':' after map key on line %d:%d", p.curToken.Line, p.curToken.Col))
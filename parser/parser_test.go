@@ -0,0 +1,625 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/notrealandy/tox/ast"
+	"github.com/notrealandy/tox/lexer"
+	"github.com/notrealandy/tox/token"
+)
+
+// parse parses src, failing the test on parse errors.
+func parse(t *testing.T, src string) []ast.Statement {
+	t.Helper()
+	p := New(lexer.New(src))
+	stmts := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		t.Fatalf("parse errors for %q: %v", src, p.Errors)
+	}
+	return stmts
+}
+
+// parseExpr parses src as the value of a let.
+func parseExpr(t *testing.T, src string) ast.Expression {
+	t.Helper()
+	stmts := parse(t, "let v any >> "+src)
+	if len(stmts) != 1 {
+		t.Fatalf("%q parsed to %d statements, want 1", src, len(stmts))
+	}
+	return stmts[0].(*ast.LetStatement).Value
+}
+
+// symbols spells out the operators whose token types aren't their source text.
+var symbols = map[token.TokenType]string{
+	token.EQ: "==", token.NEQ: "!=", token.LT: "<", token.LTE: "<=", token.GT: ">", token.GTE: ">=",
+	token.AND: "and", token.OR: "or", token.NOT: "not", token.IN: "in",
+}
+
+func symbol(op token.TokenType) string {
+	if s, ok := symbols[op]; ok {
+		return s
+	}
+	return string(op)
+}
+
+// sexpr writes e in prefix form, e.g. (+ 1 (* 2 3)), so a test can give the
+// shape of a parse on one line. Missing parts of a slice are written as _.
+func sexpr(e ast.Expression) string {
+	switch e := e.(type) {
+	case nil:
+		return "_"
+	case *ast.Identifier:
+		return e.Value
+	case *ast.IntegerLiteral:
+		return fmt.Sprint(e.Value)
+	case *ast.FloatLiteral:
+		return fmt.Sprint(e.Value)
+	case *ast.StringLiteral:
+		return fmt.Sprintf("%q", e.Value)
+	case *ast.BoolLiteral:
+		return fmt.Sprint(e.Value)
+	case *ast.NilLiteral:
+		return "nil"
+	case *ast.UnaryExpression:
+		return fmt.Sprintf("(%s %s)", symbol(e.Operator), sexpr(e.Right))
+	case *ast.BinaryExpression:
+		return fmt.Sprintf("(%s %s %s)", symbol(e.Operator), sexpr(e.Left), sexpr(e.Right))
+	case *ast.TernaryExpression:
+		return fmt.Sprintf("(? %s %s %s)", sexpr(e.Cond), sexpr(e.Then), sexpr(e.Else))
+	case *ast.IndexExpression:
+		return fmt.Sprintf("(index %s %s)", sexpr(e.Left), sexpr(e.Index))
+	case *ast.SliceExpression:
+		return fmt.Sprintf("(slice %s %s %s %s)", sexpr(e.Left), sexpr(e.Start), sexpr(e.End), sexpr(e.Step))
+	case *ast.CallExpression:
+		parts := []string{"call", sexpr(e.Function)}
+		for i, arg := range e.Arguments {
+			if e.ArgNames != nil && e.ArgNames[i] != "" {
+				parts = append(parts, e.ArgNames[i]+"="+sexpr(arg))
+			} else {
+				parts = append(parts, sexpr(arg))
+			}
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	case *ast.ArrayLiteral:
+		parts := make([]string, len(e.Elements))
+		for i, elem := range e.Elements {
+			parts[i] = sexpr(elem)
+		}
+		return "[" + strings.Join(parts, " ") + "]"
+	}
+	return fmt.Sprintf("<%T>", e)
+}
+
+// exprCase is an expression and the prefix form it should parse to.
+type exprCase struct {
+	src  string
+	want string
+}
+
+func runExprCases(t *testing.T, tests []exprCase) {
+	t.Helper()
+	for _, tt := range tests {
+		if got := sexpr(parseExpr(t, tt.src)); got != tt.want {
+			t.Errorf("%s parsed to %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+// expectParseErrors checks that src fails to parse with the errors in want,
+// each given as a substring of the message, in order.
+func expectParseErrors(t *testing.T, src string, want []string) {
+	t.Helper()
+	p := New(lexer.New(src))
+	p.ParseProgram()
+	if len(p.Errors) < len(want) {
+		t.Fatalf("got errors %q, want %q", p.Errors, want)
+	}
+	for i, w := range want {
+		if !strings.Contains(p.Errors[i], w) {
+			t.Errorf("error %d = %q, want it to contain %q", i, p.Errors[i], w)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	runExprCases(t, []exprCase{
+		{"1 + 2 * 3", "(+ 1 (* 2 3))"},
+		{"1 + 2 * 3 - 4", "(- (+ 1 (* 2 3)) 4)"},
+		{"10 / 2 % 3", "(% (/ 10 2) 3)"},
+		{"-2 * 3", "(* (- 2) 3)"},
+		{"-2 * 3 + 4", "(+ (* (- 2) 3) 4)"},
+		{"-(1 + 2)", "(- (+ 1 2))"},
+		{"-x[0]", "(- (index x 0))"},
+		{"--x", "(- (- x))"},
+		{"1 - -1", "(- 1 (- 1))"},
+		{"a + 1 > b * 2", "(> (+ a 1) (* b 2))"},
+		{"not a and b or c", "(or (and (not a) b) c)"},
+		{"!ok", "(not ok)"},
+		{"x in xs and y", "(and (in x xs) y)"},
+	})
+}
+
+func TestTernary(t *testing.T) {
+	runExprCases(t, []exprCase{
+		{`x > 0 ? "pos" : "neg"`, `(? (> x 0) "pos" "neg")`},
+		{"a ? 1 : b ? 2 : 3", "(? a 1 (? b 2 3))"},
+		{"(a ? b : c) ? d : e", "(? (? a b c) d e)"},
+		{"a and b ? x + 1 : -x", "(? (and a b) (+ x 1) (- x))"},
+		{"f(a ? 1 : 2)", "(call f (? a 1 2))"},
+	})
+	expectParseErrors(t, "let v int >> a ? 1", []string{"expected ':'"})
+}
+
+func TestSlices(t *testing.T) {
+	runExprCases(t, []exprCase{
+		{"xs[1:3]", "(slice xs 1 3 _)"},
+		{"xs[:3]", "(slice xs _ 3 _)"},
+		{"xs[2:]", "(slice xs 2 _ _)"},
+		{"xs[1:5:2]", "(slice xs 1 5 2)"},
+		{"xs[::-1]", "(slice xs _ _ (- 1))"},
+		{"xs[-2:]", "(slice xs (- 2) _ _)"},
+		{"xs[i + 1]", "(index xs (+ i 1))"},
+	})
+}
+
+func TestNamedArgumentParsing(t *testing.T) {
+	runExprCases(t, []exprCase{
+		{"f(1, 2)", "(call f 1 2)"},
+		{"f(a, n: 2)", "(call f a n=2)"},
+		{"f(punct: \"?\", name: \"Ann\")", `(call f punct="?" name="Ann")`},
+		{"g.greet(name: x + 1)", "(call g.greet name=(+ x 1))"},
+		{"f(x ? a : b)", "(call f (? x a b))"},
+	})
+	call := parseExpr(t, "f(1, 2)").(*ast.CallExpression)
+	if call.ArgNames != nil {
+		t.Errorf("ArgNames of a positional call = %q, want nil", call.ArgNames)
+	}
+	expectParseErrors(t, "let v int >> f(n: 1, 2)", []string{"positional argument after named argument on line 1:22"})
+}
+
+func TestExpressionPositions(t *testing.T) {
+	src := `fnc main() {
+    let total int >> sum(xs[0],
+        -count * 2)
+    log(u.name == "Ann" ? [1] : [])
+}
+`
+	want := []string{
+		"FunctionStatement 1:1",
+		"LetStatement 2:5",
+		"CallExpression 2:22",
+		"Identifier 2:22",
+		"IndexExpression 2:28",
+		"Identifier 2:26",
+		"IntegerLiteral 2:29",
+		"BinaryExpression 3:16",
+		"UnaryExpression 3:9",
+		"Identifier 3:10",
+		"IntegerLiteral 3:18",
+		"LogFunction 4:5",
+		"TernaryExpression 4:25",
+		"BinaryExpression 4:16",
+		"Identifier 4:9",
+		"StringLiteral 4:19",
+		"ArrayLiteral 4:27",
+		"IntegerLiteral 4:28",
+		"ArrayLiteral 4:33",
+	}
+	var got []string
+	ast.Walk(parse(t, src), func(node interface{}) {
+		v := reflect.ValueOf(node).Elem()
+		got = append(got, fmt.Sprintf("%s %d:%d", v.Type().Name(), v.FieldByName("Line").Int(), v.FieldByName("Col").Int()))
+	})
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("positions:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// signature describes a parsed function declaration: its parameters with
+// their types, defaults and a trailing ... when variadic, and its return type.
+func signature(fn *ast.FunctionStatement) string {
+	params := make([]string, len(fn.Params))
+	for i, name := range fn.Params {
+		params[i] = name + " " + fn.ParamTypes[i]
+		if i < len(fn.Defaults) && fn.Defaults[i] != nil {
+			params[i] += " = " + sexpr(fn.Defaults[i])
+		}
+	}
+	if fn.Variadic {
+		params[len(params)-1] += "..."
+	}
+	return "(" + strings.Join(params, ", ") + ") " + fn.ReturnType
+}
+
+// signatureCase is a function declaration and its expected signature.
+type signatureCase struct {
+	src  string
+	want string
+}
+
+func runSignatureCases(t *testing.T, tests []signatureCase) {
+	t.Helper()
+	for _, tt := range tests {
+		fn, ok := parse(t, tt.src)[0].(*ast.FunctionStatement)
+		if !ok {
+			t.Errorf("%s did not parse to a function", tt.src)
+			continue
+		}
+		if got := signature(fn); got != tt.want {
+			t.Errorf("%s parsed to %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultParameters(t *testing.T) {
+	runSignatureCases(t, []signatureCase{
+		{"fnc f(a int) >> int { return a }", "(a int) int"},
+		{"fnc f() { }", "() void"},
+		{`fnc greet(name string, punct string >> "!") >> string { return name }`, `(name string, punct string = "!") string`},
+		{"fnc f(a int >> 1 + 1, b int >> -1) { }", "(a int = (+ 1 1), b int = (- 1)) void"},
+	})
+	expectParseErrors(t, "fnc f(a int, b int >> 2, c int) { }",
+		[]string{"parameter 'c' needs a default value, as the parameters before it have one, on line 1:31"})
+}
+
+func TestVariadicParameters(t *testing.T) {
+	runSignatureCases(t, []signatureCase{
+		{"fnc sum(nums int...) >> int { return 0 }", "(nums int[]...) int"},
+		{"fnc join(sep string, parts string...) >> string { return sep }", "(sep string, parts string[]...) string"},
+		{"fnc f(ms map[string]int...) { }", "(ms (map[string]int)[]...) void"},
+	})
+	expectParseErrors(t, "fnc f(nums int..., last int) { }",
+		[]string{"variadic parameter 'nums' must be the last parameter on line 1:18"})
+}
+
+func TestFunctionTypes(t *testing.T) {
+	runSignatureCases(t, []signatureCase{
+		{"fnc apply(f fnc(int) >> int, x int) >> int { return f(x) }", "(f fnc(int)>>int, x int) int"},
+		{"fnc each(f fnc(int, string) >> bool, g fnc()) { }", "(f fnc(int,string)>>bool, g fnc()>>void) void"},
+		{"fnc adder(n int) >> fnc(int) >> int { return fnc(m int) >> int { return n + m } }", "(n int) fnc(int)>>int"},
+		{"fnc f(fs fnc(int) >> int...) { }", "(fs (fnc(int)>>int)[]...) void"},
+	})
+	lit := parseExpr(t, "fnc(n int) >> int { return n * 2 }").(*ast.FunctionLiteral)
+	if got := signature(lit.Fn); got != "(n int) int" {
+		t.Errorf("function literal parsed to %s, want (n int) int", got)
+	}
+}
+
+func TestElif(t *testing.T) {
+	tests := []struct {
+		src       string
+		wantElifs int
+		wantElse  bool
+	}{
+		{"if a { log(1) }", 0, false},
+		{"if a { log(1) } else { log(2) }", 0, true},
+		{"if a { log(1) } elif b { log(2) }", 1, false},
+		{"if a { log(1) } elif b { log(2) } elif c { log(3) } else { log(4) }", 2, true},
+		{"if a { log(1) } else if b { log(2) } else { log(3) }", 1, true},
+		{"if a {\n    log(1)\n}\nelif b {\n    log(2)\n}\nelse {\n    log(3)\n}", 1, true},
+	}
+	for _, tt := range tests {
+		stmts := parse(t, "fnc main() {\n"+tt.src+"\n}")
+		body := stmts[0].(*ast.FunctionStatement).Body
+		if len(body) != 1 {
+			t.Errorf("%q parsed to %d statements, want 1", tt.src, len(body))
+			continue
+		}
+		stmt := body[0].(*ast.IfStatement)
+		if len(stmt.ElifConds) != tt.wantElifs || len(stmt.ElifBodies) != tt.wantElifs {
+			t.Errorf("%q has %d elif conditions and %d bodies, want %d", tt.src, len(stmt.ElifConds), len(stmt.ElifBodies), tt.wantElifs)
+		}
+		if (stmt.ElseBody != nil) != tt.wantElse {
+			t.Errorf("%q has else body %v, want one: %v", tt.src, stmt.ElseBody, tt.wantElse)
+		}
+	}
+
+	errTests := []struct {
+		src  string
+		want []string
+	}{
+		{"if a { log(1) } else { log(2) } elif b { log(3) }", []string{"'elif' after 'else' on line 1:33"}},
+		{"if a { log(1) } else { log(2) } else { log(3) }", []string{"duplicate 'else' on line 1:33"}},
+		{"if a { log(1) } else { log(2) } else if b { log(3) }", []string{"duplicate 'else' on line 1:33"}},
+		{"if a { log(1) } else { } elif b { } else { }", []string{"'elif' after 'else' on line 1:26", "duplicate 'else' on line 1:37"}},
+	}
+	for _, tt := range errTests {
+		want := make([]string, len(tt.want))
+		for i, w := range tt.want {
+			want[i] = strings.Replace(w, "line 1:", "line 2:", 1)
+		}
+		expectParseErrors(t, "fnc main() {\n"+tt.src+"\n}", want)
+	}
+}
+
+func TestNestedIf(t *testing.T) {
+	src := `fnc main() {
+    if a {
+        if b {
+            if c {
+                log(1)
+            } elif d {
+                if e {
+                    log(2)
+                }
+            } else {
+                log(3)
+            }
+        }
+    }
+}`
+	// Follow the first branch of each if down to the innermost one
+	depth := 0
+	body := parse(t, src)[0].(*ast.FunctionStatement).Body
+	for len(body) == 1 {
+		stmt, ok := body[0].(*ast.IfStatement)
+		if !ok {
+			break
+		}
+		depth++
+		if depth == 3 {
+			if len(stmt.ElifBodies) != 1 || len(stmt.ElseBody) != 1 {
+				t.Fatalf("innermost if has %d elif and %d else statements, want 1 and 1", len(stmt.ElifBodies), len(stmt.ElseBody))
+			}
+			if _, ok := stmt.ElifBodies[0][0].(*ast.IfStatement); !ok {
+				t.Errorf("elif body = %#v, want an if", stmt.ElifBodies[0][0])
+			}
+		}
+		body = stmt.IfBody
+	}
+	if depth != 3 {
+		t.Errorf("parsed %d nested ifs, want 3", depth)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	src := `fnc main() {
+    match x + 1 {
+        case 1 >> { log("one") }
+        case "two" >> {
+            log(2)
+            log(2)
+        }
+        default >> { }
+    }
+}`
+	stmt := parse(t, src)[0].(*ast.FunctionStatement).Body[0].(*ast.MatchStatement)
+	if got := sexpr(stmt.Subject); got != "(+ x 1)" {
+		t.Errorf("subject = %s, want (+ x 1)", got)
+	}
+	var cases []string
+	for i, c := range stmt.Cases {
+		cases = append(cases, fmt.Sprintf("%s:%d", sexpr(c), len(stmt.Bodies[i])))
+	}
+	if got := strings.Join(cases, " "); got != `1:1 "two":2` {
+		t.Errorf("cases = %s, want 1:1 \"two\":2", got)
+	}
+	if stmt.Default == nil || len(stmt.Default) != 0 {
+		t.Errorf("default = %#v, want an empty body", stmt.Default)
+	}
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"match x { case 1 { } }", "expected '>>' after case on line 1:18"},
+		{"match x { log(1) }", "expected 'case' or 'default' in match on line 1:11"},
+		{"match x { default >> { } default >> { } }", "duplicate default in match on line 1:26"},
+		{"match x case", "expected '{' after match subject on line 1:9"},
+	}
+	for _, tt := range tests {
+		expectParseErrors(t, "fnc main() {\n"+tt.src+"\n}", []string{strings.Replace(tt.want, "line 1:", "line 2:", 1)})
+	}
+}
+
+func TestTypes(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"let xs User[] >> []", "User[]"},
+		{"let grid int[][] >> [[1]]", "int[][]"},
+		{"let p geo.Point >> nil", "geo.Point"},
+		{"let ps geo.Point[] >> []", "geo.Point[]"},
+		{"let ms (map[string]int)[] >> []", "(map[string]int)[]"},
+		{"let fs (fnc(int) >> int)[] >> []", "(fnc(int)>>int)[]"},
+		{`let m :>> map[string] >> int[] { "a": [1] }`, "map[string]int[]"},
+		{`let m :>> map[string] >> User { }`, "map[string]User"},
+	}
+	for _, tt := range tests {
+		let := parse(t, tt.src)[0].(*ast.LetStatement)
+		if let.Type != tt.want {
+			t.Errorf("%s has type %s, want %s", tt.src, let.Type, tt.want)
+		}
+	}
+
+	src := `struct Order {
+    items Item[]
+    tags string[]
+    owner User
+    history (map[string]int)[]
+}`
+	st := parse(t, src)[0].(*ast.StructStatement)
+	var fields []string
+	for _, f := range st.Fields {
+		fields = append(fields, f.Name+" "+f.Type)
+	}
+	want := "items Item[], tags string[], owner User, history (map[string]int)[]"
+	if got := strings.Join(fields, ", "); got != want {
+		t.Errorf("fields = %s, want %s", got, want)
+	}
+
+	runSignatureCases(t, []signatureCase{
+		{"fnc f(users User[], history (map[string]int)[]) >> Order[] { return [] }", "(users User[], history (map[string]int)[]) Order[]"},
+	})
+}
+
+func TestMultilineExpressions(t *testing.T) {
+	runExprCases(t, []exprCase{
+		{"1 +\n    2", "(+ 1 2)"},
+		{"1\n    + 2\n    * 3", "(+ 1 (* 2 3))"},
+		{"a and\n    b", "(and a b)"},
+		{"f(1,\n    2 // two\n)", "(call f 1 2)"},
+		{"[1,\n    /* two */ 2,\n]", "[1 2]"},
+		{"[1, // first\n    2, // second\n    3]", "[1 2 3]"},
+		{"[\n    1, // first\n    2 // last\n]", "[1 2]"},
+		{"ok\n    ? 1\n    : 2", "(? ok 1 2)"},
+	})
+	// A new line starting with an operator continues the statement before it
+	body := parse(t, "fnc main() {\n    let total int >> 1\n        + 2\n    log(total)\n}")[0].(*ast.FunctionStatement).Body
+	if len(body) != 2 {
+		t.Fatalf("body has %d statements, want 2", len(body))
+	}
+	if got := sexpr(body[0].(*ast.LetStatement).Value); got != "(+ 1 2)" {
+		t.Errorf("let value = %s, want (+ 1 2)", got)
+	}
+}
+
+func TestArrayLiteralErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"let v int[] >> [1, 2", "expected ']' at end of array literal on line 1:21"},
+		{"let v int[] >> [1, 2,, 3]", "unexpected token ',' in expression on line 1:22"},
+		{"let v int[] >> [1 +]", "unexpected token ']' in expression on line 1:20"},
+	}
+	for _, tt := range tests {
+		expectParseErrors(t, tt.src, []string{tt.want})
+	}
+}
+
+func TestTypeAliases(t *testing.T) {
+	tests := []struct {
+		src  string
+		want ast.TypeAliasStatement
+	}{
+		{"type UserId >> int", ast.TypeAliasStatement{Name: "UserId", Type: "int", Line: 1, Col: 1}},
+		{"type UserId int", ast.TypeAliasStatement{Name: "UserId", Type: "int", Line: 1, Col: 1}},
+		{"type Ids >> UserId[]", ast.TypeAliasStatement{Name: "Ids", Type: "UserId[]", Line: 1, Col: 1}},
+		{"type Counts >> (map[string]int)[]", ast.TypeAliasStatement{Name: "Counts", Type: "(map[string]int)[]", Line: 1, Col: 1}},
+		{"type Handler >> fnc(string) >> bool", ast.TypeAliasStatement{Name: "Handler", Type: "fnc(string)>>bool", Line: 1, Col: 1}},
+		{"pub type Name >> string", ast.TypeAliasStatement{Name: "Name", Type: "string", Visibility: "pub", Line: 1, Col: 5}},
+	}
+	for _, tt := range tests {
+		alias, ok := parse(t, tt.src)[0].(*ast.TypeAliasStatement)
+		if !ok || *alias != tt.want {
+			t.Errorf("%s parsed to %#v, want %#v", tt.src, alias, tt.want)
+		}
+	}
+	expectParseErrors(t, "type >> int", []string{"expected type alias name on line 1:6"})
+}
+
+func TestMapSpread(t *testing.T) {
+	src := `let m :>> map[string] >> int { ...defaults, "a": 1, ...overrides }`
+	lit := parse(t, src)[0].(*ast.LetStatement).Value.(*ast.MapLiteral)
+	var spreads []string
+	for _, s := range lit.Spreads {
+		spreads = append(spreads, sexpr(s))
+	}
+	if got := strings.Join(spreads, " "); got != "defaults overrides" {
+		t.Errorf("spreads = %s, want defaults overrides", got)
+	}
+	if len(lit.Pairs) != 1 {
+		t.Errorf("map literal has %d pairs, want 1", len(lit.Pairs))
+	}
+	for k, v := range lit.Pairs {
+		if sexpr(k) != `"a"` || sexpr(v) != "1" {
+			t.Errorf("pair = %s: %s, want \"a\": 1", sexpr(k), sexpr(v))
+		}
+	}
+}
+
+func TestCompoundAssignmentParsing(t *testing.T) {
+	tests := []struct {
+		src          string
+		wantCompound token.TokenType
+		wantValue    string
+	}{
+		{"x >> 1", "", "1"},
+		{"x += 1", token.PLUS, "(+ x 1)"},
+		{"x -= y * 2", token.MINUS, "(- x (* y 2))"},
+		{"x *= 3", token.ASTERISK, "(* x 3)"},
+		{"x /= 4", token.SLASH, "(/ x 4)"},
+		{"xs[i] += 1", token.PLUS, "(+ (index xs i) 1)"},
+		{"u.score -= 1", token.MINUS, "(- u.score 1)"},
+	}
+	for _, tt := range tests {
+		body := parse(t, "fnc main() {\n    "+tt.src+"\n}")[0].(*ast.FunctionStatement).Body
+		stmt := body[0].(*ast.AssignmentStatement)
+		if stmt.Compound != tt.wantCompound || sexpr(stmt.Value) != tt.wantValue {
+			t.Errorf("%s parsed to compound %q value %s, want %q %s", tt.src, stmt.Compound, sexpr(stmt.Value), tt.wantCompound, tt.wantValue)
+		}
+		// The evaluator reads the target through the same node it assigns to
+		if bin, ok := stmt.Value.(*ast.BinaryExpression); ok && stmt.Compound != "" && bin.Left != stmt.Left {
+			t.Errorf("%s: the value's left operand is not the assignment target", tt.src)
+		}
+	}
+}
+
+func TestBuildTags(t *testing.T) {
+	stmts := parse(t, "@when(os == \"windows\")\nlet sep string >> \"\\\\\"\n@when(arch == \"amd64\")\nfnc f() { }")
+	first := stmts[0].(*ast.WhenStatement)
+	if first.Key != "os" || first.Value != "windows" || first.Decl.(*ast.LetStatement).Name != "sep" {
+		t.Errorf("first when = %#v", first)
+	}
+	second := stmts[1].(*ast.WhenStatement)
+	if second.Key != "arch" || second.Value != "amd64" || second.Decl.(*ast.FunctionStatement).Name != "f" {
+		t.Errorf("second when = %#v", second)
+	}
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"@when(cpu == \"x\")\nlet a int >> 1", "unknown build condition 'cpu', expected os or arch on line 1:7"},
+		{"@when(os != \"x\")\nlet a int >> 1", "expected '==' in build condition on line 1:10"},
+		{"@when(os == linux)\nlet a int >> 1", "expected string in build condition on line 1:13"},
+		{"@when os == \"x\"\nlet a int >> 1", "expected '(' after '@when' on line 1:7"},
+	}
+	for _, tt := range tests {
+		expectParseErrors(t, tt.src, []string{tt.want})
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	tests := []struct {
+		src          string
+		wantName     string
+		wantPure     bool
+		wantOverride bool
+		wantVis      string
+	}{
+		{"@pure\nfnc f(n int) >> int { return n }", "f", true, false, ""},
+		{"@pure\npub fnc f(n int) >> int { return n }", "f", true, false, "pub"},
+		{"@override\nfnc go.println(msg string) { }", "go.println", false, true, ""},
+		{"@override\nfnc go.map.merge(a map[string]int, b map[string]int) >> map[string]int { return a }", "go.map.merge", false, true, ""},
+		{"@override\nfnc go.regex.match(p string, s string) >> bool { return false }", "go.regex.match", false, true, ""},
+	}
+	for _, tt := range tests {
+		fn, ok := parse(t, tt.src)[0].(*ast.FunctionStatement)
+		if !ok {
+			t.Errorf("%q did not parse to a function", tt.src)
+			continue
+		}
+		if fn.Name != tt.wantName || fn.Pure != tt.wantPure || fn.Override != tt.wantOverride || fn.Visibility != tt.wantVis {
+			t.Errorf("%q parsed to %s pure=%v override=%v visibility=%q", tt.src, fn.Name, fn.Pure, fn.Override, fn.Visibility)
+		}
+	}
+
+	errTests := []struct {
+		src  string
+		want string
+	}{
+		{"@pure\nlet x int >> 1", "expected function declaration after '@pure' on line 2:1"},
+		{"@override\nlet x int >> 1", "expected function declaration after '@override' on line 2:1"},
+		{"@override\nfnc go.(s string) { }", "expected builtin name after '@override fnc' on line 2:8"},
+		{"@inline\nfnc f() { }", "unknown annotation '@inline' on line 1:1"},
+	}
+	for _, tt := range errTests {
+		expectParseErrors(t, tt.src, []string{tt.want})
+	}
+}
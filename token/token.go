@@ -7,7 +7,9 @@ const (
 	BREAK = "BREAK" // break keyword
 	CONTINUE = "CONTINUE" // continue keyword
 	STRUCT = "STRUCT" // struct keyword
+	TYPEDEF = "TYPEDEF" // type keyword, declares a type alias
 	LET = "LET" // reserved keyword
+	CONST = "CONST" // const keyword, an immutable let
 	FNC = "FNC" // function keyword
 	LOG = "LOG" // native function keyword
 	LEN = "LEN" // native function keyword
@@ -16,8 +18,13 @@ const (
 	ELIF = "ELIF" // else if statement
 	ELSE = "ELSE" // else statement
 	WHILE = "WHILE" // while loop
+	WITH = "WITH" // with block, closes its resource on exit
 	FOR = "FOR" // for loop
+	MATCH = "MATCH" // match statement
+	CASE = "CASE" // case of a match
+	DEFAULT = "DEFAULT" // default case of a match
 	RETURN = "RETURN"
+	ASSERT = "ASSERT" // assert statement
 	LPAREN = "LPAREN" // (
 	RPAREN = "RPAREN" // )
 	LBRACE = "LBRACE" // {
@@ -29,16 +36,23 @@ const (
 	ASSIGN_OP = "ASSIGN_OP" // >>
 	STRING = "STRING" // string literal, e.g. "test"
 	INT = "INT" // int literal, e.g. 3
+	FLOAT = "FLOAT" // float literal, e.g. 3.14
+	CHAR = "CHAR" // character literal, e.g. 'A' (an int holding its code point)
 	BOOL = "BOOL" // bool literal, e.g. true/false
-	FNCVOID = "FNCVOID" // function return type void
 	PACKAGE = "PACKAGE" // package keyword
 	IMPORT = "IMPORT" // import keyword
 	DOT = "DOT" // .
+	ELLIPSIS = "ELLIPSIS" // ...
+	RANGE = "RANGE" // .. (e.g. 0..10)
 	PLUS = "+"
 	MINUS = "-"
 	ASTERISK = "*"
 	SLASH = "/"
 	MODULUS = "%"
+	PLUS_ASSIGN = "+="
+	MINUS_ASSIGN = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN = "/="
 	NIL = "NIL"
 	COMMA = "COMMA" // ,
 	EQ = "EQ" // ==
@@ -47,18 +61,31 @@ const (
 	LTE = "LTE" // <=
 	GT = "GT" // >
 	GTE = "GTE" // >=
-	AND = "AND" // &&
-	OR = "OR" // ||
-	NOT = "NOT" // !
+	AND = "AND" // && or and
+	OR = "OR" // || or or
+	NOT = "NOT" // ! or not
+	IN = "IN" // in (membership, e.g. x in xs)
 	SEMICOLON = "SEMICOLON" // ;
 	COLON = "COLON" // :
+	QUESTION = "QUESTION" // ? (ternary, e.g. x > 0 ? "pos" : "neg")
+	AT = "AT" // @ (annotations, e.g. @pure)
 	ILLEGAL = "ILLEGAL"
 	EOF = "EOF"
 )
 
+// CompoundAssignOps maps compound assignment operators to the binary operator
+// they apply, e.g. x += 1 assigns x + 1 to x.
+var CompoundAssignOps = map[TokenType]TokenType{
+	PLUS_ASSIGN:     PLUS,
+	MINUS_ASSIGN:    MINUS,
+	ASTERISK_ASSIGN: ASTERISK,
+	SLASH_ASSIGN:    SLASH,
+}
+
 type Token struct {
 	Type TokenType
 	Literal string
 	Line int
 	Col int
+	Raw bool // a STRING written as a `raw` literal: no escapes or interpolation
 }
\ No newline at end of file
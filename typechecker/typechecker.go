@@ -2,6 +2,8 @@ package typechecker
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/notrealandy/tox/ast"
@@ -9,29 +11,226 @@ import (
 )
 
 var GoBuiltins = map[string]string{
-	"go.println":         "void",
-	"go.printf":          "void",
-	"go.time.now":        "string",
-	"go.time.sleep":      "void",
-	"go.file.open":       "int",
-	"go.file.close":      "void",
-	"go.file.read":       "string",
-	"go.file.write":      "bool",
-	"go.file.create":     "int",
-	"go.file.remove":     "bool",
-	"go.dir.create":      "bool",
-	"go.dir.remove":      "bool",
-	"go.dir.removeAll":   "bool",
-	"go.path.exists":     "bool",
-	"go.file.stat":       "map[string]any",
-	"go.file.readline":   "string",
-	"go.strings.split":   "string[]",
-	"go.strings.trim":    "string",
-	"go.strings.toLower": "string",
-	"go.strings.toUpper": "string",
-	"go.bytes.make":      "int[]", // or "byte[]" if you add a byte type
-	"go.bytes.copy":      "int",   // returns number of bytes copied
-	"go.bytes.cap":       "int",
+	"go.println":            "void",
+	"go.printf":             "void",
+	"go.time.now":           "string",
+	"go.time.sleep":         "void", // milliseconds
+	"go.time.sleepSeconds":  "void",
+	"go.time.unix":          "int", // seconds since the Unix epoch
+	"go.time.unixMillis":    "int", // milliseconds since the Unix epoch
+	"go.time.format":        "string",
+	"go.file.open":          "int",
+	"go.file.close":         "void",
+	"go.file.read":          "string",
+	"go.file.write":         "bool",
+	"go.file.writeAll":      "bool",
+	"go.file.append":        "bool",
+	"go.file.create":        "int",
+	"go.file.remove":        "bool",
+	"go.dir.create":         "bool",
+	"go.dir.remove":         "bool",
+	"go.dir.removeAll":      "bool",
+	"go.dir.list":           "string[]",           // nil if the path isn't a readable directory
+	"go.dir.listDetailed":   "(map[string]any)[]", // like go.file.stat, nil if the path isn't a readable directory
+	"go.path.exists":        "bool",
+	"go.file.stat":          "map[string]any",
+	"go.file.readline":      "string",
+	"go.file.readLines":     "string[]", // nil if the file can't be read
+	"go.strings.split":      "string[]",
+	"go.strings.trim":       "string",
+	"go.strings.toLower":    "string",
+	"go.strings.toUpper":    "string",
+	"go.strings.title":      "string",
+	"go.strings.capitalize": "string",
+	"go.strings.scan":       "any[]",
+	"go.strings.replace":    "string",
+	"go.strings.contains":   "bool",
+	"go.strings.indexOf":    "int",
+	"go.strings.repeat":     "string",
+	"go.strings.join":       "string",
+	"go.strings.hasPrefix":  "bool",
+	"go.strings.hasSuffix":  "bool",
+	"go.strings.length":     "int",            // characters, not bytes
+	"go.http.get":           "map[string]any", // {"status": int, "body": string, "error": string}
+	"go.http.post":          "map[string]any", // {"status": int, "body": string, "error": string}
+	"go.rand.int":           "int",            // from 0 up to but not including its argument; -1 if that is <= 0
+	"go.rand.float":         "float",          // from 0 up to but not including 1
+	"go.rand.seed":          "void",
+	"go.env.get":            "string", // "" if the variable isn't set
+	"go.env.set":            "bool",
+	"go.os.args":            "string[]",
+	"go.regex.match":        "bool",
+	"go.regex.find":         "string", // "" if nothing matches
+	"go.regex.findAll":      "string[]",
+	"go.bytes.make":         "int[]", // or "byte[]" if you add a byte type
+	"go.bytes.copy":         "int",   // returns number of bytes copied
+	"go.bytes.cap":          "int",
+	"go.map.merge":          "map[K]V", // same map type as its arguments, see genericBuiltins
+	"go.map.keys":           "K[]",     // key type of its map argument
+	"go.map.values":         "V[]",     // value type of its map argument
+	"go.map.has":            "bool",
+	"go.map.delete":         "void",
+	"go.async":              "bool",
+	"go.channel.new":        "int",
+	"go.channel.send":       "bool",
+	"go.channel.recv":       "any",
+	"go.math.sum":           "number", // element type of its int[]/float[] argument
+	"go.math.avg":           "float",
+	"go.math.minOf":         "number", // element type of its int[]/float[] argument
+	"go.math.maxOf":         "number", // element type of its int[]/float[] argument
+	"go.math.abs":           "number", // type of its argument
+	"go.math.min":           "number", // int for two ints, float otherwise
+	"go.math.max":           "number", // int for two ints, float otherwise
+	"go.math.pow":           "float",
+	"go.math.sqrt":          "float",
+	"go.math.floor":         "int",
+	"go.math.ceil":          "int",
+	"go.array.push":         "T[]", // type of its array argument
+	"go.array.pop":          "T",   // element type of its array argument
+	"go.array.append":       "T[]", // type of its array arguments
+	"go.array.contains":     "bool",
+	"go.array.sort":         "T[]",   // type of its array argument
+	"go.array.sortBy":       "T[]",   // type of its array argument
+	"go.conv.toInt":         "int",   // runtime error if the string isn't an int
+	"go.conv.toFloat":       "float", // runtime error if the string isn't a number
+	"go.conv.toBool":        "bool",  // runtime error if the string isn't true or false
+}
+
+// builtinParams are the parameter types of builtins whose arguments are
+// checked like those of a declared function.
+var builtinParams = map[string][]string{
+	"go.strings.replace":   {"string", "string", "string"},
+	"go.strings.contains":  {"string", "string"},
+	"go.strings.indexOf":   {"string", "string"},
+	"go.strings.repeat":    {"string", "int"},
+	"go.strings.join":      {"string[]", "string"},
+	"go.strings.hasPrefix": {"string", "string"},
+	"go.strings.hasSuffix": {"string", "string"},
+	"go.strings.length":    {"string"},
+	"go.http.get":          {"string"},
+	"go.http.post":         {"string", "string", "string"},
+	"go.rand.int":          {"int"},
+	"go.rand.float":        {},
+	"go.rand.seed":         {"int"},
+	"go.env.get":           {"string"},
+	"go.env.set":           {"string", "string"},
+	"go.os.args":           {},
+	"go.file.readLines":    {"string"},
+	"go.dir.list":          {"string"},
+	"go.dir.listDetailed":  {"string"},
+	"go.file.writeAll":     {"string", "string"},
+	"go.file.append":       {"string", "string"},
+	"go.time.sleepSeconds": {"int"},
+	"go.time.unix":         {},
+	"go.time.unixMillis":   {},
+	"go.time.format":       {"int", "string"},
+	"go.regex.match":       {"string", "string"},
+	"go.regex.find":        {"string", "string"},
+	"go.regex.findAll":     {"string", "string"},
+}
+
+// genericBuiltins compute the return type of builtins whose result type depends
+// on the types of their arguments. They take precedence over GoBuiltins.
+var genericBuiltins = map[string]func(argTypes []string) string{
+	"go.map.merge": func(argTypes []string) string {
+		if len(argTypes) > 0 {
+			return argTypes[0]
+		}
+		return ""
+	},
+	"go.math.sum":   numericElemType,
+	"go.math.minOf": numericElemType,
+	"go.math.maxOf": numericElemType,
+	"go.math.abs": func(argTypes []string) string {
+		if len(argTypes) == 1 && isNumeric(argTypes[0]) {
+			return argTypes[0]
+		}
+		return ""
+	},
+	"go.map.keys": func(argTypes []string) string {
+		if len(argTypes) == 1 {
+			if key, _, ok := mapTypes(argTypes[0]); ok {
+				return arrayType(key)
+			}
+		}
+		return ""
+	},
+	"go.map.values": func(argTypes []string) string {
+		if len(argTypes) == 1 {
+			if _, val, ok := mapTypes(argTypes[0]); ok {
+				return arrayType(val)
+			}
+		}
+		return ""
+	},
+	"go.array.push":   firstArrayType,
+	"go.array.append": firstArrayType,
+	"go.array.sort":   firstArrayType,
+	"go.array.sortBy": firstArrayType,
+	"go.array.pop": func(argTypes []string) string {
+		if len(argTypes) == 1 {
+			if elem, ok := elemType(argTypes[0]); ok {
+				return elem
+			}
+		}
+		return ""
+	},
+	"go.math.min": minMaxType,
+	"go.math.max": minMaxType,
+}
+
+// firstArrayType returns the type of an array first argument.
+func firstArrayType(argTypes []string) string {
+	if len(argTypes) > 0 {
+		if _, ok := elemType(argTypes[0]); ok {
+			return argTypes[0]
+		}
+	}
+	return ""
+}
+
+// minMaxType returns the type of go.math.min and go.math.max, which promote
+// like arithmetic: int for two ints, float otherwise.
+func minMaxType(argTypes []string) string {
+	if len(argTypes) == 2 {
+		return arithmeticType(argTypes[0], argTypes[1])
+	}
+	return ""
+}
+
+// numericElemType returns the element type of an int[] or float[] first argument.
+func numericElemType(argTypes []string) string {
+	if len(argTypes) > 0 && (argTypes[0] == "int[]" || argTypes[0] == "float[]") {
+		return argTypes[0][:len(argTypes[0])-2]
+	}
+	return ""
+}
+
+// isNumeric reports whether t is int or float.
+func isNumeric(t string) bool {
+	return t == "int" || t == "float"
+}
+
+// arithmeticType returns the result type of +, -, * or / on two numeric
+// operands. An int mixed with a float is promoted to float.
+func arithmeticType(leftType, rightType string) string {
+	if !isNumeric(leftType) || !isNumeric(rightType) {
+		return ""
+	}
+	if leftType == "float" || rightType == "float" {
+		return "float"
+	}
+	return "int"
+}
+
+// compoundAssignable reports whether target op= operand keeps the target's
+// type: numbers of the same type (or a float target with an int operand), and
+// strings only for +=.
+func compoundAssignable(op token.TokenType, targetType, operandType string) bool {
+	if op == token.PLUS && targetType == "string" && operandType == "string" {
+		return true
+	}
+	return targetType != "" && arithmeticType(targetType, operandType) == targetType
 }
 
 // inferExprType returns the type (as a string) of an expression.
@@ -41,6 +240,8 @@ func inferExprType(expr ast.Expression, funcTypes map[string]string, varTypes ma
 		return "string"
 	case *ast.IntegerLiteral:
 		return "int"
+	case *ast.FloatLiteral:
+		return "float"
 	case *ast.BoolLiteral:
 		return "bool"
 	case *ast.Identifier:
@@ -48,21 +249,17 @@ func inferExprType(expr ast.Expression, funcTypes map[string]string, varTypes ma
 		if t, ok := varTypes[v.Value]; ok {
 			return t
 		}
-		// Fallback: if the identifier is qualified (e.g. "u.name")
+		// Fallback: if the identifier is qualified (e.g. "u.name" or
+		// "order.customer.address.city"), follow the fields from the variable
 		if strings.Contains(v.Value, ".") {
-			parts := strings.SplitN(v.Value, ".", 2)
-			baseName, fieldName := parts[0], parts[1]
-			if baseType, ok := varTypes[baseName]; ok {
-				if def, ok := structDefs[baseType]; ok {
-					for _, fld := range def.Fields {
-						if fld.Name == fieldName {
-							return fld.Type
-						}
-					}
+			parts := strings.Split(v.Value, ".")
+			for i := len(parts) - 1; i >= 1; i-- {
+				if baseType, ok := varTypes[strings.Join(parts[:i], ".")]; ok {
+					return fieldPathType(baseType, parts[i:], structDefs)
 				}
 			}
 			// Optionally try an unqualified lookup.
-			if t, ok := varTypes[fieldName]; ok {
+			if t, ok := varTypes[strings.Join(parts[1:], ".")]; ok {
 				return t
 			}
 		}
@@ -70,18 +267,49 @@ func inferExprType(expr ast.Expression, funcTypes map[string]string, varTypes ma
 	case *ast.BinaryExpression:
 		leftType := inferExprType(v.Left, funcTypes, varTypes, structDefs)
 		rightType := inferExprType(v.Right, funcTypes, varTypes, structDefs)
+		// Operator overloading: Vec + Vec dispatches to Vec.add
+		if _, isStruct := structDefs[leftType]; isStruct && leftType == rightType {
+			if methodName, ok := operatorMethods[v.Operator]; ok {
+				if ret, ok := funcTypes[leftType+"."+methodName]; ok {
+					if v.Operator == token.EQ || v.Operator == token.NEQ {
+						return "bool"
+					}
+					return ret
+				}
+			}
+		}
 		switch v.Operator {
-		case token.EQ, token.NEQ, token.LT, token.LTE, token.GT, token.GTE, token.AND, token.OR:
+		case token.EQ, token.NEQ, token.LT, token.LTE, token.GT, token.GTE:
 			return "bool"
+		case token.IN:
+			// x in xs, key in m, sub in s
+			if rightType == "string" && leftType == "string" {
+				return "bool"
+			}
+			if keyType, _, ok := mapTypes(rightType); ok {
+				if leftType == keyType {
+					return "bool"
+				}
+				return ""
+			}
+			if elem, ok := elemType(rightType); ok && (leftType == elem || elem == "any") {
+				return "bool"
+			}
+			return ""
+		case token.AND, token.OR:
+			// No truthiness: both operands must already be booleans
+			if leftType == "bool" && rightType == "bool" {
+				return "bool"
+			}
+			return ""
 		case token.PLUS:
 			if leftType == "string" && rightType == "string" {
 				return "string"
 			}
-			if leftType == "int" && rightType == "int" {
-				return "int"
-			}
-			return ""
-		case token.MINUS, token.ASTERISK, token.SLASH, token.MODULUS:
+			return arithmeticType(leftType, rightType)
+		case token.MINUS, token.ASTERISK, token.SLASH:
+			return arithmeticType(leftType, rightType)
+		case token.MODULUS:
 			if leftType == "int" && rightType == "int" {
 				return "int"
 			}
@@ -93,6 +321,13 @@ func inferExprType(expr ast.Expression, funcTypes map[string]string, varTypes ma
 		if v.Function != nil {
 			if ident, ok := v.Function.(*ast.Identifier); ok {
 
+				if generic, ok := genericBuiltins[ident.Value]; ok {
+					argTypes := make([]string, len(v.Arguments))
+					for i, arg := range v.Arguments {
+						argTypes[i] = inferExprType(arg, funcTypes, varTypes, structDefs)
+					}
+					return generic(argTypes)
+				}
 				if ret, ok := GoBuiltins[ident.Value]; ok {
 					return ret
 				}
@@ -114,6 +349,15 @@ func inferExprType(expr ast.Expression, funcTypes map[string]string, varTypes ma
 				if ret, ok := funcTypes[ident.Value]; ok {
 					return ret
 				}
+				// Calling a function value gives its return type; plain fnc
+				// values are untyped, so calling one gives any
+				calleeType := inferExprType(ident, funcTypes, varTypes, structDefs)
+				if calleeType == "fnc" {
+					return "any"
+				}
+				if _, ret, ok := funcTypeParts(calleeType); ok {
+					return ret
+				}
 				if ident.Value == "len" {
 					return "int"
 				}
@@ -126,9 +370,15 @@ func inferExprType(expr ast.Expression, funcTypes map[string]string, varTypes ma
 	case *ast.UnaryExpression:
 		switch v.Operator {
 		case token.MINUS:
-			return "int"
+			if rightType := inferExprType(v.Right, funcTypes, varTypes, structDefs); rightType == "int" || rightType == "float" {
+				return rightType
+			}
+			return ""
 		case token.NOT:
-			return "bool"
+			if inferExprType(v.Right, funcTypes, varTypes, structDefs) == "bool" {
+				return "bool"
+			}
+			return ""
 		default:
 			return ""
 		}
@@ -142,25 +392,36 @@ func inferExprType(expr ast.Expression, funcTypes map[string]string, varTypes ma
 				return "" // Mixed types error.
 			}
 		}
-		return elemType + "[]"
+		return arrayType(elemType)
 	case *ast.IndexExpression:
+		// The indexed value may come from anywhere, including a builtin call
+		// such as go.strings.split(s, ",")[0].
 		leftType := inferExprType(v.Left, funcTypes, varTypes, structDefs)
-		// Array indexing
-		if len(leftType) > 2 && leftType[len(leftType)-2:] == "[]" {
-			return leftType[:len(leftType)-2]
+		indexType := inferExprType(v.Index, funcTypes, varTypes, structDefs)
+		// Map indexing: map[keyType]valueType (checked first, as the value type may be an array)
+		if keyType, valueType, ok := mapTypes(leftType); ok {
+			if indexType != keyType {
+				return ""
+			}
+			return valueType
 		}
-		// Map indexing: map[keyType]valueType
-		if strings.HasPrefix(leftType, "map[") {
-			// Extract value type
-			closeBracket := strings.Index(leftType, "]")
-			if closeBracket != -1 && closeBracket+1 < len(leftType) {
-				return leftType[closeBracket+1:]
+		// Array indexing
+		if elem, ok := elemType(leftType); ok {
+			if indexType != "int" {
+				return ""
 			}
+			return elem
 		}
 		return ""
 	case *ast.SliceExpression:
+		// Bounds and step must all be ints when present
+		for _, bound := range []ast.Expression{v.Start, v.End, v.Step} {
+			if bound != nil && inferExprType(bound, funcTypes, varTypes, structDefs) != "int" {
+				return ""
+			}
+		}
 		leftType := inferExprType(v.Left, funcTypes, varTypes, structDefs)
-		if len(leftType) > 2 && leftType[len(leftType)-2:] == "[]" {
+		if _, ok := elemType(leftType); ok {
 			return leftType
 		}
 		return ""
@@ -170,17 +431,70 @@ func inferExprType(expr ast.Expression, funcTypes map[string]string, varTypes ma
 		// --- In inferExprType ---
 	case *ast.MapLiteral:
 		return fmt.Sprintf("map[%s]%s", v.KeyType, v.ValueType)
+	case *ast.FunctionLiteral:
+		return funcType(v.Fn)
+	case *ast.TernaryExpression:
+		// Both branches must have the type of the whole expression
+		if inferExprType(v.Cond, funcTypes, varTypes, structDefs) != "bool" {
+			return ""
+		}
+		thenType := inferExprType(v.Then, funcTypes, varTypes, structDefs)
+		if thenType != inferExprType(v.Else, funcTypes, varTypes, structDefs) {
+			return ""
+		}
+		return thenType
 	default:
 		return ""
 	}
 }
 
+// inferExpectedType is inferExprType for a value that must have type want: an
+// empty array literal, whose element type can't be inferred, takes want when
+// it is an array type.
+func inferExpectedType(expr ast.Expression, want string, funcTypes map[string]string, varTypes map[string]string, structDefs map[string]*ast.StructStatement) string {
+	if arr, ok := expr.(*ast.ArrayLiteral); ok {
+		if elem, isArray := elemType(want); isArray {
+			// Elements must have the wanted element type, so nested empty
+			// literals ([[1], []]) take it too
+			for _, el := range arr.Elements {
+				if !assignableType(elem, inferExpectedType(el, elem, funcTypes, varTypes, structDefs)) {
+					return inferExprType(expr, funcTypes, varTypes, structDefs)
+				}
+			}
+			return want
+		}
+	}
+	return inferExprType(expr, funcTypes, varTypes, structDefs)
+}
+
 // Check is the entry point for typechecking a program.
 func Check(stmts []ast.Statement) []error {
+	return checkProgram(stmts, nil)
+}
+
+// checkProgram is Check, appending the types it infers to types unless it is
+// nil.
+func checkProgram(stmts []ast.Statement, types *[]InferredType) []error {
 	funcTypes := map[string]string{}
 	funcDefs := map[string]*ast.FunctionStatement{}
 	structDefs := map[string]*ast.StructStatement{}
 	globalVars := map[string]string{}
+	aliases := map[string]*ast.TypeAliasStatement{}
+
+	// Aliases are replaced by the types they stand for before anything else
+	// looks at the program's types.
+	for _, s := range stmts {
+		switch st := s.(type) {
+		case *ast.TypeAliasStatement:
+			aliases[st.Name] = st
+		case *ast.StructStatement:
+			structDefs[st.Name] = st
+		}
+	}
+	var errs []error
+	if len(aliases) > 0 {
+		errs = resolveAliases(stmts, aliases, structDefs)
+	}
 
 	// First pass: register public functions, structs, and global let statements.
 	for _, s := range stmts {
@@ -188,195 +502,923 @@ func Check(stmts []ast.Statement) []error {
 		case *ast.FunctionStatement:
 			funcTypes[st.Name] = st.ReturnType
 			funcDefs[st.Name] = st
-		case *ast.StructStatement:
-			structDefs[st.Name] = st
+			// Functions are values too
+			if st.ReceiverType == "" {
+				globalVars[st.Name] = funcType(st)
+			}
 		case *ast.LetStatement:
 			globalVars[st.Name] = st.Type
+			if st.Const {
+				globalVars[constKey(st.Name)] = st.Type
+			}
 		}
 	}
 
 	// Merge global variables into varTypes and start typechecking the full AST.
-	return checkWithReturnType(stmts, "", funcTypes, funcDefs, globalVars, structDefs, false)
+	errs = append(errs, checkWithReturnType(stmts, "", funcTypes, funcDefs, globalVars, structDefs, false, types)...)
+	return dropCascades(errs)
 }
 
-// checkWithReturnType recursively typechecks statements with the current expected return type.
-func checkWithReturnType(
-	stmts []ast.Statement,
-	currentReturnType string,
-	funcTypes map[string]string,
-	funcDefs map[string]*ast.FunctionStatement,
-	varTypes map[string]string,
-	structDefs map[string]*ast.StructStatement,
-	inLoop bool,
-) []error {
+// resolveAliases replaces every type alias used in stmts by the type it
+// finally stands for, so the checker and the evaluator only ever see the
+// underlying types. Aliases that stand for themselves, directly or through
+// other aliases, are reported and left in place, as are aliases of unknown
+// types.
+func resolveAliases(stmts []ast.Statement, aliases map[string]*ast.TypeAliasStatement, structDefs map[string]*ast.StructStatement) []error {
 	var errs []error
+	resolved := map[string]string{}
+	visiting := map[string]bool{}
+	var resolve func(name string) string
+	resolve = func(name string) string {
+		alias, ok := aliases[name]
+		if !ok {
+			return name
+		}
+		if typ, ok := resolved[name]; ok {
+			return typ
+		}
+		if visiting[name] {
+			return name
+		}
+		visiting[name] = true
+		typ := mapTypeNames(alias.Type, resolve)
+		delete(visiting, name)
+		resolved[name] = typ
+		return typ
+	}
 
-	// Register nested functions.
-	for _, s := range stmts {
-		if fn, ok := s.(*ast.FunctionStatement); ok {
-			funcTypes[fn.Name] = fn.ReturnType
-			funcDefs[fn.Name] = fn
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		alias := aliases[name]
+		cyclic := false
+		typ := mapTypeNames(resolve(name), func(n string) string {
+			if aliases[n] != nil {
+				cyclic = true
+			}
+			return n
+		})
+		if _, isStruct := structDefs[name]; isStruct {
+			errs = append(errs, fmt.Errorf("'%s' is declared as both a struct and a type alias on line %d:%d", name, alias.Line, alias.Col))
+			delete(resolved, name)
+		} else if cyclic {
+			errs = append(errs, fmt.Errorf("Cyclic type alias '%s' on line %d:%d", name, alias.Line, alias.Col))
+			delete(resolved, name)
+		} else if unknown := unknownTypeName(typ, structDefs); unknown != "" {
+			errs = append(errs, fmt.Errorf("Unknown type '%s' in type alias '%s' on line %d:%d", unknown, name, alias.Line, alias.Col))
 		}
 	}
 
-	for _, s := range stmts {
-		switch stmt := s.(type) {
+	fix := func(t string) string {
+		return mapTypeNames(t, func(n string) string {
+			if typ, ok := resolved[n]; ok {
+				return typ
+			}
+			return n
+		})
+	}
+	fixFunction := func(fn *ast.FunctionStatement) {
+		for i, t := range fn.ParamTypes {
+			fn.ParamTypes[i] = fix(t)
+		}
+		fn.ReturnType = fix(fn.ReturnType)
+	}
+	ast.Walk(stmts, func(node interface{}) {
+		switch n := node.(type) {
+		case *ast.TypeAliasStatement:
+			if typ, ok := resolved[n.Name]; ok {
+				n.Type = typ
+			}
 		case *ast.LetStatement:
-			valType := inferExprType(stmt.Value, funcTypes, varTypes, structDefs)
-			if valType == "" {
-				errs = append(errs, fmt.Errorf("Error on line %d:%d: initialization of variable '%s' uses an undeclared or non‑public variable", stmt.Line, stmt.Col, stmt.Name))
+			n.Type = fix(n.Type)
+		case *ast.FunctionStatement:
+			fixFunction(n)
+		case *ast.StructStatement:
+			for i := range n.Fields {
+				n.Fields[i].Type = fix(n.Fields[i].Type)
 			}
-			varTypes[stmt.Name] = stmt.Type
-			if stmt.Type == "any" {
-				// Only allow non-array types
-				if len(valType) > 2 && valType[len(valType)-2:] == "[]" {
-					errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign array type %s to any (variable '%s')", stmt.Line, stmt.Col, valType, stmt.Name))
-				}
-			} else if stmt.Type == "any[]" {
-				// Only allow array types
-				if len(valType) <= 2 || valType[len(valType)-2:] != "[]" {
-					errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign non-array type %s to any[] (variable '%s')", stmt.Line, stmt.Col, valType, stmt.Name))
-				}
-			} else if valType != stmt.Type {
-				errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign %s to %s (variable '%s')", stmt.Line, stmt.Col, valType, stmt.Type, stmt.Name))
+		case *ast.FunctionLiteral:
+			fixFunction(n.Fn)
+		case *ast.MapLiteral:
+			n.KeyType = fix(n.KeyType)
+			n.ValueType = fix(n.ValueType)
+		case *ast.StructLiteral:
+			// An alias of a struct builds that struct
+			n.StructName = fix(n.StructName)
+		}
+	})
+	return errs
+}
+
+// mapTypeNames rebuilds type t with each type name in it, including those
+// inside array, map and function types, replaced by fn(name).
+func mapTypeNames(t string, fn func(name string) string) string {
+	if keyType, valueType, ok := mapTypes(t); ok {
+		return "map[" + mapTypeNames(keyType, fn) + "]" + mapTypeNames(valueType, fn)
+	}
+	if elem, ok := elemType(t); ok {
+		return arrayType(mapTypeNames(elem, fn))
+	}
+	if params, ret, ok := funcTypeParts(t); ok {
+		for i, param := range params {
+			if elem, variadic := strings.CutSuffix(param, "..."); variadic {
+				params[i] = mapTypeNames(elem, fn) + "..."
+			} else {
+				params[i] = mapTypeNames(param, fn)
 			}
+		}
+		return fmt.Sprintf("fnc(%s)>>%s", strings.Join(params, ","), mapTypeNames(ret, fn))
+	}
+	return fn(t)
+}
 
-			if mapLit, ok := stmt.Value.(*ast.MapLiteral); ok {
-				// Validate type string
-				expectedType := fmt.Sprintf("map[%s]%s", mapLit.KeyType, mapLit.ValueType)
-				if stmt.Type != expectedType {
-					errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign %s to %s (variable '%s')", stmt.Line, stmt.Col, expectedType, stmt.Type, stmt.Name))
+// InferredType is the type inferred for the value of a let, log, return or
+// expression statement. Type is "" when it couldn't be inferred.
+type InferredType struct {
+	Line int
+	Col  int
+	Kind string // "let", "log", "return" or "expr"
+	Name string // variable name of a let
+	Type string
+}
+
+// CheckTypes typechecks a program like Check and also returns the type
+// inferred for each let, log, return and expression statement, ordered by
+// position.
+func CheckTypes(stmts []ast.Statement) ([]error, []InferredType) {
+	var types []InferredType
+	errs := checkProgram(stmts, &types)
+	sort.SliceStable(types, func(i, j int) bool {
+		if types[i].Line != types[j].Line {
+			return types[i].Line < types[j].Line
+		}
+		return types[i].Col < types[j].Col
+	})
+	return errs, types
+}
+
+// recordType appends an inferred type to types unless it is nil.
+func recordType(types *[]InferredType, kind, name, typ string, line, col int) {
+	if types != nil {
+		*types = append(*types, InferredType{Line: line, Col: col, Kind: kind, Name: name, Type: typ})
+	}
+}
+
+// undeclaredError is an error caused by using names that aren't declared.
+// Only the first error for each name is reported: the rest would repeat it.
+type undeclaredError struct {
+	names []string
+	err   error
+}
+
+func (e *undeclaredError) Error() string { return e.err.Error() }
+
+// undeclared wraps err with the undeclared names used in expr, if any.
+func undeclared(err error, expr ast.Expression, funcTypes map[string]string, varTypes map[string]string, structDefs map[string]*ast.StructStatement) error {
+	var names []string
+	ast.Walk([]ast.Statement{&ast.ExpressionStatement{Expr: expr}}, func(node interface{}) {
+		ident, ok := node.(*ast.Identifier)
+		if !ok || strings.Contains(ident.Value, ".") {
+			return
+		}
+		if _, ok := varTypes[ident.Value]; ok {
+			return
+		}
+		if _, ok := funcTypes[ident.Value]; ok {
+			return
+		}
+		if _, ok := structDefs[ident.Value]; ok {
+			return
+		}
+		if ident.Value == "len" || ident.Value == "input" {
+			return
+		}
+		names = append(names, ident.Value)
+	})
+	if len(names) == 0 {
+		return err
+	}
+	return &undeclaredError{names: names, err: err}
+}
+
+// explainedError is an error caused by one reported before it, such as a
+// builtin rejecting its arguments. dropCascades drops it.
+type explainedError struct {
+	err error
+}
+
+func (e *explainedError) Error() string { return e.err.Error() }
+
+// untypedError explains why expr has no type. A mistyped ternary, an array
+// literal mixing element types or an index of the wrong type is reported as
+// such, and a builtin call with invalid arguments was already reported by
+// checkBuiltinArgs; otherwise err is returned, wrapped by undeclared.
+func untypedError(err error, expr ast.Expression, funcTypes map[string]string, varTypes map[string]string, structDefs map[string]*ast.StructStatement) error {
+	var typeErr error
+	ast.Walk([]ast.Statement{&ast.ExpressionStatement{Expr: expr}}, func(node interface{}) {
+		if typeErr != nil {
+			return
+		}
+		switch t := node.(type) {
+		case *ast.CallExpression:
+			ident, ok := t.Function.(*ast.Identifier)
+			if _, generic := genericBuiltins[ident.Value]; !ok || !generic {
+				return
+			}
+			// Arguments without a type are left to undeclared
+			for _, arg := range t.Arguments {
+				if inferExprType(arg, funcTypes, varTypes, structDefs) == "" {
+					return
 				}
-				// Validate all keys and values
-				for k, v := range mapLit.Pairs {
-					keyType := inferExprType(k, funcTypes, varTypes, structDefs)
-					valType := inferExprType(v, funcTypes, varTypes, structDefs)
-					if keyType != mapLit.KeyType {
-						errs = append(errs, fmt.Errorf("Map key type error on line %d:%d: expected %s, got %s", stmt.Line, stmt.Col, mapLit.KeyType, keyType))
-					}
-					if valType != mapLit.ValueType {
-						errs = append(errs, fmt.Errorf("Map value type error on line %d:%d: expected %s, got %s", stmt.Line, stmt.Col, mapLit.ValueType, valType))
-					}
+			}
+			if len(checkBuiltinArgs(ident.Value, t, funcTypes, varTypes, structDefs, t.Line, t.Col)) > 0 {
+				typeErr = &explainedError{err: err}
+			}
+		case *ast.TernaryExpression:
+			condType := inferExprType(t.Cond, funcTypes, varTypes, structDefs)
+			thenType := inferExprType(t.Then, funcTypes, varTypes, structDefs)
+			elseType := inferExprType(t.Else, funcTypes, varTypes, structDefs)
+			// Unknown types are left to undeclared
+			if condType != "" && condType != "bool" {
+				typeErr = fmt.Errorf("Type error on line %d:%d: condition of '?' must be bool, got %s", t.Line, t.Col, condType)
+			} else if thenType != "" && elseType != "" && thenType != elseType {
+				typeErr = fmt.Errorf("Type error on line %d:%d: branches of '?' have different types %s and %s", t.Line, t.Col, thenType, elseType)
+			}
+		case *ast.UnaryExpression:
+			// !x and not x
+			rightType := inferExprType(t.Right, funcTypes, varTypes, structDefs)
+			if t.Operator == token.NOT && rightType != "" && rightType != "bool" {
+				typeErr = fmt.Errorf("Type error on line %d:%d: logical not expects bool, got %s", t.Line, t.Col, rightType)
+			}
+		case *ast.IndexExpression:
+			leftType := inferExprType(t.Left, funcTypes, varTypes, structDefs)
+			indexType := inferExprType(t.Index, funcTypes, varTypes, structDefs)
+			if indexType == "" {
+				return
+			}
+			if keyType, _, ok := mapTypes(leftType); ok {
+				if indexType != keyType {
+					typeErr = fmt.Errorf("Map key type error on line %d:%d: expected %s, got %s", t.Line, t.Col, keyType, indexType)
+				}
+			} else if _, ok := elemType(leftType); ok && indexType != "int" {
+				typeErr = fmt.Errorf("Array index must be int, got %s on line %d:%d", indexType, t.Line, t.Col)
+			}
+		case *ast.ArrayLiteral:
+			if len(t.Elements) == 0 {
+				return
+			}
+			first := inferExprType(t.Elements[0], funcTypes, varTypes, structDefs)
+			for _, el := range t.Elements[1:] {
+				elType := inferExprType(el, funcTypes, varTypes, structDefs)
+				if first != "" && elType != "" && elType != first {
+					typeErr = fmt.Errorf("Array literal has mixed element types: %s and %s on line %d:%d", first, elType, t.Line, t.Col)
+					return
 				}
 			}
+		}
+	})
+	if typeErr != nil {
+		return typeErr
+	}
+	return undeclared(err, expr, funcTypes, varTypes, structDefs)
+}
 
-			// --- Struct literal field validation ---
-			if structLit, ok := stmt.Value.(*ast.StructLiteral); ok {
-				if def, ok := structDefs[structLit.StructName]; ok {
-					// Check for missing fields
-					for _, field := range def.Fields {
-						if _, exists := structLit.Fields[field.Name]; !exists {
-							errs = append(errs, fmt.Errorf("Missing field '%s' in struct literal for '%s' on line %d:%d", field.Name, structLit.StructName, stmt.Line, stmt.Col))
-						}
-					}
-					// Check for extra fields
-					for fieldName := range structLit.Fields {
-						found := false
-						for _, field := range def.Fields {
-							if field.Name == fieldName {
-								found = true
-								break
-							}
-						}
-						if !found {
-							errs = append(errs, fmt.Errorf("Unknown field '%s' in struct literal for '%s' on line %d:%d", fieldName, structLit.StructName, stmt.Line, stmt.Col))
-						}
-					}
+// dropCascades removes explained errors and errors whose undeclared names were
+// all reported by an earlier error.
+func dropCascades(errs []error) []error {
+	reported := map[string]bool{}
+	var kept []error
+	for _, err := range errs {
+		if _, ok := err.(*explainedError); ok {
+			continue
+		}
+		if u, ok := err.(*undeclaredError); ok {
+			fresh := false
+			for _, name := range u.names {
+				if !reported[name] {
+					reported[name] = true
+					fresh = true
 				}
 			}
-		case *ast.ExpressionStatement:
-			// If the expression is a CallExpression, typecheck its arguments via checkCallExpr.
-			if call, ok := stmt.Expr.(*ast.CallExpression); ok {
-				errs = append(errs, checkCallExpr(call, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+			if !fresh {
+				continue
 			}
-			exprType := inferExprType(stmt.Expr, funcTypes, varTypes, structDefs)
-			if exprType == "" {
-				errs = append(errs, fmt.Errorf("Error on line %d:%d: expression uses an undeclared or non‑public variable", stmt.Line, stmt.Col))
+		}
+		kept = append(kept, err)
+	}
+	return kept
+}
+
+// pureBuiltins are the builtins a @pure function may call: they neither do I/O
+// nor mutate their arguments.
+var pureBuiltins = map[string]bool{
+	"len":                  true,
+	"go.bytes.cap":         true,
+	"go.map.merge":         true,
+	"go.map.keys":          true,
+	"go.map.values":        true,
+	"go.map.has":           true,
+	"go.array.push":        true,
+	"go.array.pop":         true,
+	"go.array.append":      true,
+	"go.array.contains":    true,
+	"go.array.sort":        true,
+	"go.strings.replace":   true,
+	"go.strings.contains":  true,
+	"go.strings.indexOf":   true,
+	"go.strings.repeat":    true,
+	"go.strings.join":      true,
+	"go.strings.hasPrefix": true,
+	"go.strings.hasSuffix": true,
+	"go.strings.length":    true,
+	"go.regex.match":       true,
+	"go.regex.find":        true,
+	"go.regex.findAll":     true,
+	"go.math.abs":          true,
+	"go.math.min":          true,
+	"go.math.max":          true,
+	"go.math.pow":          true,
+	"go.math.sqrt":         true,
+	"go.math.floor":        true,
+	"go.math.ceil":         true,
+}
+
+// checkPurity reports side effects in the body of a @pure function: logging,
+// reading input, impure builtins, calls to functions not marked @pure and
+// assignments to variables it didn't declare.
+func checkPurity(fn *ast.FunctionStatement, funcDefs map[string]*ast.FunctionStatement) []error {
+	var errs []error
+	// Its locals are its parameters and whatever it declares; anything else
+	// an assignment reaches outlives the call
+	locals := map[string]bool{}
+	for _, param := range fn.Params {
+		locals[param] = true
+	}
+	ast.Walk(fn.Body, func(node interface{}) {
+		switch n := node.(type) {
+		case *ast.LetStatement:
+			locals[n.Name] = true
+		case *ast.WithStatement:
+			locals[n.Name] = true
+		case *ast.FunctionStatement:
+			for _, param := range n.Params {
+				locals[param] = true
+			}
+		case *ast.FunctionLiteral:
+			for _, param := range n.Fn.Params {
+				locals[param] = true
+			}
+		}
+	})
+	ast.Walk(fn.Body, func(node interface{}) {
+		switch n := node.(type) {
+		case *ast.AssignmentStatement:
+			if name := assignedVar(n); !locals[name] {
+				errs = append(errs, fmt.Errorf("Pure function '%s' cannot assign to '%s', which it didn't declare, on line %d:%d", fn.Name, name, n.Line, n.Col))
 			}
 		case *ast.LogFunction:
-			exprType := inferExprType(stmt.Value, funcTypes, varTypes, structDefs)
-			if exprType == "" {
-				errs = append(errs, fmt.Errorf("Error on line %d:%d: log expression uses an undeclared or non‑public variable", stmt.Line, stmt.Col))
+			errs = append(errs, fmt.Errorf("Pure function '%s' cannot log on line %d:%d", fn.Name, n.Line, n.Col))
+		case *ast.CallExpression:
+			ident, ok := n.Function.(*ast.Identifier)
+			if !ok {
+				return
 			}
-		case *ast.FunctionStatement:
-			// Check that the return type is valid (built-in or declared struct)
-			builtin := stmt.ReturnType == "int" || stmt.ReturnType == "string" || stmt.ReturnType == "bool" || stmt.ReturnType == "void"
-			if !builtin {
-				if _, ok := structDefs[stmt.ReturnType]; !ok {
-					errs = append(errs, fmt.Errorf("Unknown return type '%s' for function '%s' on line %d:%d", stmt.ReturnType, stmt.Name, stmt.Line, stmt.Col))
+			name := ident.Value
+			if def, ok := funcDefs[name]; ok {
+				if !def.Pure {
+					errs = append(errs, fmt.Errorf("Pure function '%s' cannot call non-pure function '%s' on line %d:%d", fn.Name, name, ident.Line, ident.Col))
 				}
+				return
 			}
-			// Create a new scope for the function body.
-			funcVarTypes := make(map[string]string)
-			for k, v := range varTypes {
-				funcVarTypes[k] = v
+			if pureBuiltins[name] || strings.HasPrefix(name, "go.strings.") || strings.HasPrefix(name, "go.math.") {
+				return
 			}
-			for i, param := range stmt.Params {
-				funcVarTypes[param] = stmt.ParamTypes[i]
-			}
-			errs = append(errs, checkWithReturnType(stmt.Body, stmt.ReturnType, funcTypes, funcDefs, funcVarTypes, structDefs, false)...)
-		case *ast.ReturnStatement:
-			if currentReturnType == "void" {
-				if stmt.Value != nil {
-					if _, ok := stmt.Value.(*ast.NilLiteral); !ok {
-						errs = append(errs, fmt.Errorf("Cannot return a value from a void function (line %d:%d)", stmt.Line, stmt.Col))
-					}
-				}
-			} else {
-				if stmt.Value == nil {
-					errs = append(errs, fmt.Errorf("Must return a value from non-void function (line %d:%d)", stmt.Line, stmt.Col))
-				} else {
-					valType := inferExprType(stmt.Value, funcTypes, varTypes, structDefs)
-					if valType != currentReturnType {
-						errs = append(errs, fmt.Errorf("Return type mismatch on line %d:%d: expected %s, got %s", stmt.Line, stmt.Col, currentReturnType, valType))
-					}
-				}
+			if _, ok := GoBuiltins[name]; ok || name == "input" {
+				errs = append(errs, fmt.Errorf("Pure function '%s' cannot call '%s' on line %d:%d", fn.Name, name, ident.Line, ident.Col))
 			}
-		case *ast.AssignmentStatement:
-			// Field assignment: u.name >> ...
-			if ident, ok := stmt.Left.(*ast.Identifier); ok && strings.Contains(ident.Value, ".") {
-				// ...field assignment logic...
-			} else if idxExpr, ok := stmt.Left.(*ast.IndexExpression); ok {
-				// Array or map mutation: xs[0] >> v or m["a"] >> v
-				collectionType := inferExprType(idxExpr.Left, funcTypes, varTypes, structDefs)
-				indexType := inferExprType(idxExpr.Index, funcTypes, varTypes, structDefs)
-				valType := inferExprType(stmt.Value, funcTypes, varTypes, structDefs)
-				// Array mutation
-				if strings.HasSuffix(collectionType, "[]") {
-					elemType := collectionType[:len(collectionType)-2]
-					if indexType != "int" {
-						errs = append(errs, fmt.Errorf("Array index must be int, got %s on line %d:%d", indexType, stmt.Line, stmt.Col))
-					}
-					if valType != elemType {
-						errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign %s to %s[] element", stmt.Line, stmt.Col, valType, elemType))
-					}
-				} else if strings.HasPrefix(collectionType, "map[") {
+		}
+	})
+	return errs
+}
+
+// assignedVar returns the variable an assignment changes: xs for xs[0] >> 1,
+// u for u.name >> "x".
+func assignedVar(stmt *ast.AssignmentStatement) string {
+	target := stmt.Left
+	for {
+		index, ok := target.(*ast.IndexExpression)
+		if !ok {
+			break
+		}
+		target = index.Left
+	}
+	ident, ok := target.(*ast.Identifier)
+	if !ok {
+		return stmt.Name
+	}
+	name, _, _ := strings.Cut(ident.Value, ".")
+	return name
+}
+
+// operatorMethods are the struct methods overloadable operators dispatch to;
+// see operatorMethods in the evaluator.
+var operatorMethods = map[token.TokenType]string{
+	token.PLUS:  "add",
+	token.MINUS: "sub",
+	token.EQ:    "eq",
+	token.NEQ:   "eq",
+}
+
+// withResources are the builtins whose result a with statement can close;
+// see resourceClosers in the evaluator.
+var withResources = map[string]bool{
+	"go.file.open":   true,
+	"go.file.create": true,
+}
+
+// checkFunctionLiterals typechecks the bodies of the function literals in
+// exprs, wherever they're nested. Each body sees the variables of the
+// enclosing scope plus its own parameters; literals nested in a body are
+// checked with it.
+func checkFunctionLiterals(
+	exprs []ast.Expression,
+	funcTypes map[string]string,
+	funcDefs map[string]*ast.FunctionStatement,
+	varTypes map[string]string,
+	structDefs map[string]*ast.StructStatement,
+	types *[]InferredType,
+) []error {
+	var errs []error
+	inLiteral := map[*ast.FunctionLiteral]bool{}
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		ast.Walk([]ast.Statement{&ast.ExpressionStatement{Expr: expr}}, func(node interface{}) {
+			e, ok := node.(*ast.FunctionLiteral)
+			if !ok || inLiteral[e] {
+				return
+			}
+			// Parents are visited first, so the literals in its body are
+			// marked before the walk reaches them
+			ast.Walk(e.Fn.Body, func(inner interface{}) {
+				if lit, ok := inner.(*ast.FunctionLiteral); ok {
+					inLiteral[lit] = true
+				}
+			})
+			errs = append(errs, checkDefaults(e.Fn, funcTypes, varTypes, structDefs)...)
+			if e.Fn.ReturnType != "void" && !returnsOnAllPaths(e.Fn.Body) {
+				errs = append(errs, fmt.Errorf("Function literal may not return a value on all paths (line %d:%d)", e.Line, e.Col))
+			}
+			scope := copyVarTypes(varTypes)
+			for i, param := range e.Fn.Params {
+				scope[param] = e.Fn.ParamTypes[i]
+				delete(scope, constKey(param))
+			}
+			bodyTypes, bodyDefs := hideFunctions(e.Fn.Params, funcTypes, funcDefs)
+			errs = append(errs, checkWithReturnType(e.Fn.Body, e.Fn.ReturnType, bodyTypes, bodyDefs, scope, structDefs, false, types)...)
+		})
+	}
+	return errs
+}
+
+// interpolationRe and formatSpecRe are the placeholder and spec syntax of the
+// evaluator's interpolateString and formatWithSpec.
+var (
+	interpolationRe = regexp.MustCompile(`<%([^%>]+)%>`)
+	formatSpecRe    = regexp.MustCompile(`^[-+# 0]*[0-9]*(\.[0-9]+)?[dxXobfeEgGstv]$`)
+)
+
+// checkFormatSpecs checks the specs of the placeholders in the string literals
+// of exprs against the values they format: <%price:.2f%> needs an int or float
+// price. Literals in the body of a function literal are checked with the body.
+func checkFormatSpecs(
+	exprs []ast.Expression,
+	funcTypes map[string]string,
+	varTypes map[string]string,
+	structDefs map[string]*ast.StructStatement,
+) []error {
+	var errs []error
+	inLiteral := map[*ast.StringLiteral]bool{}
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		ast.Walk([]ast.Statement{&ast.ExpressionStatement{Expr: expr}}, func(node interface{}) {
+			switch n := node.(type) {
+			case *ast.FunctionLiteral:
+				ast.Walk(n.Fn.Body, func(inner interface{}) {
+					if lit, ok := inner.(*ast.StringLiteral); ok {
+						inLiteral[lit] = true
+					}
+				})
+			case *ast.StringLiteral:
+				if n.Raw || inLiteral[n] {
+					return
+				}
+				for _, m := range interpolationRe.FindAllStringSubmatch(n.Value, -1) {
+					name, spec, hasSpec := strings.Cut(strings.TrimSpace(m[1]), ":")
+					if !hasSpec {
+						continue
+					}
+					name, spec = strings.TrimSpace(name), strings.TrimSpace(spec)
+					if !formatSpecRe.MatchString(spec) {
+						errs = append(errs, fmt.Errorf("Invalid format spec '%s' for '%s' on line %d:%d", spec, name, n.Line, n.Col))
+						continue
+					}
+					valType := inferExprType(&ast.Identifier{Value: name, Type: token.IDENT}, funcTypes, varTypes, structDefs)
+					if valType != "" && valType != "any" && !formatVerbFits(spec[len(spec)-1], valType) {
+						errs = append(errs, fmt.Errorf("Format spec '%s' does not fit %s '%s' on line %d:%d", spec, valType, name, n.Line, n.Col))
+					}
+				}
+			}
+		})
+	}
+	return errs
+}
+
+// formatVerbFits reports whether a format verb can format a value of type t.
+// Ints are accepted by the float verbs, and s and v format anything.
+func formatVerbFits(verb byte, t string) bool {
+	switch verb {
+	case 'd', 'x', 'X', 'o', 'b':
+		return t == "int"
+	case 'f', 'e', 'E', 'g', 'G':
+		return t == "int" || t == "float"
+	case 't':
+		return t == "bool"
+	}
+	return true
+}
+
+// statementExprs returns the expressions of stmt itself. Those in its nested
+// blocks are left to the check of the block, which has the block's scope; so
+// is a for loop's condition, which sees the loop variable.
+func statementExprs(stmt ast.Statement) []ast.Expression {
+	switch st := stmt.(type) {
+	case *ast.LetStatement:
+		return []ast.Expression{st.Value}
+	case *ast.AssignmentStatement:
+		return []ast.Expression{st.Left, st.Value}
+	case *ast.ExpressionStatement:
+		return []ast.Expression{st.Expr}
+	case *ast.ReturnStatement:
+		return []ast.Expression{st.Value}
+	case *ast.LogFunction:
+		return []ast.Expression{st.Value}
+	case *ast.AssertStatement:
+		return []ast.Expression{st.Cond, st.Message}
+	case *ast.IfStatement:
+		return append([]ast.Expression{st.IfCond}, st.ElifConds...)
+	case *ast.MatchStatement:
+		return append([]ast.Expression{st.Subject}, st.Cases...)
+	case *ast.WhileStatement:
+		return []ast.Expression{st.Condition}
+	case *ast.WithStatement:
+		return []ast.Expression{st.Value}
+	}
+	return nil
+}
+
+// constKey is the varTypes entry marking name as a const. It can't clash
+// with a variable, and is scoped like one.
+func constKey(name string) string {
+	return "const " + name
+}
+
+// isConstant reports whether expr can be worked out without running the
+// program: literals, other consts, and operators applied to those.
+func isConstant(expr ast.Expression, varTypes map[string]string) bool {
+	switch e := expr.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.BoolLiteral, *ast.NilLiteral:
+		return true
+	case *ast.StringLiteral:
+		// Interpolation reads variables
+		return e.Raw || !strings.Contains(e.Value, "<%")
+	case *ast.Identifier:
+		_, ok := varTypes[constKey(e.Value)]
+		return ok
+	case *ast.UnaryExpression:
+		return isConstant(e.Right, varTypes)
+	case *ast.BinaryExpression:
+		return isConstant(e.Left, varTypes) && isConstant(e.Right, varTypes)
+	case *ast.TernaryExpression:
+		return isConstant(e.Cond, varTypes) && isConstant(e.Then, varTypes) && isConstant(e.Else, varTypes)
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			if !isConstant(el, varTypes) {
+				return false
+			}
+		}
+		return true
+	case *ast.MapLiteral:
+		if len(e.Spreads) > 0 {
+			return false
+		}
+		for k, v := range e.Pairs {
+			if !isConstant(k, varTypes) || !isConstant(v, varTypes) {
+				return false
+			}
+		}
+		return true
+	case *ast.StructLiteral:
+		for _, v := range e.Fields {
+			if !isConstant(v, varTypes) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// checkWithReturnType recursively typechecks statements with the current expected return type.
+func checkWithReturnType(
+	stmts []ast.Statement,
+	currentReturnType string,
+	funcTypes map[string]string,
+	funcDefs map[string]*ast.FunctionStatement,
+	varTypes map[string]string,
+	structDefs map[string]*ast.StructStatement,
+	inLoop bool,
+	types *[]InferredType,
+) []error {
+	errs := checkReachable(stmts)
+
+	// Register nested functions. Like at run time they're only visible in the
+	// block declaring them, so they go in copies of the maps.
+	copied := false
+	for _, s := range stmts {
+		if fn, ok := s.(*ast.FunctionStatement); ok {
+			if !copied {
+				funcTypes, funcDefs = copyFuncs(funcTypes, funcDefs)
+				copied = true
+			}
+			funcTypes[fn.Name] = fn.ReturnType
+			funcDefs[fn.Name] = fn
+			if fn.ReceiverType == "" {
+				varTypes[fn.Name] = funcType(fn)
+			}
+		}
+	}
+
+	for _, s := range stmts {
+		errs = append(errs, checkFunctionLiterals(statementExprs(s), funcTypes, funcDefs, varTypes, structDefs, types)...)
+		errs = append(errs, checkFormatSpecs(statementExprs(s), funcTypes, varTypes, structDefs)...)
+		switch stmt := s.(type) {
+		case *ast.LetStatement:
+			errs = append(errs, checkCalls(stmt.Value, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+			valType := inferExpectedType(stmt.Value, stmt.Type, funcTypes, varTypes, structDefs)
+			recordType(types, "let", stmt.Name, valType, stmt.Line, stmt.Col)
+			if valType == "" {
+				err := fmt.Errorf("Error on line %d:%d: initialization of variable '%s' uses an undeclared or non‑public variable", stmt.Line, stmt.Col, stmt.Name)
+				errs = append(errs, untypedError(err, stmt.Value, funcTypes, varTypes, structDefs))
+			}
+			varTypes[stmt.Name] = stmt.Type
+			if stmt.Const {
+				varTypes[constKey(stmt.Name)] = stmt.Type
+				if !isConstant(stmt.Value, varTypes) {
+					errs = append(errs, fmt.Errorf("Error on line %d:%d: const '%s' must be initialized with a constant expression", stmt.Line, stmt.Col, stmt.Name))
+				}
+			} else {
+				// A let shadows a const of the same name
+				delete(varTypes, constKey(stmt.Name))
+			}
+			if valType == "" {
+				// Already reported: a type mismatch would only repeat it
+			} else if stmt.Type == "any" {
+				// Only allow non-array types
+				if len(valType) > 2 && valType[len(valType)-2:] == "[]" {
+					errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign array type %s to any (variable '%s')", stmt.Line, stmt.Col, valType, stmt.Name))
+				}
+			} else if stmt.Type == "any[]" {
+				// Only allow array types
+				if len(valType) <= 2 || valType[len(valType)-2:] != "[]" {
+					errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign non-array type %s to any[] (variable '%s')", stmt.Line, stmt.Col, valType, stmt.Name))
+				}
+			} else if !assignableType(stmt.Type, valType) {
+				errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign %s to %s (variable '%s')", stmt.Line, stmt.Col, valType, stmt.Type, stmt.Name))
+			}
+
+			if mapLit, ok := stmt.Value.(*ast.MapLiteral); ok {
+				// Validate type string
+				expectedType := fmt.Sprintf("map[%s]%s", mapLit.KeyType, mapLit.ValueType)
+				if stmt.Type != expectedType {
+					errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign %s to %s (variable '%s')", stmt.Line, stmt.Col, expectedType, stmt.Type, stmt.Name))
+				}
+				// Spread maps must have the literal's own map type
+				for _, spread := range mapLit.Spreads {
+					if spreadType := inferExprType(spread, funcTypes, varTypes, structDefs); spreadType != expectedType {
+						errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot spread %s into %s literal", stmt.Line, stmt.Col, spreadType, expectedType))
+					}
+				}
+				// Validate all keys and values
+				for k, v := range mapLit.Pairs {
+					keyType := inferExprType(k, funcTypes, varTypes, structDefs)
+					valType := inferExprType(v, funcTypes, varTypes, structDefs)
+					if keyType != mapLit.KeyType {
+						errs = append(errs, fmt.Errorf("Map key type error on line %d:%d: expected %s, got %s", stmt.Line, stmt.Col, mapLit.KeyType, keyType))
+					}
+					if valType != mapLit.ValueType {
+						errs = append(errs, fmt.Errorf("Map value type error on line %d:%d: expected %s, got %s", stmt.Line, stmt.Col, mapLit.ValueType, valType))
+					}
+				}
+			}
+
+			// --- Struct literal field validation ---
+			errs = append(errs, checkStructLiterals(stmt.Value, funcTypes, varTypes, structDefs)...)
+		case *ast.ExpressionStatement:
+			errs = append(errs, checkCalls(stmt.Expr, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+			errs = append(errs, checkStructLiterals(stmt.Expr, funcTypes, varTypes, structDefs)...)
+			exprType := inferExprType(stmt.Expr, funcTypes, varTypes, structDefs)
+			recordType(types, "expr", "", exprType, stmt.Line, stmt.Col)
+			if exprType == "" {
+				err := fmt.Errorf("Error on line %d:%d: expression uses an undeclared or non‑public variable", stmt.Line, stmt.Col)
+				errs = append(errs, untypedError(err, stmt.Expr, funcTypes, varTypes, structDefs))
+			}
+		case *ast.LogFunction:
+			errs = append(errs, checkCalls(stmt.Value, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+			errs = append(errs, checkStructLiterals(stmt.Value, funcTypes, varTypes, structDefs)...)
+			exprType := inferExprType(stmt.Value, funcTypes, varTypes, structDefs)
+			recordType(types, "log", "", exprType, stmt.Line, stmt.Col)
+			if _, isNil := stmt.Value.(*ast.NilLiteral); exprType == "" && !isNil {
+				err := fmt.Errorf("Error on line %d:%d: log expression uses an undeclared or non‑public variable", stmt.Line, stmt.Col)
+				errs = append(errs, untypedError(err, stmt.Value, funcTypes, varTypes, structDefs))
+			}
+		case *ast.FunctionStatement:
+			bodyDefs := funcDefs
+			if stmt.Override {
+				// An override keeps the builtin's return type, and calls to the
+				// builtin from its own body reach the builtin
+				if ret, ok := GoBuiltins[stmt.Name]; !ok {
+					errs = append(errs, fmt.Errorf("Cannot override '%s' on line %d:%d: not a builtin", stmt.Name, stmt.Line, stmt.Col))
+				} else if ret != stmt.ReturnType {
+					errs = append(errs, fmt.Errorf("Override of '%s' must return %s, not %s on line %d:%d", stmt.Name, ret, stmt.ReturnType, stmt.Line, stmt.Col))
+				}
+				bodyDefs = make(map[string]*ast.FunctionStatement, len(funcDefs))
+				for name, def := range funcDefs {
+					if name != stmt.Name {
+						bodyDefs[name] = def
+					}
+				}
+			} else {
+				// Check that the return type is valid: built-in or declared
+				// structs, possibly inside arrays and maps
+				if name := unknownTypeName(stmt.ReturnType, structDefs); stmt.ReturnType == "void" {
+					// Nothing to check
+				} else if name == stmt.ReturnType {
+					errs = append(errs, fmt.Errorf("Unknown return type '%s' for function '%s' on line %d:%d", stmt.ReturnType, stmt.Name, stmt.Line, stmt.Col))
+				} else if name != "" {
+					errs = append(errs, fmt.Errorf("Unknown type '%s' in return type '%s' for function '%s' on line %d:%d", name, stmt.ReturnType, stmt.Name, stmt.Line, stmt.Col))
+				}
+			}
+			// == and != use the result of eq as their own
+			if stmt.ReceiverType != "" && stmt.Name == stmt.ReceiverType+".eq" && stmt.ReturnType != "bool" {
+				errs = append(errs, fmt.Errorf("Method '%s' overloads == and must return bool, not %s on line %d:%d", stmt.Name, stmt.ReturnType, stmt.Line, stmt.Col))
+			}
+			if stmt.Name == "init" && stmt.ReceiverType == "" {
+				if len(stmt.Params) > 0 || stmt.ReturnType != "void" {
+					errs = append(errs, fmt.Errorf("Function 'init' must take no parameters and return void on line %d:%d", stmt.Line, stmt.Col))
+				}
+			}
+			if stmt.Pure {
+				errs = append(errs, checkPurity(stmt, funcDefs)...)
+			}
+			errs = append(errs, checkDefaults(stmt, funcTypes, varTypes, structDefs)...)
+			if stmt.ReturnType != "void" && !returnsOnAllPaths(stmt.Body) {
+				errs = append(errs, fmt.Errorf("Function '%s' may not return a value on all paths (line %d:%d)", stmt.Name, stmt.Line, stmt.Col))
+			}
+			// Create a new scope for the function body.
+			funcVarTypes := make(map[string]string)
+			for k, v := range varTypes {
+				funcVarTypes[k] = v
+			}
+			for i, param := range stmt.Params {
+				funcVarTypes[param] = stmt.ParamTypes[i]
+				// A parameter shadows a const of the same name
+				delete(funcVarTypes, constKey(param))
+			}
+			// Methods see their receiver as this
+			if stmt.ReceiverType != "" {
+				funcVarTypes["this"] = stmt.ReceiverType
+			}
+			bodyTypes, bodyDefs := hideFunctions(stmt.Params, funcTypes, bodyDefs)
+			errs = append(errs, checkWithReturnType(stmt.Body, stmt.ReturnType, bodyTypes, bodyDefs, funcVarTypes, structDefs, false, types)...)
+		case *ast.ReturnStatement:
+			if currentReturnType == "void" {
+				if stmt.Value != nil {
+					if _, ok := stmt.Value.(*ast.NilLiteral); !ok {
+						errs = append(errs, fmt.Errorf("Cannot return a value from a void function (line %d:%d)", stmt.Line, stmt.Col))
+					}
+				}
+			} else {
+				if stmt.Value == nil {
+					errs = append(errs, fmt.Errorf("Must return a value from non-void function (line %d:%d)", stmt.Line, stmt.Col))
+				} else {
+					errs = append(errs, checkCalls(stmt.Value, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+					valType := inferExpectedType(stmt.Value, currentReturnType, funcTypes, varTypes, structDefs)
+					recordType(types, "return", "", valType, stmt.Line, stmt.Col)
+					errs = append(errs, checkStructLiterals(stmt.Value, funcTypes, varTypes, structDefs)...)
+					if !assignableType(currentReturnType, valType) {
+						errs = append(errs, fmt.Errorf("Return type mismatch on line %d:%d: expected %s, got %s", stmt.Line, stmt.Col, currentReturnType, valType))
+					}
+				}
+			}
+		case *ast.AssignmentStatement:
+			// x += e was parsed as x >> x + e, so the value holds the target
+			if stmt.Compound == "" {
+				errs = append(errs, checkCalls(stmt.Left, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+			}
+			errs = append(errs, checkCalls(stmt.Value, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+			errs = append(errs, checkStructLiterals(stmt.Value, funcTypes, varTypes, structDefs)...)
+			// A const's elements and fields can't be changed either:
+			// limits[0] >> 99 or cfg.name >> "x"
+			if left, ok := stmt.Left.(*ast.Identifier); !ok || strings.Contains(left.Value, ".") {
+				root := assignedVar(stmt)
+				if _, isConst := varTypes[constKey(root)]; isConst {
+					errs = append(errs, fmt.Errorf("Cannot modify const '%s' on line %d:%d", root, stmt.Line, stmt.Col))
+					continue
+				}
+			}
+			// Compound assignment: x += e was parsed as x >> x + e
+			if bin, ok := stmt.Value.(*ast.BinaryExpression); ok && stmt.Compound != "" {
+				targetType := inferExprType(bin.Left, funcTypes, varTypes, structDefs)
+				operandType := inferExprType(bin.Right, funcTypes, varTypes, structDefs)
+				if !compoundAssignable(stmt.Compound, targetType, operandType) {
+					errs = append(errs, fmt.Errorf("Type error on line %d:%d: operator '%s=' not defined for %s and %s", stmt.Line, stmt.Col, stmt.Compound, targetType, operandType))
+					continue
+				}
+			}
+			// Field assignment: u.name >> ... or order.customer.name >> ...
+			if ident, ok := stmt.Left.(*ast.Identifier); ok && strings.Contains(ident.Value, ".") {
+				fieldType := inferExprType(ident, funcTypes, varTypes, structDefs)
+				valType := inferExpectedType(stmt.Value, fieldType, funcTypes, varTypes, structDefs)
+				if fieldType == "" {
+					err := fmt.Errorf("Assignment to unknown field or variable '%s' on line %d:%d", ident.Value, stmt.Line, stmt.Col)
+					errs = append(errs, undeclared(err, ident, funcTypes, varTypes, structDefs))
+				} else if valType == "" {
+					err := fmt.Errorf("Error on line %d:%d: assignment of '%s' uses an undeclared or non‑public variable", stmt.Line, stmt.Col, ident.Value)
+					errs = append(errs, untypedError(err, stmt.Value, funcTypes, varTypes, structDefs))
+				} else if fieldType != "any" && !assignableType(fieldType, valType) {
+					errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign %s to %s (field '%s')", stmt.Line, stmt.Col, valType, fieldType, ident.Value))
+				}
+			} else if idxExpr, ok := stmt.Left.(*ast.IndexExpression); ok {
+				// Array or map mutation: xs[0] >> v or m["a"] >> v
+				collectionType := inferExprType(idxExpr.Left, funcTypes, varTypes, structDefs)
+				indexType := inferExprType(idxExpr.Index, funcTypes, varTypes, structDefs)
+				wantType, _ := elemType(collectionType)
+				if _, valueType, ok := mapTypes(collectionType); ok {
+					wantType = valueType
+				}
+				valType := inferExpectedType(stmt.Value, wantType, funcTypes, varTypes, structDefs)
+				if valType == "" {
+					err := fmt.Errorf("Error on line %d:%d: assignment uses an undeclared or non‑public variable", stmt.Line, stmt.Col)
+					errs = append(errs, untypedError(err, stmt.Value, funcTypes, varTypes, structDefs))
+				}
+				if collectionType == "" {
+					// e.g. m[1]["a"] >> v with a bad key in m[1]
+					err := fmt.Errorf("Error on line %d:%d: assignment target uses an undeclared or non‑public variable", stmt.Line, stmt.Col)
+					errs = append(errs, untypedError(err, idxExpr.Left, funcTypes, varTypes, structDefs))
+				} else if strings.HasPrefix(collectionType, "map[") {
 					// Map mutation
-					closeBracket := strings.Index(collectionType, "]")
-					if closeBracket == -1 {
+					keyType, valueType, ok := mapTypes(collectionType)
+					if !ok {
 						errs = append(errs, fmt.Errorf("Malformed map type '%s' on line %d:%d", collectionType, stmt.Line, stmt.Col))
 					} else {
-						keyType := collectionType[4:closeBracket]
-						valueType := collectionType[closeBracket+1:]
 						if indexType != keyType {
-							errs = append(errs, fmt.Errorf("Map key type error on line %d:%d: expected %s, got %s", stmt.Line, stmt.Col, keyType, indexType))
+							errs = append(errs, fmt.Errorf("Map key type error on line %d:%d: expected %s, got %s", idxExpr.Line, idxExpr.Col, keyType, indexType))
 						}
-						if valType != valueType {
+						if valType != "" && !assignableType(valueType, valType) {
 							errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign %s to %s (map value)", stmt.Line, stmt.Col, valType, valueType))
 						}
 					}
+				} else if elem, ok := elemType(collectionType); ok {
+					// Array mutation
+					if indexType != "int" {
+						errs = append(errs, fmt.Errorf("Array index must be int, got %s on line %d:%d", indexType, stmt.Line, stmt.Col))
+					}
+					if valType != "" && !assignableType(elem, valType) {
+						errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign %s to %s[] element", stmt.Line, stmt.Col, valType, elem))
+					}
 				} else {
 					errs = append(errs, fmt.Errorf("Assignment target is not an array or map on line %d:%d", stmt.Line, stmt.Col))
 				}
 			} else {
 				// Normal assignment: variable must be declared.
 				if _, ok := varTypes[stmt.Name]; !ok {
-					errs = append(errs, fmt.Errorf("Assignment to undeclared variable '%s' on line %d:%d", stmt.Name, stmt.Line, stmt.Col))
+					err := fmt.Errorf("Assignment to undeclared variable '%s' on line %d:%d", stmt.Name, stmt.Line, stmt.Col)
+					errs = append(errs, &undeclaredError{names: []string{stmt.Name}, err: err})
+				} else if _, ok := varTypes[constKey(stmt.Name)]; ok {
+					errs = append(errs, fmt.Errorf("Cannot assign to const '%s' on line %d:%d", stmt.Name, stmt.Line, stmt.Col))
 				} else {
 					expectedType := varTypes[stmt.Name]
-					valType := inferExprType(stmt.Value, funcTypes, varTypes, structDefs)
+					valType := inferExpectedType(stmt.Value, expectedType, funcTypes, varTypes, structDefs)
 					if valType == "" {
-						errs = append(errs, fmt.Errorf("Error on line %d:%d: assignment of variable '%s' uses an undeclared or non‑public variable", stmt.Line, stmt.Col, stmt.Name))
+						err := fmt.Errorf("Error on line %d:%d: assignment of variable '%s' uses an undeclared or non‑public variable", stmt.Line, stmt.Col, stmt.Name)
+						errs = append(errs, untypedError(err, stmt.Value, funcTypes, varTypes, structDefs))
 					} else if expectedType == "any" {
 						// Only allow non-array types
 						if len(valType) > 2 && valType[len(valType)-2:] == "[]" {
@@ -387,11 +1429,24 @@ func checkWithReturnType(
 						if len(valType) <= 2 || valType[len(valType)-2:] != "[]" {
 							errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign non-array type %s to any[] (variable '%s')", stmt.Line, stmt.Col, valType, stmt.Name))
 						}
-					} else if valType != expectedType {
+					} else if !assignableType(expectedType, valType) {
 						errs = append(errs, fmt.Errorf("Type error on line %d:%d: cannot assign %s to %s (variable '%s')", stmt.Line, stmt.Col, valType, expectedType, stmt.Name))
 					}
 				}
 			}
+		case *ast.AssertStatement:
+			errs = append(errs, checkCalls(stmt.Cond, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+			errs = append(errs, checkCalls(stmt.Message, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+			condType := inferExprType(stmt.Cond, funcTypes, varTypes, structDefs)
+			if condType != "bool" {
+				errs = append(errs, fmt.Errorf("Assert condition must be boolean, got %s on line %d:%d", condType, stmt.Line, stmt.Col))
+			}
+			if stmt.Message != nil {
+				msgType := inferExprType(stmt.Message, funcTypes, varTypes, structDefs)
+				if msgType != "string" {
+					errs = append(errs, fmt.Errorf("Assert message must be string, got %s on line %d:%d", msgType, stmt.Line, stmt.Col))
+				}
+			}
 		case *ast.BreakStatement:
 			if !inLoop {
 				errs = append(errs, fmt.Errorf("Break statement not inside a loop on line %d:%d", stmt.Line, stmt.Col))
@@ -400,24 +1455,75 @@ func checkWithReturnType(
 			if !inLoop {
 				errs = append(errs, fmt.Errorf("Continue statement not inside a loop on line %d:%d", stmt.Line, stmt.Col))
 			}
+		case *ast.WithStatement:
+			call, isCall := stmt.Value.(*ast.CallExpression)
+			opener := ""
+			if isCall {
+				if ident, ok := call.Function.(*ast.Identifier); ok {
+					opener = ident.Value
+				}
+			}
+			errs = append(errs, checkCalls(stmt.Value, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+			if !withResources[opener] {
+				errs = append(errs, fmt.Errorf("With statement on line %d:%d needs a resource opened by go.file.open or go.file.create", stmt.Line, stmt.Col))
+			}
+			// The binding only exists inside the block
+			withVarTypes := copyVarTypes(varTypes)
+			withVarTypes[stmt.Name] = inferExprType(stmt.Value, funcTypes, varTypes, structDefs)
+			errs = append(errs, checkWithReturnType(stmt.Body, currentReturnType, funcTypes, funcDefs, withVarTypes, structDefs, inLoop, types)...)
+		case *ast.MatchStatement:
+			errs = append(errs, checkCalls(stmt.Subject, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+			subjectType := inferExprType(stmt.Subject, funcTypes, varTypes, structDefs)
+			switch subjectType {
+			case "int", "float", "string", "bool":
+				for _, c := range stmt.Cases {
+					if caseType := inferExprType(c, funcTypes, varTypes, structDefs); caseType != subjectType {
+						errs = append(errs, fmt.Errorf("Type error on line %d:%d: case value of type %s in match on %s", stmt.Line, stmt.Col, caseType, subjectType))
+					}
+				}
+			default:
+				errs = append(errs, fmt.Errorf("Match subject must be int, float, string or bool, got %s on line %d:%d", subjectType, stmt.Line, stmt.Col))
+			}
+			for _, body := range append(stmt.Bodies, stmt.Default) {
+				errs = append(errs, checkWithReturnType(body, currentReturnType, funcTypes, funcDefs, copyVarTypes(varTypes), structDefs, inLoop, types)...)
+			}
+		case *ast.IfStatement:
+			for _, cond := range append([]ast.Expression{stmt.IfCond}, stmt.ElifConds...) {
+				errs = append(errs, checkCalls(cond, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
+				condLine, condCol := exprPos(cond, stmt.Line, stmt.Col)
+				condType := inferExprType(cond, funcTypes, varTypes, structDefs)
+				if condType == "" {
+					err := fmt.Errorf("Error on line %d:%d: if condition uses an undeclared or non‑public variable", condLine, condCol)
+					errs = append(errs, untypedError(err, cond, funcTypes, varTypes, structDefs))
+				} else if condType != "bool" {
+					errs = append(errs, fmt.Errorf("If condition must be boolean, got %s on line %d:%d", condType, condLine, condCol))
+				}
+			}
+			// Each branch has its own scope
+			for _, body := range append(append([][]ast.Statement{stmt.IfBody}, stmt.ElifBodies...), stmt.ElseBody) {
+				errs = append(errs, checkWithReturnType(body, currentReturnType, funcTypes, funcDefs, copyVarTypes(varTypes), structDefs, inLoop, types)...)
+			}
 		case *ast.WhileStatement:
+			errs = append(errs, checkCalls(stmt.Condition, funcDefs, funcTypes, varTypes, structDefs, stmt.Line, stmt.Col)...)
 			condType := inferExprType(stmt.Condition, funcTypes, varTypes, structDefs)
 			if condType != "bool" {
 				errs = append(errs, fmt.Errorf("While condition must be boolean, got %s on line %d:%d", condType, stmt.Line, stmt.Col))
 			}
-			errs = append(errs, checkWithReturnType(stmt.Body, currentReturnType, funcTypes, funcDefs, copyVarTypes(varTypes), structDefs, true)...) // inLoop = true
+			errs = append(errs, checkWithReturnType(stmt.Body, currentReturnType, funcTypes, funcDefs, copyVarTypes(varTypes), structDefs, true, types)...) // inLoop = true
 		case *ast.ForStatement:
 			forVarTypes := copyVarTypes(varTypes)
 			if stmt.Init != nil {
-				errs = append(errs, checkWithReturnType([]ast.Statement{stmt.Init}, currentReturnType, funcTypes, funcDefs, forVarTypes, structDefs, false)...)
+				errs = append(errs, checkWithReturnType([]ast.Statement{stmt.Init}, currentReturnType, funcTypes, funcDefs, forVarTypes, structDefs, false, types)...)
 			}
+			errs = append(errs, checkFunctionLiterals([]ast.Expression{stmt.Condition}, funcTypes, funcDefs, forVarTypes, structDefs, types)...)
+			errs = append(errs, checkCalls(stmt.Condition, funcDefs, funcTypes, forVarTypes, structDefs, stmt.Line, stmt.Col)...)
 			condType := inferExprType(stmt.Condition, funcTypes, forVarTypes, structDefs)
 			if condType != "bool" {
 				errs = append(errs, fmt.Errorf("For condition must be boolean, got %s on line %d:%d", condType, stmt.Line, stmt.Col))
 			}
-			errs = append(errs, checkWithReturnType(stmt.Body, currentReturnType, funcTypes, funcDefs, forVarTypes, structDefs, true)...) // inLoop = true
+			errs = append(errs, checkWithReturnType(stmt.Body, currentReturnType, funcTypes, funcDefs, forVarTypes, structDefs, true, types)...) // inLoop = true
 			if stmt.Post != nil {
-				errs = append(errs, checkWithReturnType([]ast.Statement{stmt.Post}, currentReturnType, funcTypes, funcDefs, forVarTypes, structDefs, false)...)
+				errs = append(errs, checkWithReturnType([]ast.Statement{stmt.Post}, currentReturnType, funcTypes, funcDefs, forVarTypes, structDefs, false, types)...)
 			}
 		}
 	}
@@ -425,6 +1531,42 @@ func checkWithReturnType(
 	return errs
 }
 
+// checkCalls checks the arguments of every call in expr, not just the
+// outermost one: return n * fact(n - 1) checks the call to fact. Calls in the
+// body of a function literal are left to checkFunctionLiterals, which knows
+// the literal's parameters.
+func checkCalls(
+	expr ast.Expression,
+	funcDefs map[string]*ast.FunctionStatement,
+	funcTypes map[string]string,
+	varTypes map[string]string,
+	structDefs map[string]*ast.StructStatement,
+	line, col int,
+) []error {
+	if expr == nil {
+		return nil
+	}
+	var errs []error
+	inLiteral := map[*ast.CallExpression]bool{}
+	ast.Walk([]ast.Statement{&ast.ExpressionStatement{Expr: expr}}, func(node interface{}) {
+		switch n := node.(type) {
+		case *ast.FunctionLiteral:
+			// Parents are visited first, so the calls in its body are marked
+			// before the walk reaches them
+			ast.Walk(n.Fn.Body, func(inner interface{}) {
+				if call, ok := inner.(*ast.CallExpression); ok {
+					inLiteral[call] = true
+				}
+			})
+		case *ast.CallExpression:
+			if !inLiteral[n] {
+				errs = append(errs, checkCallExpr(n, funcDefs, funcTypes, varTypes, structDefs, line, col)...)
+			}
+		}
+	})
+	return errs
+}
+
 // checkCallExpr verifies that a call expression has the correct number and types of arguments.
 func checkCallExpr(
 	call *ast.CallExpression,
@@ -435,13 +1577,22 @@ func checkCallExpr(
 	line, col int,
 ) []error {
 	var errs []error
+	// Errors point at the call itself; line and col of the statement holding
+	// it are the fallback for calls built without a position
+	line, col = exprPos(call, line, col)
 	ident, ok := call.Function.(*ast.Identifier)
 	if !ok {
 		return errs
 	}
 
 	if _, ok := GoBuiltins[ident.Value]; ok {
-		return errs
+		// Calls to an @override are checked against its own parameters
+		if def, ok := funcDefs[ident.Value]; !ok || !def.Override {
+			if call.ArgNames != nil {
+				return []error{namedArgsError(ident.Value, line, col)}
+			}
+			return checkBuiltinArgs(ident.Value, call, funcTypes, varTypes, structDefs, line, col)
+		}
 	}
 
 	// --- Method call support ---
@@ -453,17 +1604,27 @@ func checkCallExpr(
 			methodFullName := baseType + "." + methodName
 			fn, ok := funcDefs[methodFullName]
 			if ok {
-				// Insert the base as the first argument (for 'this')
-				args := append([]ast.Expression{&ast.Identifier{Value: baseName}}, call.Arguments...)
-				if len(args) != len(fn.Params) {
-					errs = append(errs, fmt.Errorf("Method '%s' expects %d arguments, got %d on line %d:%d", methodFullName, len(fn.Params), len(args), line, col))
+				// The base is bound to this: Params are the arguments after it
+				args, err := call.ArgumentsFor(fn.Params, fn.Defaults)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("Call to '%s' on line %d:%d: %v", methodFullName, line, col, err))
+					return errs
+				}
+				if want, ok := expectedArgs(fn, len(call.Arguments)); !ok {
+					errs = append(errs, fmt.Errorf("Method '%s' expects %s arguments, got %d on line %d:%d", methodFullName, want, len(call.Arguments), line, col))
 					return errs
 				}
 				for i, arg := range args {
-					argType := inferExprType(arg, funcTypes, varTypes, structDefs)
-					paramType := fn.ParamTypes[i]
-					if argType != paramType {
-						errs = append(errs, fmt.Errorf("Type error: argument %d to '%s' expects %s, got %s on line %d:%d", i+1, methodFullName, paramType, argType, line, col))
+					if i < len(fn.Defaults) && arg == fn.Defaults[i] {
+						continue // checked with the declaration
+					}
+					paramType := argParamType(fn.ParamTypes, fn.Variadic, i)
+					argType := inferExpectedType(arg, paramType, funcTypes, varTypes, structDefs)
+					if argType == "void" {
+						errs = append(errs, voidArgError(arg, i+1, methodFullName, line, col))
+					} else if !assignableType(paramType, argType) {
+						argLine, argCol := exprPos(arg, line, col)
+						errs = append(errs, fmt.Errorf("Type error: argument %d to '%s' expects %s, got %s on line %d:%d", i+1, methodFullName, paramType, argType, argLine, argCol))
 					}
 				}
 				return errs
@@ -471,14 +1632,63 @@ func checkCallExpr(
 		}
 	}
 
+	// Closure held by a variable or struct field, e.g. cfg.onDone(). A plain
+	// fnc has no signature, so its arguments aren't checked.
+	if _, declared := funcDefs[ident.Value]; !declared {
+		calleeType := inferExprType(ident, funcTypes, varTypes, structDefs)
+		if calleeType == "fnc" {
+			return errs
+		}
+		if params, _, ok := funcTypeParts(calleeType); ok {
+			// Parameter names aren't part of a function type
+			if call.ArgNames != nil {
+				return []error{namedArgsError(ident.Value, line, col)}
+			}
+			variadic := false
+			if n := len(params); n > 0 && strings.HasSuffix(params[n-1], "...") {
+				variadic = true
+				params[n-1] = arrayType(strings.TrimSuffix(params[n-1], "..."))
+			}
+			if variadic && len(call.Arguments) < len(params)-1 {
+				errs = append(errs, fmt.Errorf("Function '%s' expects at least %d arguments, got %d on line %d:%d", ident.Value, len(params)-1, len(call.Arguments), line, col))
+				return errs
+			}
+			if !variadic && len(call.Arguments) != len(params) {
+				errs = append(errs, fmt.Errorf("Function '%s' expects %d arguments, got %d on line %d:%d", ident.Value, len(params), len(call.Arguments), line, col))
+				return errs
+			}
+			for i, arg := range call.Arguments {
+				paramType := argParamType(params, variadic, i)
+				argType := inferExpectedType(arg, paramType, funcTypes, varTypes, structDefs)
+				if argType == "void" {
+					errs = append(errs, voidArgError(arg, i+1, ident.Value, line, col))
+				} else if !assignableType(paramType, argType) {
+					argLine, argCol := exprPos(arg, line, col)
+					errs = append(errs, fmt.Errorf("Type error: argument %d to '%s' expects %s, got %s on line %d:%d", i+1, ident.Value, paramType, argType, argLine, argCol))
+				}
+			}
+			return errs
+		}
+	}
+
+	if (ident.Value == "len" || ident.Value == "input") && call.ArgNames != nil {
+		return []error{namedArgsError(ident.Value, line, col)}
+	}
 	// Built-in len function.
 	if ident.Value == "len" {
 		if len(call.Arguments) != 1 {
 			errs = append(errs, fmt.Errorf("Built-in 'len' expects 1 argument, got %d on line %d:%d", len(call.Arguments), line, col))
+			return errs
 		}
 		argType := inferExprType(call.Arguments[0], funcTypes, varTypes, structDefs)
-		if len(argType) < 3 || argType[len(argType)-2:] != "[]" {
-			errs = append(errs, fmt.Errorf("Built-in 'len' expects an array argument, got %s on line %d:%d", argType, line, col))
+		// Strings count their characters
+		if _, ok := elemType(argType); !ok && argType != "string" {
+			err := fmt.Errorf("Built-in 'len' expects an array or string argument, got %s on line %d:%d", argType, line, col)
+			if argType == "" {
+				// Say why, e.g. a mixed array literal or an undeclared name
+				err = untypedError(err, call.Arguments[0], funcTypes, varTypes, structDefs)
+			}
+			errs = append(errs, err)
 		}
 		return errs
 	}
@@ -501,20 +1711,695 @@ func checkCallExpr(
 		errs = append(errs, fmt.Errorf("Unknown function '%s' on line %d:%d", ident.Value, line, col))
 		return errs
 	}
-	if len(call.Arguments) != len(fn.Params) {
-		errs = append(errs, fmt.Errorf("Function '%s' expects %d arguments, got %d on line %d:%d", ident.Value, len(fn.Params), len(call.Arguments), line, col))
+	args, err := call.ArgumentsFor(fn.Params, fn.Defaults)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Call to '%s' on line %d:%d: %v", ident.Value, line, col, err))
+		return errs
+	}
+	if want, ok := expectedArgs(fn, len(call.Arguments)); !ok {
+		errs = append(errs, fmt.Errorf("Function '%s' expects %s arguments, got %d on line %d:%d", ident.Value, want, len(call.Arguments), line, col))
 		return errs
 	}
+	for i, arg := range args {
+		if i < len(fn.Defaults) && arg == fn.Defaults[i] {
+			continue // checked with the declaration
+		}
+		paramType := argParamType(fn.ParamTypes, fn.Variadic, i)
+		argType := inferExpectedType(arg, paramType, funcTypes, varTypes, structDefs)
+		if argType == "void" {
+			errs = append(errs, voidArgError(arg, i+1, ident.Value, line, col))
+		} else if !assignableType(paramType, argType) {
+			argLine, argCol := exprPos(arg, line, col)
+			errs = append(errs, fmt.Errorf("Type error: argument %d to '%s' expects %s, got %s on line %d:%d", i+1, ident.Value, paramType, argType, argLine, argCol))
+		}
+	}
+	return errs
+}
+
+// namedArgsError reports named arguments in a call to a builtin or a function
+// value, whose parameters have no names to match them to.
+func namedArgsError(name string, line, col int) error {
+	return fmt.Errorf("Call to '%s' on line %d:%d: named arguments need a declared function or method", name, line, col)
+}
+
+// expectedArgs describes how many arguments fn takes, "2", "1 to 2" when
+// trailing parameters have defaults or "at least 1" when it is variadic, and
+// reports whether got is one of them.
+func expectedArgs(fn *ast.FunctionStatement, got int) (string, bool) {
+	fixed := len(fn.Params)
+	if fn.Variadic {
+		fixed--
+	}
+	required := fixed
+	for required > 0 && required <= len(fn.Defaults) && fn.Defaults[required-1] != nil {
+		required--
+	}
+	if fn.Variadic {
+		return fmt.Sprintf("at least %d", required), got >= required
+	}
+	ok := got >= required && got <= len(fn.Params)
+	if required == len(fn.Params) {
+		return fmt.Sprint(required), ok
+	}
+	return fmt.Sprintf("%d to %d", required, len(fn.Params)), ok
+}
+
+// checkReachable reports the first statement of a block following a return,
+// break or continue in it, as it can never run. Nested blocks are checked on
+// their own.
+func checkReachable(stmts []ast.Statement) []error {
+	for i := 0; i+1 < len(stmts); i++ {
+		switch stmts[i].(type) {
+		case *ast.ReturnStatement, *ast.BreakStatement, *ast.ContinueStatement:
+			line, col := statementPos(stmts[i+1])
+			return []error{fmt.Errorf("Unreachable statement on line %d:%d", line, col)}
+		}
+	}
+	return nil
+}
+
+// statementPos returns the position of a statement, 0:0 for the few kinds
+// that don't record one.
+func statementPos(s ast.Statement) (int, int) {
+	switch st := s.(type) {
+	case *ast.LetStatement:
+		return st.Line, st.Col
+	case *ast.FunctionStatement:
+		return st.Line, st.Col
+	case *ast.StructStatement:
+		return st.Line, st.Col
+	case *ast.TypeAliasStatement:
+		return st.Line, st.Col
+	case *ast.LogFunction:
+		return st.Line, st.Col
+	case *ast.ReturnStatement:
+		return st.Line, st.Col
+	case *ast.AssertStatement:
+		return st.Line, st.Col
+	case *ast.IfStatement:
+		return st.Line, st.Col
+	case *ast.MatchStatement:
+		return st.Line, st.Col
+	case *ast.AssignmentStatement:
+		return st.Line, st.Col
+	case *ast.WhileStatement:
+		return st.Line, st.Col
+	case *ast.WithStatement:
+		return st.Line, st.Col
+	case *ast.ForStatement:
+		return st.Line, st.Col
+	case *ast.WhenStatement:
+		return st.Line, st.Col
+	case *ast.ExpressionStatement:
+		return st.Line, st.Col
+	case *ast.BreakStatement:
+		return st.Line, st.Col
+	case *ast.ContinueStatement:
+		return st.Line, st.Col
+	}
+	return 0, 0
+}
+
+// returnsOnAllPaths reports whether every path through body ends in a
+// return: a return statement, an if with an else or a match with a default
+// whose branches all return, or a while true loop that is never broken out
+// of.
+func returnsOnAllPaths(body []ast.Statement) bool {
+	for _, s := range body {
+		switch st := s.(type) {
+		case *ast.ReturnStatement:
+			return true
+		case *ast.IfStatement:
+			if st.ElseBody == nil || !returnsOnAllPaths(st.IfBody) || !returnsOnAllPaths(st.ElseBody) {
+				continue
+			}
+			all := true
+			for _, elifBody := range st.ElifBodies {
+				all = all && returnsOnAllPaths(elifBody)
+			}
+			if all {
+				return true
+			}
+		case *ast.MatchStatement:
+			if st.Default == nil || !returnsOnAllPaths(st.Default) {
+				continue
+			}
+			all := true
+			for _, caseBody := range st.Bodies {
+				all = all && returnsOnAllPaths(caseBody)
+			}
+			if all {
+				return true
+			}
+		case *ast.WithStatement:
+			if returnsOnAllPaths(st.Body) {
+				return true
+			}
+		case *ast.WhileStatement:
+			if cond, ok := st.Condition.(*ast.BoolLiteral); ok && cond.Value && !breaks(st.Body) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// breaks reports whether body contains a break out of the loop it belongs
+// to; breaks inside nested loops don't count.
+func breaks(body []ast.Statement) bool {
+	for _, s := range body {
+		switch st := s.(type) {
+		case *ast.BreakStatement:
+			return true
+		case *ast.IfStatement:
+			if breaks(st.IfBody) || breaks(st.ElseBody) {
+				return true
+			}
+			for _, elifBody := range st.ElifBodies {
+				if breaks(elifBody) {
+					return true
+				}
+			}
+		case *ast.MatchStatement:
+			if breaks(st.Default) {
+				return true
+			}
+			for _, caseBody := range st.Bodies {
+				if breaks(caseBody) {
+					return true
+				}
+			}
+		case *ast.WithStatement:
+			if breaks(st.Body) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// argParamType returns the type argument i must have: the element type of a
+// variadic last parameter for each argument it collects.
+func argParamType(paramTypes []string, variadic bool, i int) string {
+	last := len(paramTypes) - 1
+	if variadic && i >= last {
+		elem, _ := elemType(paramTypes[last])
+		return elem
+	}
+	return paramTypes[i]
+}
+
+// checkDefaults reports parameter defaults of fn that aren't constant
+// expressions or don't have the parameter's type.
+func checkDefaults(fn *ast.FunctionStatement, funcTypes map[string]string, varTypes map[string]string, structDefs map[string]*ast.StructStatement) []error {
+	var errs []error
+	for i, def := range fn.Defaults {
+		if def == nil {
+			continue
+		}
+		param, paramType := fn.Params[i], fn.ParamTypes[i]
+		if !isConstant(def, varTypes) {
+			errs = append(errs, fmt.Errorf("Error on line %d:%d: default value of parameter '%s' must be a constant expression", fn.Line, fn.Col, param))
+		} else if defType := inferExprType(def, funcTypes, varTypes, structDefs); !assignableType(paramType, defType) {
+			errs = append(errs, fmt.Errorf("Type error on line %d:%d: default value of parameter '%s' is %s, not %s", fn.Line, fn.Col, param, defType, paramType))
+		}
+	}
+	return errs
+}
+
+// exprPos returns the position of an expression, or line and col when it
+// doesn't record one.
+func exprPos(expr ast.Expression, line, col int) (int, int) {
+	var l, c int
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		l, c = e.Line, e.Col
+	case *ast.CallExpression:
+		l, c = e.Line, e.Col
+	case *ast.IndexExpression:
+		l, c = e.Line, e.Col
+	case *ast.SliceExpression:
+		l, c = e.Line, e.Col
+	case *ast.ArrayLiteral:
+		l, c = e.Line, e.Col
+	case *ast.MapLiteral:
+		l, c = e.Line, e.Col
+	case *ast.StructLiteral:
+		l, c = e.Line, e.Col
+	case *ast.FunctionLiteral:
+		l, c = e.Line, e.Col
+	case *ast.BinaryExpression:
+		l, c = e.Line, e.Col
+	case *ast.UnaryExpression:
+		l, c = e.Line, e.Col
+	case *ast.TernaryExpression:
+		l, c = e.Line, e.Col
+	case *ast.StringLiteral:
+		l, c = e.Line, e.Col
+	case *ast.IntegerLiteral:
+		l, c = e.Line, e.Col
+	case *ast.FloatLiteral:
+		l, c = e.Line, e.Col
+	case *ast.BoolLiteral:
+		l, c = e.Line, e.Col
+	case *ast.NilLiteral:
+		l, c = e.Line, e.Col
+	}
+	if l == 0 {
+		return line, col
+	}
+	return l, c
+}
+
+// voidArgError reports passing the result of a void function call, e.g. f(g())
+// where g returns void, as argument n to callee.
+func voidArgError(arg ast.Expression, n int, callee string, line, col int) error {
+	name := "call"
+	if call, ok := arg.(*ast.CallExpression); ok {
+		if ident, ok := call.Function.(*ast.Identifier); ok {
+			name = ident.Value
+		}
+	}
+	return fmt.Errorf("Type error: cannot pass result of void function '%s' as argument %d to '%s' on line %d:%d", name, n, callee, line, col)
+}
+
+// checkBuiltinArgs validates arguments of Go builtins that constrain their argument types.
+func checkBuiltinArgs(
+	name string,
+	call *ast.CallExpression,
+	funcTypes map[string]string,
+	varTypes map[string]string,
+	structDefs map[string]*ast.StructStatement,
+	line, col int,
+) []error {
+	var errs []error
+	argTypes := make([]string, len(call.Arguments))
 	for i, arg := range call.Arguments {
-		argType := inferExprType(arg, funcTypes, varTypes, structDefs)
-		paramType := fn.ParamTypes[i]
-		if argType != paramType {
-			errs = append(errs, fmt.Errorf("Type error: argument %d to '%s' expects %s, got %s on line %d:%d", i+1, ident.Value, paramType, argType, line, col))
+		argTypes[i] = inferExprType(arg, funcTypes, varTypes, structDefs)
+	}
+	switch name {
+	case "go.math.sum", "go.math.avg", "go.math.minOf", "go.math.maxOf":
+		if len(argTypes) != 1 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 1 argument, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else if argTypes[0] != "int[]" && argTypes[0] != "float[]" {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects an int[] or float[] argument, got %s on line %d:%d", name, argTypes[0], line, col))
+		}
+	case "go.strings.scan":
+		if len(argTypes) != 2 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 2 arguments, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else if argTypes[0] != "string" || argTypes[1] != "string" {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects a string and a format string, got %s and %s on line %d:%d", name, argTypes[0], argTypes[1], line, col))
+		}
+	case "go.math.abs", "go.math.sqrt", "go.math.floor", "go.math.ceil":
+		if len(argTypes) != 1 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 1 argument, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else {
+			errs = append(errs, numericArgErrors(name, call, argTypes, line, col)...)
+		}
+	case "go.math.min", "go.math.max", "go.math.pow":
+		if len(argTypes) != 2 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 2 arguments, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else {
+			errs = append(errs, numericArgErrors(name, call, argTypes, line, col)...)
+		}
+	case "go.array.push", "go.array.contains":
+		if len(argTypes) != 2 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 2 arguments, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else if elem, ok := elemType(argTypes[0]); !ok {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects an array as its first argument, got %s on line %d:%d", name, argTypes[0], line, col))
+		} else if !assignableType(elem, argTypes[1]) {
+			argLine, argCol := exprPos(call.Arguments[1], line, col)
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects a value of type %s, got %s on line %d:%d", name, elem, argTypes[1], argLine, argCol))
+		}
+	case "go.array.pop":
+		if len(argTypes) != 1 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 1 argument, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else if _, ok := elemType(argTypes[0]); !ok {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects an array argument, got %s on line %d:%d", name, argTypes[0], line, col))
+		}
+	case "go.array.append":
+		if len(argTypes) != 2 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 2 arguments, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else if _, ok := elemType(argTypes[0]); !ok {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects array arguments, got %s on line %d:%d", name, argTypes[0], line, col))
+		} else if argTypes[0] != argTypes[1] {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects both arrays to have the same type, got %s and %s on line %d:%d", name, argTypes[0], argTypes[1], line, col))
+		}
+	case "go.array.sort":
+		if len(argTypes) != 1 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 1 argument, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else if argTypes[0] != "int[]" && argTypes[0] != "float[]" && argTypes[0] != "string[]" {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects an int[], float[] or string[] argument, got %s on line %d:%d", name, argTypes[0], line, col))
+		}
+	case "go.array.sortBy":
+		if len(argTypes) != 2 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 2 arguments, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else if elem, ok := elemType(argTypes[0]); !ok {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects an array as its first argument, got %s on line %d:%d", name, argTypes[0], line, col))
+		} else if less := "fnc(" + elem + "," + elem + ")>>bool"; !assignableType(less, argTypes[1]) {
+			argLine, argCol := exprPos(call.Arguments[1], line, col)
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects a comparator of type %s, got %s on line %d:%d", name, less, argTypes[1], argLine, argCol))
+		}
+	case "go.conv.toInt", "go.conv.toFloat", "go.conv.toBool":
+		if len(argTypes) != 1 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 1 argument, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else if argTypes[0] != "string" {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects a string argument, got %s on line %d:%d", name, argTypes[0], line, col))
+		}
+	case "go.map.keys", "go.map.values":
+		if len(argTypes) != 1 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 1 argument, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else if _, _, ok := mapTypes(argTypes[0]); !ok {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects a map argument, got %s on line %d:%d", name, argTypes[0], line, col))
+		}
+	case "go.map.has", "go.map.delete":
+		if len(argTypes) != 2 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 2 arguments, got %d on line %d:%d", name, len(argTypes), line, col))
+		} else if key, _, ok := mapTypes(argTypes[0]); !ok {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects a map as its first argument, got %s on line %d:%d", name, argTypes[0], line, col))
+		} else if !assignableType(key, argTypes[1]) {
+			argLine, argCol := exprPos(call.Arguments[1], line, col)
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects a key of type %s, got %s on line %d:%d", name, key, argTypes[1], argLine, argCol))
+		}
+	case "go.map.merge":
+		if len(argTypes) != 2 {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects 2 arguments, got %d on line %d:%d", name, len(argTypes), line, col))
+			break
+		}
+		if _, _, ok := mapTypes(argTypes[0]); !ok {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects map arguments, got %s on line %d:%d", name, argTypes[0], line, col))
+		} else if argTypes[0] != argTypes[1] {
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects both maps to have the same type, got %s and %s on line %d:%d", name, argTypes[0], argTypes[1], line, col))
+		}
+	default:
+		params, ok := builtinParams[name]
+		if !ok {
+			break
+		}
+		if len(argTypes) != len(params) {
+			plural := "s"
+			if len(params) == 1 {
+				plural = ""
+			}
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects %d argument%s, got %d on line %d:%d", name, len(params), plural, len(argTypes), line, col))
+			break
+		}
+		for i, param := range params {
+			if !assignableType(param, argTypes[i]) {
+				argLine, argCol := exprPos(call.Arguments[i], line, col)
+				errs = append(errs, fmt.Errorf("Type error: argument %d to '%s' expects %s, got %s on line %d:%d", i+1, name, param, argTypes[i], argLine, argCol))
+			}
 		}
 	}
 	return errs
 }
 
+// numericArgErrors reports the arguments of a go.math builtin that aren't
+// int or float, at the argument's position.
+func numericArgErrors(name string, call *ast.CallExpression, argTypes []string, line, col int) []error {
+	var errs []error
+	for i, argType := range argTypes {
+		if !isNumeric(argType) {
+			argLine, argCol := exprPos(call.Arguments[i], line, col)
+			errs = append(errs, fmt.Errorf("Built-in '%s' expects int or float arguments, got %s on line %d:%d", name, argType, argLine, argCol))
+		}
+	}
+	return errs
+}
+
+// elemType returns the element type of an array type, e.g. "int" for "int[]"
+// and "map[string]int" for "(map[string]int)[]". A map type is never an array,
+// even when its value type is: map[string]int[] maps strings to int arrays.
+// Likewise fnc()>>int[] is a function returning an array.
+func elemType(t string) (string, bool) {
+	if len(t) <= 2 || !strings.HasSuffix(t, "[]") || strings.HasPrefix(t, "map[") || strings.HasPrefix(t, "fnc(") {
+		return "", false
+	}
+	elem := t[:len(t)-2]
+	if strings.HasPrefix(elem, "(") && strings.HasSuffix(elem, ")") {
+		elem = elem[1 : len(elem)-1]
+	}
+	return elem, true
+}
+
+// arrayType returns the type of an array of elem, the inverse of elemType.
+func arrayType(elem string) string {
+	if strings.HasPrefix(elem, "map[") || strings.HasPrefix(elem, "fnc(") {
+		return "(" + elem + ")[]"
+	}
+	return elem + "[]"
+}
+
+// unknownTypeName returns the first name in type t that is neither a built-in
+// type nor a declared struct, looking inside array and map types; "" if there
+// is none.
+func unknownTypeName(t string, structDefs map[string]*ast.StructStatement) string {
+	if keyType, valueType, ok := mapTypes(t); ok {
+		if name := unknownTypeName(keyType, structDefs); name != "" {
+			return name
+		}
+		return unknownTypeName(valueType, structDefs)
+	}
+	if elem, ok := elemType(t); ok {
+		return unknownTypeName(elem, structDefs)
+	}
+	if params, ret, ok := funcTypeParts(t); ok {
+		for _, param := range params {
+			param = strings.TrimSuffix(param, "...")
+			if name := unknownTypeName(param, structDefs); name != "" {
+				return name
+			}
+		}
+		if ret == "void" {
+			return ""
+		}
+		return unknownTypeName(ret, structDefs)
+	}
+	switch t {
+	case "int", "float", "string", "bool", "any", "fnc":
+		return ""
+	}
+	if _, ok := structDefs[t]; ok {
+		return ""
+	}
+	return t
+}
+
+// fieldPathType returns the type of the field reached by reading fields in
+// turn from a value of type typ, or "" if one of them doesn't exist.
+func fieldPathType(typ string, fields []string, structDefs map[string]*ast.StructStatement) string {
+	for _, field := range fields {
+		def, ok := structDefs[typ]
+		if !ok {
+			return ""
+		}
+		typ = ""
+		for _, fld := range def.Fields {
+			if fld.Name == field {
+				typ = fld.Type
+				break
+			}
+		}
+		if typ == "" {
+			return ""
+		}
+	}
+	return typ
+}
+
+// funcType returns the type of fn as a value, e.g. "fnc(int,string)>>bool".
+func funcType(fn *ast.FunctionStatement) string {
+	params := fn.ParamTypes
+	if fn.Variadic {
+		// The last parameter is written as it was declared, nums int...
+		params = append([]string{}, params...)
+		elem, _ := elemType(params[len(params)-1])
+		params[len(params)-1] = elem + "..."
+	}
+	return fmt.Sprintf("fnc(%s)>>%s", strings.Join(params, ","), fn.ReturnType)
+}
+
+// funcTypeParts splits a function type such as "fnc(int,string)>>bool" into
+// its parameter and return types.
+func funcTypeParts(t string) ([]string, string, bool) {
+	if !strings.HasPrefix(t, "fnc(") {
+		return nil, "", false
+	}
+	// Parameter types may themselves hold commas and parentheses
+	var params []string
+	depth, start := 0, len("fnc(")
+	for i := start; i < len(t); i++ {
+		switch t[i] {
+		case '(', '[':
+			depth++
+		case ']':
+			depth--
+		case ',', ')':
+			if depth > 0 {
+				if t[i] == ')' {
+					depth--
+				}
+				continue
+			}
+			if param := t[start:i]; param != "" {
+				params = append(params, param)
+			}
+			start = i + 1
+			if t[i] == ')' {
+				if !strings.HasPrefix(t[i+1:], ">>") {
+					return nil, "", false
+				}
+				return params, t[i+3:], true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// assignableType reports whether a value of type got can be stored where type
+// want is expected: the same type, or any function for a plain fnc.
+func assignableType(want, got string) bool {
+	if want == "fnc" {
+		_, _, isFunc := funcTypeParts(got)
+		return got == "fnc" || isFunc
+	}
+	return want == got
+}
+
+// mapTypes splits a map type such as "map[string]int" into its key and value types.
+func mapTypes(t string) (string, string, bool) {
+	if !strings.HasPrefix(t, "map[") {
+		return "", "", false
+	}
+	closeBracket := strings.Index(t, "]")
+	if closeBracket == -1 || closeBracket+1 >= len(t) {
+		return "", "", false
+	}
+	return t[4:closeBracket], t[closeBracket+1:], true
+}
+
+// checkStructLiterals checks the struct literals in expr, including those
+// nested in fields, arrays and maps: it reports missing and unknown fields,
+// and field values that don't have the declared type.
+func checkStructLiterals(expr ast.Expression, funcTypes map[string]string, varTypes map[string]string, structDefs map[string]*ast.StructStatement) []error {
+	var errs []error
+	switch e := expr.(type) {
+	case *ast.StructLiteral:
+		errs = append(errs, checkStructLiteral(e, funcTypes, varTypes, structDefs)...)
+		names := make([]string, 0, len(e.Fields))
+		for name := range e.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			errs = append(errs, checkStructLiterals(e.Fields[name], funcTypes, varTypes, structDefs)...)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			errs = append(errs, checkStructLiterals(el, funcTypes, varTypes, structDefs)...)
+		}
+	case *ast.MapLiteral:
+		for _, value := range e.Pairs {
+			errs = append(errs, checkStructLiterals(value, funcTypes, varTypes, structDefs)...)
+		}
+	case *ast.CallExpression:
+		for _, arg := range e.Arguments {
+			errs = append(errs, checkStructLiterals(arg, funcTypes, varTypes, structDefs)...)
+		}
+	case *ast.BinaryExpression:
+		errs = append(errs, checkStructLiterals(e.Left, funcTypes, varTypes, structDefs)...)
+		errs = append(errs, checkStructLiterals(e.Right, funcTypes, varTypes, structDefs)...)
+	case *ast.UnaryExpression:
+		errs = append(errs, checkStructLiterals(e.Right, funcTypes, varTypes, structDefs)...)
+	case *ast.TernaryExpression:
+		errs = append(errs, checkStructLiterals(e.Then, funcTypes, varTypes, structDefs)...)
+		errs = append(errs, checkStructLiterals(e.Else, funcTypes, varTypes, structDefs)...)
+	}
+	return errs
+}
+
+// checkStructLiteral checks the fields of a single struct literal.
+func checkStructLiteral(lit *ast.StructLiteral, funcTypes map[string]string, varTypes map[string]string, structDefs map[string]*ast.StructStatement) []error {
+	var errs []error
+	def, ok := structDefs[lit.StructName]
+	if !ok {
+		if _, isVar := varTypes[lit.StructName]; isVar {
+			// Most likely a block or map after a variable, e.g. if x == y { ... }
+			// outside a statement header
+			return []error{fmt.Errorf("Unknown struct type '%s' on line %d:%d ('%s' is a variable: is the '{' meant to start a block or map?)", lit.StructName, lit.Line, lit.Col, lit.StructName)}
+		}
+		return []error{fmt.Errorf("Unknown struct type '%s' on line %d:%d", lit.StructName, lit.Line, lit.Col)}
+	}
+	// Check for missing fields, and the type of the others
+	declared := map[string]bool{}
+	for _, field := range def.Fields {
+		declared[field.Name] = true
+		value, exists := lit.Fields[field.Name]
+		if !exists {
+			errs = append(errs, fmt.Errorf("Missing field '%s' in struct literal for '%s' on line %d:%d", field.Name, lit.StructName, lit.Line, lit.Col))
+			continue
+		}
+		valType := inferExpectedType(value, field.Type, funcTypes, varTypes, structDefs)
+		if field.Type == "any" || valType == "" {
+			continue
+		}
+		if !assignableType(field.Type, valType) {
+			line, col := exprPos(value, lit.Line, lit.Col)
+			errs = append(errs, fmt.Errorf("Field '%s' expects %s, got %s on line %d:%d", field.Name, field.Type, valType, line, col))
+		}
+	}
+	// Check for extra fields
+	var unknown []string
+	for fieldName := range lit.Fields {
+		if !declared[fieldName] {
+			unknown = append(unknown, fieldName)
+		}
+	}
+	sort.Strings(unknown)
+	for _, fieldName := range unknown {
+		errs = append(errs, fmt.Errorf("Unknown field '%s' in struct literal for '%s' on line %d:%d", fieldName, lit.StructName, lit.Line, lit.Col))
+	}
+	return errs
+}
+
+// hideFunctions returns funcTypes and funcDefs without the functions named
+// like one of params: in the body, the parameter is called instead. The maps
+// are only copied when a function is hidden.
+func hideFunctions(params []string, funcTypes map[string]string, funcDefs map[string]*ast.FunctionStatement) (map[string]string, map[string]*ast.FunctionStatement) {
+	hidden := map[string]bool{}
+	for _, param := range params {
+		if _, ok := funcDefs[param]; ok {
+			hidden[param] = true
+		}
+	}
+	if len(hidden) == 0 {
+		return funcTypes, funcDefs
+	}
+	types := make(map[string]string, len(funcTypes))
+	for name, ret := range funcTypes {
+		if !hidden[name] {
+			types[name] = ret
+		}
+	}
+	defs := make(map[string]*ast.FunctionStatement, len(funcDefs))
+	for name, def := range funcDefs {
+		if !hidden[name] {
+			defs[name] = def
+		}
+	}
+	return types, defs
+}
+
+// copyFuncs makes shallow copies of the maps of function return types and
+// declarations.
+func copyFuncs(funcTypes map[string]string, funcDefs map[string]*ast.FunctionStatement) (map[string]string, map[string]*ast.FunctionStatement) {
+	defs := make(map[string]*ast.FunctionStatement, len(funcDefs))
+	for name, def := range funcDefs {
+		defs[name] = def
+	}
+	return copyVarTypes(funcTypes), defs
+}
+
 // copyVarTypes makes a shallow copy of a map of variable types.
 func copyVarTypes(src map[string]string) map[string]string {
 	dst := make(map[string]string)
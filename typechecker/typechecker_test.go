@@ -0,0 +1,1099 @@
+package typechecker
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/notrealandy/tox/ast"
+	"github.com/notrealandy/tox/lexer"
+	"github.com/notrealandy/tox/parser"
+)
+
+// parse parses src, failing the test on parse errors.
+func parse(t *testing.T, src string) []ast.Statement {
+	t.Helper()
+	p := parser.New(lexer.New(src))
+	stmts := p.ParseProgram()
+	if len(p.Errors) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors)
+	}
+	return stmts
+}
+
+// check parses src and type checks it.
+func check(t *testing.T, src string) []error {
+	t.Helper()
+	return Check(parse(t, src))
+}
+
+// expectErrors checks that src type checks with exactly the errors in want,
+// each given as a substring of the message, in order.
+func expectErrors(t *testing.T, src string, want []string) {
+	t.Helper()
+	errs := check(t, src)
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors %v, want %d %q", len(errs), errs, len(want), want)
+	}
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), want[i]) {
+			t.Errorf("error %d = %q, want it to contain %q", i, err, want[i])
+		}
+	}
+}
+
+const mutualRecursion = `
+fnc factorial(n int) >> int {
+    if n <= 1 {
+        return 1
+    }
+    return n * factorial(n - 1)
+}
+fnc isEven(n int) >> bool {
+    if n == 0 {
+        return true
+    }
+    return isOdd(n - 1)
+}
+fnc isOdd(n int) >> bool {
+    if n == 0 {
+        return false
+    }
+    return isEven(n - 1)
+}
+`
+
+func TestCallArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"recursive calls", `log(factorial(5))
+    log(isEven(10))
+    log(isOdd(7))`, nil},
+		{"call in a let", `let y int >> 1 + factorial("x")`,
+			[]string{"argument 1 to 'factorial' expects int, got string on line 21:32"}},
+		{"call in a log", `log(isEven("x"))`,
+			[]string{"argument 1 to 'isEven' expects int, got string on line 21:16"}},
+		{"call in a condition", `if isOdd(true) {
+        log("odd")
+    }`, []string{"argument 1 to 'isOdd' expects int, got bool on line 21:14"}},
+		{"nested call", `log(factorial(factorial("x")))`,
+			[]string{"argument 1 to 'factorial' expects int, got string on line 21:29"}},
+		{"call in an assignment", `let n int >> 0
+    n >> factorial(1.5)`,
+			[]string{"argument 1 to 'factorial' expects int, got float on line 22:20"}},
+		{"call in a function literal", `let f fnc >> fnc(s string) >> int {
+        return factorial(s)
+    }`, []string{"argument 1 to 'factorial' expects int, got string on line 22:26"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := mutualRecursion + "fnc main() {\n    " + tt.body + "\n}\n"
+			expectErrors(t, src, tt.want)
+		})
+	}
+}
+
+func TestCallArgumentsInReturn(t *testing.T) {
+	src := `
+fnc fact(n int) >> int {
+    if n <= 1 {
+        return 1
+    }
+    return n * fact("x")
+}
+`
+	expectErrors(t, src, []string{"argument 1 to 'fact' expects int, got string on line 6:21"})
+}
+
+func TestParamShadowsConst(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"function parameter", `
+const limit int >> 10
+fnc clamp(limit int) >> int {
+    limit >> limit + 1
+    return limit
+}
+`, nil},
+		{"function literal parameter", `
+const limit int >> 10
+let f fnc >> fnc(limit int) >> int {
+    limit >> 0
+    return limit
+}
+`, nil},
+		{"const itself", `
+const limit int >> 10
+fnc main() {
+    limit >> 11
+}
+`, []string{"Cannot assign to const 'limit'"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectErrors(t, tt.src, tt.want)
+		})
+	}
+}
+
+func TestPurity(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"locals and parameters", `let local int >> n
+    local += 1
+    n >> n + 1
+    return local`, nil},
+		{"global", `counter += n
+    return n`, []string{"Pure function 'f' cannot assign to 'counter'"}},
+		{"global array element", `xs[0] >> n
+    return n`, []string{"Pure function 'f' cannot assign to 'xs'"}},
+		{"log", `log(n)
+    return n`, []string{"Pure function 'f' cannot log"}},
+		{"impure call", `return g(n)`, []string{"Pure function 'f' cannot call non-pure function 'g'"}},
+		{"builtin with side effects", `go.println(n)
+    return n`, []string{"Pure function 'f' cannot call 'go.println' on line 9:5"}},
+		{"pure builtin", `return go.math.abs(n)`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := `
+let counter int >> 0
+let xs int[] >> [1, 2]
+fnc g(n int) >> int {
+    return n
+}
+@pure
+fnc f(n int) >> int {
+    ` + tt.body + `
+}
+`
+			expectErrors(t, src, tt.want)
+		})
+	}
+}
+
+func TestNamedArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"function", `log(greet(punct: "?", name: "Ann"))`, nil},
+		{"method", `log(g.greet(punct: "?", name: "Ann"))`, nil},
+		{"function unknown name", `log(greet(name: "Ann", tone: "!"))`,
+			[]string{"Call to 'greet' on line 13:9: unknown parameter 'tone'"}},
+		{"function name given twice", `log(greet("Ann", name: "Bob"))`,
+			[]string{"Call to 'greet' on line 13:9: parameter 'name' is given more than once"}},
+		{"function missing argument", `log(greet(punct: "?"))`,
+			[]string{"Call to 'greet' on line 13:9: missing argument for parameter 'name'"}},
+		{"method unknown name", `log(g.greet(nme: "Ann"))`,
+			[]string{"Call to 'Greeter.greet' on line 13:9: unknown parameter 'nme'"}},
+		{"method wrong type", `log(g.greet(name: 1))`,
+			[]string{"argument 1 to 'Greeter.greet' expects string, got int"}},
+		{"builtin", `log(go.strings.repeat(s: "a", n: 3))`,
+			[]string{"Call to 'go.strings.repeat' on line 13:9: named arguments need a declared function or method"}},
+		{"len", `log(len(xs: [1]))`,
+			[]string{"Call to 'len' on line 13:9: named arguments need a declared function or method"}},
+		{"function value", `let f fnc(int) >> int >> fnc(n int) >> int {
+        return n
+    }
+    log(f(n: 1))`, []string{"Call to 'f' on line 16:9: named arguments need a declared function or method"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := `
+struct Greeter {
+    prefix string
+}
+fnc Greeter.greet(name string, punct string >> "!") >> string {
+    return this.prefix + name + punct
+}
+fnc greet(name string, punct string >> "!") >> string {
+    return name + punct
+}
+let g Greeter >> { prefix: "Hi " }
+fnc main() {
+    ` + tt.body + `
+}
+`
+			expectErrors(t, src, tt.want)
+		})
+	}
+}
+
+func TestCheckTypes(t *testing.T) {
+	src := `
+fnc double(n int) >> int {
+    return n * 2
+}
+fnc main() {
+    let x int >> double(2)
+    log("x" + "y")
+    double(x)
+}
+`
+	want := []InferredType{
+		{Line: 3, Col: 5, Kind: "return", Type: "int"},
+		{Line: 6, Col: 5, Kind: "let", Name: "x", Type: "int"},
+		{Line: 7, Col: 5, Kind: "log", Type: "string"},
+		{Line: 8, Col: 5, Kind: "expr", Type: "int"},
+	}
+	// Each call collects its own types, even when calls overlap.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		stmts := parse(t, src)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs, types := CheckTypes(stmts)
+			if len(errs) > 0 {
+				t.Errorf("unexpected errors: %v", errs)
+			}
+			if !reflect.DeepEqual(types, want) {
+				t.Errorf("CheckTypes() types = %+v, want %+v", types, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFileWriteArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"writeAll", `let ok bool >> go.file.writeAll("out.txt", "a\nb")`, nil},
+		{"append", `let ok bool >> go.file.append("out.txt", "c\n")`, nil},
+		{"writeAll content", `log(go.file.writeAll("out.txt", 1))`,
+			[]string{"argument 2 to 'go.file.writeAll' expects string, got int on line 2:37"}},
+		{"append path", `log(go.file.append(true, "x"))`,
+			[]string{"argument 1 to 'go.file.append' expects string, got bool on line 2:24"}},
+		{"append count", `log(go.file.append("out.txt"))`,
+			[]string{"Built-in 'go.file.append' expects 2 arguments, got 1"}},
+		{"result type", `let n int >> go.file.writeAll("out.txt", "x")`,
+			[]string{"cannot assign bool to int (variable 'n')"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectErrors(t, "fnc main() {\n    "+tt.body+"\n}\n", tt.want)
+		})
+	}
+}
+
+// inMain wraps body in a main function, so its first line is line 2.
+func inMain(body string) string {
+	return "fnc main() {\n    " + body + "\n}\n"
+}
+
+// errorCase is a program and the errors it should type check with.
+type errorCase struct {
+	name string
+	src  string
+	want []string
+}
+
+func runErrorCases(t *testing.T, tests []errorCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectErrors(t, tt.src, tt.want)
+		})
+	}
+}
+
+func TestAssert(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"condition and message", inMain(`assert 1 + 1 == 2, "math"`), nil},
+		{"condition only", inMain(`assert true`), nil},
+		{"condition type", inMain(`assert 1`),
+			[]string{"Assert condition must be boolean, got int on line 2:5"}},
+		{"message type", inMain(`assert true, 2`),
+			[]string{"Assert message must be string, got int"}},
+	})
+}
+
+func TestIndexedBuiltinResults(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"split element", inMain(`let s string >> go.strings.split("a,b", ",")[0] + "!"`), nil},
+		{"split element as int", inMain(`let n int >> go.strings.split("a,b", ",")[0]`),
+			[]string{"cannot assign string to int (variable 'n')"}},
+		{"array of arrays", inMain(`let grid int[][] >> [[1], [2]]
+    grid[0] >> [3]
+    grid[1] >> 4`), []string{"Type error on line 4:5: cannot assign int to int[][] element"}},
+		{"map of arrays", inMain(`let m :>> map[string] >> int[] { "a": [1] }
+    let n int >> m["a"][0] + 1`), nil},
+		{"array of maps", inMain(`let xs (map[string]int)[] >> [map[string]int{ "a": 1 }, map[string]int{ "b": 2 }]
+    let n int >> xs[0]["a"] + 1
+    xs[1]["c"] >> 3`), nil},
+		{"array of maps element type", inMain(`let xs (map[string]int)[] >> [map[string]int{ "a": 1 }]
+    let s string >> xs[0]["a"]`), []string{"Type error on line 3:5: cannot assign int to string (variable 's')"}},
+		{"array of maps key type", inMain(`let xs (map[string]int)[] >> [map[string]int{ "a": 1 }]
+    log(xs[0][1])`), []string{"Map key type error on line 3:14: expected string, got int"}},
+		{"array of maps value type", inMain(`let xs (map[string]int)[] >> [map[string]int{ "a": 1 }]
+    xs[0]["a"] >> "x"`), []string{"cannot assign string to int (map value)"}},
+		{"string index", inMain(`let xs int[] >> [1, 2]
+    xs["a"] >> 3`), []string{"Array index must be int, got string on line 3:5"}},
+	})
+}
+
+func TestMapMerge(t *testing.T) {
+	const decls = `let a :>> map[string] >> int { "a": 1 }
+    let b :>> map[string] >> string { "b": "x" }
+    `
+	runErrorCases(t, []errorCase{
+		{"spread", inMain(decls + `let c :>> map[string] >> int { ...a, "c": 3 }`), nil},
+		{"merge", inMain(decls + `let c map[string]int >> go.map.merge(a, a)`), nil},
+		{"spread of another type", inMain(decls + `let c :>> map[string] >> int { ...b }`),
+			[]string{"cannot spread map[string]string into map[string]int literal"}},
+		{"merge of different types", inMain(decls + `log(go.map.merge(a, b))`),
+			[]string{"expects both maps to have the same type"}},
+		{"merge count", inMain(decls + `log(go.map.merge(a))`),
+			[]string{"expects 2 arguments, got 1"}},
+		{"merge of non-maps", inMain(decls + `log(go.map.merge(1, 2))`),
+			[]string{"expects map arguments, got int"}},
+	})
+}
+
+func TestAggregateArguments(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"int sum", inMain(`let n int >> go.math.sum([1, 2])`), nil},
+		{"float minOf", inMain(`let f float >> go.math.minOf([1.5, 2.5])`), nil},
+		{"avg of ints", inMain(`let f float >> go.math.avg([1, 2])`), nil},
+		{"float sum as int", inMain(`let n int >> go.math.sum([1.5])`),
+			[]string{"cannot assign float to int (variable 'n')"}},
+		{"count", inMain(`log(go.math.sum([1, 2], [3]))`),
+			[]string{"Built-in 'go.math.sum' expects 1 argument, got 2"}},
+		{"strings", inMain(`log(go.math.avg(["a"]))`),
+			[]string{"Built-in 'go.math.avg' expects an int[] or float[] argument, got string[]"}},
+	})
+}
+
+func TestInitFunctions(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", "fnc init() {\n    log(1)\n}\n", nil},
+		{"parameters", "fnc init(n int) {\n}\n",
+			[]string{"Function 'init' must take no parameters and return void on line 1:1"}},
+		{"return type", "fnc init() >> int {\n    return 1\n}\n",
+			[]string{"Function 'init' must take no parameters and return void on line 1:1"}},
+		{"method named init", "struct S {\n    n int\n}\nfnc S.init(n int) {\n}\n", nil},
+	})
+}
+
+func TestWith(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"open", inMain(`with f >> go.file.open("x.txt") {
+        log(go.file.read(f))
+    }`), nil},
+		{"create", inMain(`with f >> go.file.create("x.txt") {
+        go.file.write(f, "x")
+    }`), nil},
+		{"not a resource", inMain(`with f >> 1 {
+        log(f)
+    }`), []string{"With statement on line 2:5 needs a resource opened by go.file.open or go.file.create"}},
+		{"scoped to the block", inMain(`with f >> go.file.open("x.txt") {
+    }
+    log(f)`), []string{"Error on line 4:5: log expression uses an undeclared"}},
+	})
+}
+
+func TestCompoundAssignmentTypes(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"numbers", inMain(`let n int >> 1
+    n += 2
+    n *= n`), nil},
+		{"string concatenation", inMain(`let s string >> "a"
+    s += "b"`), nil},
+		{"array element", inMain(`let xs float[] >> [1.5]
+    xs[0] /= 2.0`), nil},
+		{"string minus", inMain(`let s string >> "a"
+    s -= 1`), []string{"operator '-=' not defined for string and int"}},
+	})
+}
+
+func TestVoidArguments(t *testing.T) {
+	const decls = `fnc nothing() {
+}
+fnc one() >> int {
+    return 1
+}
+fnc take(n int) >> int {
+    return n
+}
+`
+	runErrorCases(t, []errorCase{
+		{"value", decls + inMain(`log(take(one()))`), nil},
+		{"function", decls + inMain(`log(take(nothing()))`),
+			[]string{"cannot pass result of void function 'nothing' as argument 1 to 'take' on line 10:9"}},
+		{"builtin", decls + inMain(`log(go.strings.repeat("a", nothing()))`),
+			[]string{"argument 2 to 'go.strings.repeat' expects int, got void on line 10:32"}},
+	})
+}
+
+func TestUndeclaredReportedOnce(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"assignment", inMain(`missing >> 1`),
+			[]string{"Assignment to undeclared variable 'missing'"}},
+		{"initialization", inMain(`let n int >> ghost + 1`),
+			[]string{"uses an undeclared"}},
+		{"repeated uses", inMain(`log(ghost)
+    let n int >> ghost
+    log(ghost + 1)`), []string{"log expression uses an undeclared"}},
+		{"distinct names", inMain(`log(ghost)
+    log(phantom)`), []string{"log expression uses an undeclared", "log expression uses an undeclared"}},
+		{"assigned value", inMain(`let y int >> 0
+    y >> ghost`), []string{"assignment of variable 'y' uses an undeclared"}},
+		{"expression statement", inMain(`ghost + 1`),
+			[]string{"Error on line 2:5: expression uses an undeclared"}},
+	})
+}
+
+func TestScanArguments(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let parts any[] >> go.strings.scan("1 2", "%d %d")`), nil},
+		{"arguments", inMain(`log(go.strings.scan(1, "%d"))`),
+			[]string{"Built-in 'go.strings.scan' expects a string and a format string, got int and string"}},
+		{"count", inMain(`log(go.strings.scan("1"))`),
+			[]string{"Built-in 'go.strings.scan' expects 2 arguments, got 1"}},
+	})
+}
+
+func TestOverrides(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", `@override
+fnc go.strings.length(s string) >> int {
+    return go.strings.length(s) * 2
+}
+` + inMain(`let n int >> go.strings.length("ab")`), nil},
+		{"not a builtin", "@override\nfnc go.nothing() {\n}\n",
+			[]string{"Cannot override 'go.nothing' on line 1:1: not a builtin"}},
+		{"return type", "@override\nfnc go.strings.length(s string) >> string {\n    return s\n}\n",
+			[]string{"Override of 'go.strings.length' must return int, not string on line 1:1"}},
+	})
+}
+
+func TestConst(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"constant expressions", "const limit int >> 10 * 2\nconst name string >> \"tox\"\n", nil},
+		{"local", inMain(`const limit int >> 10
+    log(limit)`), nil},
+		{"compound assignment", "const limit int >> 10\n" + inMain(`limit += 1`),
+			[]string{"Cannot assign to const 'limit'"}},
+		{"non-constant value", "fnc f() >> int {\n    return 1\n}\nconst limit int >> f()\n",
+			[]string{"const 'limit' must be initialized with a constant expression"}},
+		{"element", "const limits int[] >> [1, 2]\n" + inMain(`limits[0] >> 99`),
+			[]string{"Cannot modify const 'limits' on line 3:5"}},
+		{"compound element", "const limits int[] >> [1, 2]\n" + inMain(`limits[1] += 1`),
+			[]string{"Cannot modify const 'limits' on line 3:5"}},
+		{"nested element", "const grid int[][] >> [[1], [2]]\n" + inMain(`grid[0][0] >> 5`),
+			[]string{"Cannot modify const 'grid'"}},
+		{"map value", "const sizes :>> map[string] >> int { \"a\": 1 }\n" + inMain(`sizes["a"] >> 2`),
+			[]string{"Cannot modify const 'sizes' on line 3:5"}},
+		{"field", "struct User {\n    name string\n}\nconst admin User >> User{ name: \"root\" }\n" + inMain(`admin.name >> "me"`),
+			[]string{"Cannot modify const 'admin' on line 6:5"}},
+		{"parameter shadowing a const", "const limits int[] >> [1, 2]\nfnc f(limits int[]) {\n    limits[0] >> 99\n}\n", nil},
+	})
+}
+
+func TestUnknownReturnTypes(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"declared later", "fnc f() >> User[] {\n    return []\n}\nstruct User {\n    name string\n}\n", nil},
+		{"array of an unknown struct", "fnc f() >> Ghost[] {\n    return []\n}\n",
+			[]string{"Unknown type 'Ghost' in return type 'Ghost[]' for function 'f'"}},
+		{"map of an unknown struct", "fnc f(m map[string]Phantom) >> map[string]Phantom {\n    return m\n}\n",
+			[]string{"Unknown type 'Phantom' in return type 'map[string]Phantom' for function 'f'"}},
+	})
+}
+
+func TestTernaryTypes(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let n int >> 1 > 0 ? 1 : 2`), nil},
+		{"int and float", inMain(`let f float >> true ? 1 : 2.5`),
+			[]string{"Type error on line 2:25: branches of '?' have different types int and float"}},
+		{"condition", inMain(`log(1 ? "a" : "b")`),
+			[]string{"condition of '?' must be bool, got int"}},
+		{"branches", inMain(`log(true ? 1 : "b")`),
+			[]string{"branches of '?' have different types int and string"}},
+	})
+}
+
+func TestFormatSpecs(t *testing.T) {
+	const decls = `struct User {
+    age int
+}
+let price float >> 9.5
+let count int >> 3
+let name string >> "tox"
+let ok bool >> true
+let u User >> User{ age: 30 }
+`
+	runErrorCases(t, []errorCase{
+		{"valid", decls + `log("<%price:.2f%> <%count:d%> <%count:5d%> <%name:-10s%> <%ok:t%> <%u.age:03d%>")`, nil},
+		{"int with a float verb", decls + `log("<%count:.1f%>")`, nil},
+		{"any value with s", decls + `log("<%price:s%> <%u:v%>")`, nil},
+		{"undeclared name", decls + `log("<%ghost:d%>")`, nil},
+		{"raw string", decls + "log(`<%price:q%>`)", nil},
+		{"malformed", decls + `log("<%price:q%>")`,
+			[]string{"Invalid format spec 'q' for 'price' on line 9:5"}},
+		{"float with d", decls + `log("<%price:d%>")`,
+			[]string{"Format spec 'd' does not fit float 'price' on line 9:5"}},
+		{"string with f", decls + `let s string >> "<%name:.2f%>"`,
+			[]string{"Format spec '.2f' does not fit string 'name'"}},
+		{"int with t", decls + `log("<%u.age:t%>")`,
+			[]string{"Format spec 't' does not fit int 'u.age'"}},
+		{"in a function literal", decls + inMain(`let f fnc(int) >> string >> fnc(n int) >> string {
+        return "<%n:s%> <%n:t%>"
+    }`), []string{"Format spec 't' does not fit int 'n'"}},
+	})
+}
+
+func TestMatchTypes(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let n int >> 1
+    match n {
+        case 1 >> {
+            log("one")
+        }
+        default >> {
+            log("other")
+        }
+    }`), nil},
+		{"subject", inMain(`let xs int[] >> [1]
+    match xs {
+        default >> {
+        }
+    }`), []string{"Match subject must be int, float, string or bool, got int[]"}},
+		{"case value", inMain(`let n int >> 1
+    match n {
+        case "one" >> {
+        }
+    }`), []string{"case value of type string in match on int"}},
+	})
+}
+
+func TestFunctionLiterals(t *testing.T) {
+	const apply = `fnc apply(f fnc(int) >> int, n int) >> int {
+    return f(n)
+}
+`
+	const decl = `let f fnc(int) >> int >> fnc(n int) >> int {
+        return n * 2
+    }
+    `
+	runErrorCases(t, []errorCase{
+		{"call", inMain(decl + `let n int >> f(2)`), nil},
+		{"argument type", inMain(decl + `log(f("x"))`),
+			[]string{"argument 1 to 'f' expects int, got string on line 5:11"}},
+		{"body", inMain(`let g fnc >> fnc(s string) >> int {
+        return s
+    }`), []string{"Return type mismatch"}},
+		{"body in a log argument", apply + inMain(`log(apply(fnc(x int) >> int { return "oops" }, 2))`),
+			[]string{"Return type mismatch on line 5:35: expected int, got string"}},
+		{"body in a binary expression", apply + inMain(`let r int >> apply(fnc(x int) >> int { return x + undefinedThing }, 1) + 1`),
+			[]string{"Return type mismatch on line 5:44"}},
+		{"body in an if condition", apply + inMain(`if apply(fnc(x int) >> int { return true }, 1) > 0 {
+        log("yes")
+    }`), []string{"Return type mismatch on line 5:34: expected int, got bool"}},
+		{"struct field", `struct Config {
+    onDone fnc() >> int
+}
+` + inMain(`let cfg Config >> Config{ onDone: fnc() >> int {
+        return 1
+    } }
+    let n int >> cfg.onDone()`), nil},
+	})
+}
+
+func TestNestedFieldAssignment(t *testing.T) {
+	const decls = `struct Address {
+    city string
+}
+struct User {
+    name string
+    addr Address
+}
+`
+	runErrorCases(t, []errorCase{
+		{"valid", decls + inMain(`let u User >> { name: "Ann", addr: Address{ city: "Oslo" } }
+    u.addr.city >> "Bergen"`), nil},
+		{"unknown field", decls + inMain(`let u User >> { name: "Ann", addr: Address{ city: "Oslo" } }
+    u.addr.zip >> "0150"`), []string{"Assignment to unknown field or variable 'u.addr.zip'"}},
+		{"field type", decls + inMain(`let u User >> { name: "Ann", addr: Address{ city: "Oslo" } }
+    u.addr.city >> 1`), []string{"cannot assign int to string (field 'u.addr.city')"}},
+		{"undeclared value", decls + inMain(`let u User >> { name: "Ann", addr: Address{ city: "Oslo" } }
+    u.name >> ghost`), []string{"assignment of 'u.name' uses an undeclared"}},
+	})
+}
+
+func TestStructLiteralFields(t *testing.T) {
+	const decls = `struct User {
+    name string
+    age int
+    tags string[]
+}
+`
+	runErrorCases(t, []errorCase{
+		{"valid", decls + inMain(`let u User >> User{ name: "Ann", age: 30, tags: ["a"] }`), nil},
+		{"empty array field", decls + inMain(`let u User >> User{ name: "Ann", age: 30, tags: [] }`), nil},
+		{"missing field", decls + inMain(`let u User >> User{ name: "Ann", tags: [] }`),
+			[]string{"Missing field 'age' in struct literal for 'User' on line 7:19"}},
+		{"unknown field", decls + inMain(`let u User >> User{ name: "Ann", age: 30, tags: [], email: "a@b" }`),
+			[]string{"Unknown field 'email' in struct literal for 'User' on line 7:19"}},
+		{"field type", decls + inMain(`let u User >> User{ name: 1, age: 30, tags: [] }`),
+			[]string{"Field 'name' expects string, got int on line 7:31"}},
+		{"element type", decls + inMain(`let u User >> User{ name: "Ann", age: 30, tags: [1] }`),
+			[]string{"Field 'tags' expects string[], got int[]"}},
+	})
+}
+
+func TestStructLiteralTypes(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"declared later", inMain(`let p Point >> Point{ x: 1 }`) + "struct Point {\n    x int\n}\n", nil},
+		{"unknown struct", inMain(`log(Ghost{ x: 1 })`),
+			[]string{"Unknown struct type 'Ghost' on line 2:9"}},
+		{"variable", inMain(`let n int >> 1
+    log(n{ x: 1 })`), []string{"Unknown struct type 'n' on line 3:9 ('n' is a variable: is the '{' meant to start a block or map?)"}},
+	})
+}
+
+func TestTypeAliasErrors(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", "type UserId int\ntype Ids UserId[]\n" + inMain(`let ids Ids >> [1, 2]
+    let id UserId >> ids[0] + 1`), nil},
+		{"cycle", "type A B\ntype B A\n", []string{"Cyclic type alias 'A' on line 1:1", "Cyclic type alias 'B' on line 2:1"}},
+		{"unknown type", "type C Ghost\n", []string{"Unknown type 'Ghost' in type alias 'C'"}},
+		{"struct and alias", "struct D {\n    n int\n}\ntype D int\n",
+			[]string{"'D' is declared as both a struct and a type alias"}},
+	})
+}
+
+func TestDefaultParameterErrors(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", "fnc f(a int, b int >> 2) >> int {\n    return a + b\n}\n" + inMain(`log(f(1))
+    log(f(1, 3))`), nil},
+		{"default type", "fnc f(a int, b int >> \"x\") {\n}\n",
+			[]string{"default value of parameter 'b' is string, not int"}},
+		{"non-constant default", "let n int >> 1\nfnc f(a int, b int >> n) {\n}\n",
+			[]string{"must be a constant expression"}},
+		{"too few", "fnc f(a int, b int >> 2) {\n}\n" + inMain(`f()`),
+			[]string{"Function 'f' expects 1 to 2 arguments, got 0"}},
+		{"method too many", "struct S {\n    n int\n}\nfnc S.m(a int, b int >> 2) {\n}\nlet s S >> S{ n: 1 }\n" + inMain(`s.m(1, 2, 3)`),
+			[]string{"Method 'S.m' expects 1 to 2 arguments, got 3"}},
+	})
+}
+
+func TestVariadicArguments(t *testing.T) {
+	const decl = "fnc sum(first int, rest int...) >> int {\n    return first + go.math.sum(rest)\n}\n"
+	runErrorCases(t, []errorCase{
+		{"valid", decl + inMain(`log(sum(1))
+    log(sum(1, 2, 3))`), nil},
+		{"too few", decl + inMain(`log(sum())`),
+			[]string{"Function 'sum' expects at least 1 arguments, got 0"}},
+		{"rest type", decl + inMain(`log(sum(1, 2, "x"))`),
+			[]string{"argument 3 to 'sum' expects int, got string"}},
+	})
+}
+
+func TestMissingReturns(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"if and else", "fnc f(n int) >> int {\n    if n > 0 {\n        return 1\n    } else {\n        return 2\n    }\n}\n", nil},
+		{"match with default", "fnc f(n int) >> int {\n    match n {\n        case 1 >> {\n            return 1\n        }\n        default >> {\n            return 0\n        }\n    }\n}\n", nil},
+		{"void", "fnc f(n int) {\n    if n > 0 {\n        log(n)\n    }\n}\n", nil},
+		{"if without else", "fnc f(n int) >> int {\n    if n > 0 {\n        return 1\n    }\n}\n",
+			[]string{"Function 'f' may not return a value on all paths (line 1:1)"}},
+		{"loop", "fnc f(n int) >> int {\n    while n > 0 {\n        return n\n    }\n}\n",
+			[]string{"Function 'f' may not return a value on all paths (line 1:1)"}},
+		{"function literal", inMain(`let g fnc(int) >> int >> fnc(n int) >> int {
+        log(n)
+    }`), []string{"Function literal may not return a value on all paths (line 2:30)"}},
+	})
+}
+
+func TestUnreachableCode(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"last statement", "fnc f() >> int {\n    log(1)\n    return 1\n}\n", nil},
+		{"after return", "fnc f() >> int {\n    return 1\n    log(2)\n}\n",
+			[]string{"Unreachable statement on line 3:5"}},
+		{"after break", inMain(`while true {
+        break
+        log(1)
+    }`), []string{"Unreachable statement on line 4:9"}},
+		{"after continue", inMain(`for let i int >> 0; i < 3; i += 1 {
+        continue
+        log(i)
+    }`), []string{"Unreachable statement on line 4:9"}},
+		{"only the first", "fnc f() >> int {\n    return 1\n    log(2)\n    log(3)\n}\n",
+			[]string{"Unreachable statement on line 3:5"}},
+	})
+}
+
+func TestMapIndexTypes(t *testing.T) {
+	const decl = `let m :>> map[string] >> int { "a": 1 }
+    `
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(decl + `m["b"] >> 2
+    let n int >> m["a"] + 1`), nil},
+		{"key type", inMain(decl + `m[1] >> 2`),
+			[]string{"Map key type error on line 3:6: expected string, got int"}},
+		{"value type", inMain(decl + `m["b"] >> "x"`),
+			[]string{"cannot assign string to int (map value)"}},
+		{"read type", inMain(decl + `let s string >> m["a"]`),
+			[]string{"cannot assign int to string (variable 's')"}},
+		{"undeclared target", inMain(`ghost["a"] >> 1`),
+			[]string{"assignment target uses an undeclared"}},
+		{"undeclared value", inMain(decl + `m["b"] >> phantom`),
+			[]string{"assignment uses an undeclared"}},
+	})
+}
+
+func TestLenArguments(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"string", inMain(`let s string >> "héllo"
+    let n int >> len(s) + len("é")`), nil},
+		{"array", inMain(`let n int >> len([1, 2])`), nil},
+		{"int", inMain(`let n int >> len(5)`),
+			[]string{"Built-in 'len' expects an array or string argument, got int on line 2:18"}},
+		{"map", inMain(`let m :>> map[string] >> int { "a": 1 }
+    let n int >> len(m)`), []string{"Built-in 'len' expects an array or string argument, got map[string]int on line 3:18"}},
+		{"two arguments", inMain(`let n int >> len("a", "b")`),
+			[]string{"Built-in 'len' expects 1 argument, got 2 on line 2:18"}},
+	})
+}
+
+func TestMixedArrayLiterals(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"same type", inMain(`let xs int[] >> [1, 2, 3]`), nil},
+		{"nested", inMain(`let xs int[][] >> [[1], [2, 3]]`), nil},
+		{"mixed", inMain(`let xs int[] >> [1, "a"]`),
+			[]string{"Array literal has mixed element types: int and string on line 2:21"}},
+		{"mixed in a call", inMain(`log(len([true, 1.5]))`),
+			[]string{"Array literal has mixed element types: bool and float on line 2:13"}},
+		{"untyped len argument", inMain(`log(len(ghost))`),
+			[]string{"Built-in 'len' expects an array or string argument, got  on line 2:9"}},
+	})
+}
+
+func TestInputConversion(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let n int >> go.conv.toInt(input("n: "))
+    let f float >> go.conv.toFloat(input())
+    let b bool >> go.conv.toBool(input())`), nil},
+		{"input is a string", inMain(`let n int >> input()`),
+			[]string{"cannot assign string to int (variable 'n')"}},
+		{"argument type", inMain(`log(go.conv.toInt(1))`),
+			[]string{"Built-in 'go.conv.toInt' expects a string argument, got int"}},
+	})
+}
+
+func TestLogicalNot(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"not false", "let x bool >> !false\n", nil},
+		{"not a variable", "let ok bool >> true\nlet x bool >> !ok\nlet y bool >> not !ok\n", nil},
+		{"not an int", "let x bool >> !1\n",
+			[]string{"Type error on line 1:15: logical not expects bool, got int"}},
+		{"not a string", inMain(`if not "x" {
+        log(1)
+    }`), []string{"logical not expects bool, got string"}},
+	})
+}
+
+func TestOperatorMethods(t *testing.T) {
+	const decls = `struct Vec {
+    x int
+}
+fnc Vec.add(o Vec) >> Vec {
+    return Vec{ x: this.x + o.x }
+}
+`
+	runErrorCases(t, []errorCase{
+		{"valid", decls + `fnc Vec.eq(o Vec) >> bool {
+    return this.x == o.x
+}
+let a Vec >> Vec{ x: 1 }
+let b Vec >> a + a
+let same bool >> a != b
+`, nil},
+		{"eq not returning bool", decls + `fnc Vec.eq(o Vec) >> int {
+    return this.x - o.x
+}
+`, []string{"Method 'Vec.eq' overloads == and must return bool, not int on line 7:1"}},
+		{"eq of another struct", decls + `struct Other {
+    y int
+}
+fnc Other.eq(o Other) >> bool {
+    return this.y == o.y
+}
+fnc eq(a int) >> int {
+    return a
+}
+`, nil},
+	})
+}
+
+func TestConditionTypes(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let n int >> 1
+    if n > 0 and n < 10 {
+        log(n)
+    } elif not (n == 0) {
+        log(0)
+    }`), nil},
+		{"if", inMain(`if 1 {
+        log(1)
+    }`), []string{"If condition must be boolean, got int on line 2:8"}},
+		{"elif", inMain(`if true {
+        log(1)
+    } elif "x" {
+        log(2)
+    }`), []string{"If condition must be boolean, got string on line 4:12"}},
+		{"undeclared", inMain(`if ghost {
+        log(1)
+    }`), []string{"if condition uses an undeclared"}},
+	})
+}
+
+func TestBlockScopes(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"outer variable", inMain(`let n int >> 1
+    if true {
+        n >> 2
+    }
+    log(n)`), nil},
+		{"if", inMain(`if true {
+        let inner int >> 1
+    }
+    log(inner)`), []string{"log expression uses an undeclared"}},
+		{"while", inMain(`while false {
+        let step int >> 1
+    }
+    step >> 2`), []string{"Assignment to undeclared variable 'step'"}},
+		{"for", inMain(`for let i int >> 0; i < 3; i += 1 {
+    }
+    log(i)`), []string{"log expression uses an undeclared"}},
+		{"redeclared in a block", inMain(`let n int >> 1
+    if true {
+        let n string >> "x"
+        log(n)
+    }
+    let m int >> n`), nil},
+	})
+}
+
+func TestNestedFunctionScopes(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"mutual recursion", inMain(`let base int >> 10
+    fnc isEven(n int) >> bool {
+        if n == 0 {
+            return true
+        }
+        return isOdd(n - 1)
+    }
+    fnc isOdd(n int) >> bool {
+        if n == 0 {
+            return false
+        }
+        return isEven(n - 1)
+    }
+    fnc shifted(n int) >> int {
+        return n + base
+    }
+    log(isEven(shifted(4)))`), nil},
+		{"argument types", inMain(`fnc twice(n int) >> int {
+        return n * 2
+    }
+    log(twice("x"))`), []string{"argument 1 to 'twice' expects int, got string on line 5:15"}},
+		{"called from another function", "fnc outer() {\n    fnc helper() >> int {\n        return 1\n    }\n}\n" + inMain(`log(helper())`),
+			[]string{"Unknown function 'helper' on line 7:9", "log expression uses an undeclared"}},
+		{"called outside its block", inMain(`if true {
+        fnc helper() >> int {
+            return 1
+        }
+    }
+    let x int >> helper()`), []string{"Unknown function 'helper' on line 7:18", "initialization of variable 'x' uses an undeclared"}},
+	})
+}
+
+func TestMathArguments(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let n int >> go.math.abs(-1)
+    let f float >> go.math.abs(-1.5)
+    let m int >> go.math.max(1, 2)
+    let p float >> go.math.pow(2, 3)
+    let r int >> go.math.floor(2.5)`), nil},
+		{"promotion", inMain(`let n int >> go.math.max(1, 2.5)`),
+			[]string{"cannot assign float to int (variable 'n')"}},
+		{"abs of a string", inMain(`log(go.math.abs("a"))`),
+			[]string{"Built-in 'go.math.abs' expects int or float arguments, got string on line 2:21"}},
+		{"abs of a string in a let", inMain(`let n int >> go.math.abs("a")`),
+			[]string{"Built-in 'go.math.abs' expects int or float arguments, got string on line 2:30"}},
+		{"abs of an undeclared name", inMain(`log(go.math.abs(ghost))`),
+			[]string{"Built-in 'go.math.abs' expects int or float arguments", "log expression uses an undeclared"}},
+		{"count", inMain(`log(go.math.pow(2))`),
+			[]string{"Built-in 'go.math.pow' expects 2 arguments, got 1"}},
+	})
+}
+
+func TestArrayArguments(t *testing.T) {
+	const decl = `let xs int[] >> [1, 2]
+    `
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(decl + `xs >> go.array.push(xs, 3)
+    let last int >> go.array.pop(xs)
+    let all int[] >> go.array.append(xs, [4])
+    let has bool >> go.array.contains(xs, 1)`), nil},
+		{"push element type", inMain(decl + `log(go.array.push(xs, "a"))`),
+			[]string{"Built-in 'go.array.push' expects a value of type int, got string"}},
+		{"pop of a non-array", inMain(`log(go.array.pop(1))`),
+			[]string{"Built-in 'go.array.pop' expects an array argument, got int"}},
+		{"contains in a non-array", inMain(`log(go.array.contains("abc", "a"))`),
+			[]string{"Built-in 'go.array.contains' expects an array as its first argument, got string"}},
+		{"append of different types", inMain(decl + `log(go.array.append(xs, ["a"]))`),
+			[]string{"Built-in 'go.array.append' expects both arrays to have the same type"}},
+		{"append to a non-array", inMain(`log(go.array.append(1, [2]))`),
+			[]string{"Built-in 'go.array.append' expects array arguments, got int"}},
+		{"result type", inMain(decl + `let s string >> go.array.pop(xs)`),
+			[]string{"cannot assign int to string (variable 's')"}},
+	})
+}
+
+func TestSortArguments(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let xs int[] >> go.array.sort([3, 1])
+    let ys int[] >> go.array.sortBy(xs, fnc(a int, b int) >> bool {
+        return a > b
+    })`), nil},
+		{"unordered elements", inMain(`log(go.array.sort([true, false]))`),
+			[]string{"Built-in 'go.array.sort' expects an int[], float[] or string[] argument, got bool[]"}},
+		{"comparator type", inMain(`log(go.array.sortBy([1], fnc(a string, b string) >> bool {
+        return a < b
+    }))`), []string{"Built-in 'go.array.sortBy' expects a comparator of type fnc(int,int)>>bool, got fnc(string,string)>>bool"}},
+	})
+}
+
+func TestStringArguments(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let s string >> go.strings.replace("a-b", "-", "+")
+    let i int >> go.strings.indexOf(s, "+")
+    let r string >> go.strings.join(["a", go.strings.repeat("b", 2)], ",")`), nil},
+		{"replace count", inMain(`log(go.strings.replace("a", "b"))`),
+			[]string{"Built-in 'go.strings.replace' expects 3 arguments, got 2"}},
+		{"repeat count type", inMain(`log(go.strings.repeat("a", "b"))`),
+			[]string{"argument 2 to 'go.strings.repeat' expects int, got string"}},
+		{"join element type", inMain(`log(go.strings.join([1, 2], ","))`),
+			[]string{"argument 1 to 'go.strings.join' expects string[], got int[]"}},
+	})
+}
+
+func TestPrefixArguments(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let ok bool >> go.strings.hasPrefix("ab", "a") and go.strings.hasSuffix("ab", "b")
+    let n int >> go.strings.length("ab") + 1`), nil},
+		{"hasPrefix count", inMain(`log(go.strings.hasPrefix("a"))`),
+			[]string{"Built-in 'go.strings.hasPrefix' expects 2 arguments, got 1"}},
+		{"length count", inMain(`log(go.strings.length("a", "b"))`),
+			[]string{"Built-in 'go.strings.length' expects 1 argument, got 2"}},
+		{"hasSuffix type", inMain(`log(go.strings.hasSuffix("a", 1))`),
+			[]string{"argument 2 to 'go.strings.hasSuffix' expects string, got int"}},
+	})
+}
+
+func TestHTTPArguments(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let resp map[string]any >> go.http.post("http://localhost", "{}", "application/json")
+    log(resp["status"])`), nil},
+		{"get url type", inMain(`log(go.http.get(1))`),
+			[]string{"argument 1 to 'go.http.get' expects string, got int"}},
+		{"post count", inMain(`log(go.http.post("http://localhost", "{}"))`),
+			[]string{"Built-in 'go.http.post' expects 3 arguments, got 2"}},
+	})
+}
+
+func TestTimeArguments(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let s string >> go.time.format(go.time.unix(), "2006-01-02")
+    let ms int >> go.time.unixMillis()`), nil},
+		{"format time type", inMain(`log(go.time.format("now", "2006"))`),
+			[]string{"argument 1 to 'go.time.format' expects int, got string"}},
+	})
+}
+
+func TestMapArguments(t *testing.T) {
+	const decl = `let m :>> map[string] >> int { "a": 1 }
+    `
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(decl + `let keys string[] >> go.map.keys(m)
+    let values int[] >> go.map.values(m)
+    let ok bool >> go.map.has(m, "a")
+    go.map.delete(m, "missing")`), nil},
+		{"has key type", inMain(decl + `log(go.map.has(m, 1))`),
+			[]string{"Built-in 'go.map.has' expects a key of type string, got int"}},
+		{"keys of a non-map", inMain(`log(go.map.keys(1))`),
+			[]string{"Built-in 'go.map.keys' expects a map argument, got int"}},
+		{"delete from a non-map", inMain(`go.map.delete([1], 0)`),
+			[]string{"Built-in 'go.map.delete' expects a map as its first argument, got int[]"}},
+		{"keys type", inMain(decl + `let keys int[] >> go.map.keys(m)`),
+			[]string{"cannot assign string[] to int[] (variable 'keys')"}},
+	})
+}
+
+func TestDirListArguments(t *testing.T) {
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(`let names string[] >> go.dir.list(".")
+    let details (map[string]any)[] >> go.dir.listDetailed(".")`), nil},
+		{"list result", inMain(`let s string >> go.dir.list(".")`),
+			[]string{"cannot assign string[] to string (variable 's')"}},
+		{"list path type", inMain(`log(go.dir.list(1))`),
+			[]string{"argument 1 to 'go.dir.list' expects string, got int"}},
+	})
+}
+
+func TestFunctionTypeChecks(t *testing.T) {
+	const decls = `fnc apply(f fnc(int) >> int, n int) >> int {
+    return f(n)
+}
+fnc double(n int) >> int {
+    return n * 2
+}
+fnc upper(s string) >> string {
+    return go.strings.toUpper(s)
+}
+`
+	runErrorCases(t, []errorCase{
+		{"named function", decls + inMain(`log(apply(double, 2))`), nil},
+		{"function literal", decls + inMain(`log(apply(fnc(n int) >> int {
+        return n + 1
+    }, 2))`), nil},
+		{"wrong function type", decls + inMain(`log(apply(upper, 2))`),
+			[]string{"argument 1 to 'apply' expects fnc(int)>>int, got fnc(string)>>string"}},
+		{"call count", decls + inMain(`let f fnc(int) >> int >> double
+    log(f(1, 2))`), []string{"Function 'f' expects 1 arguments, got 2"}},
+		{"call result", decls + inMain(`let f fnc(int) >> int >> double
+    let s string >> f(1)`), []string{"cannot assign int to string (variable 's')"}},
+	})
+}
+
+func TestNestedArrayTypes(t *testing.T) {
+	const decl = `let g int[][] >> [[1, 2], [3]]
+    `
+	runErrorCases(t, []errorCase{
+		{"valid", inMain(decl + `let n int >> g[0][1]
+    let row int[] >> g[1]
+    g[1][0] >> 4`), nil},
+		{"element type", inMain(decl + `let s string >> g[0][0]`),
+			[]string{"cannot assign int to string (variable 's')"}},
+		{"row type", inMain(decl + `let n int >> g[0]`),
+			[]string{"cannot assign int[] to int (variable 'n')"}},
+		{"inner index type", inMain(decl + `log(g[0]["a"])`),
+			[]string{"Array index must be int, got string"}},
+	})
+}